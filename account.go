@@ -14,29 +14,51 @@
 package vault
 
 import (
+	"context"
 	"encoding/json"
+	"sort"
 
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
+	wtypes "github.com/wealdtech/go-eth2-wallet-types/v2"
 )
 
 // StoreAccount stores an account.  It will fail if it cannot store the data.
 // Note this will overwrite an existing account with the same ID.  It will not, however, allow multiple accounts with the same
 // name to co-exist in the same wallet.
-func (s *Store) StoreAccount(walletID uuid.UUID, accountID uuid.UUID, data []byte) error {
-	s.Authorize()
+func (s *Store) StoreAccount(walletID uuid.UUID, accountID uuid.UUID, data []byte) (err error) {
+	defer func() { s.audit("store account", walletID.String(), "", accountID.String(), err) }()
 
-	client := s.client
+	if err := s.Authorize(); err != nil {
+		return err
+	}
+
+	unlock, err := s.lockWallet(walletID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.storeAccount(walletID, accountID, data)
+}
+
+// storeAccount stores an account as per StoreAccount.  The caller must hold the wallet's lock.
+func (s *Store) storeAccount(walletID uuid.UUID, accountID uuid.UUID, data []byte) error {
+	if s.validatePayloads {
+		if err := validatePayload(data, accountID, ""); err != nil {
+			return err
+		}
+	}
 
 	// Ensure the wallet exists
-	_, err := s.RetrieveWalletByID(walletID)
+	walletData, err := s.retrieveWalletByID(walletID)
 
 	if err != nil {
 		return errors.New("unknown wallet")
 	}
 
 	// See if an account with this name already exists
-	existingAccount, err := s.RetrieveAccount(walletID, accountID)
+	existingAccount, err := s.retrieveAccount(walletID, accountID)
 	if err == nil {
 		// It does; they need to have the same ID for us to overwrite it
 		info := &struct {
@@ -55,90 +77,256 @@ func (s *Store) StoreAccount(walletID uuid.UUID, accountID uuid.UUID, data []byt
 
 	path := s.accountPath(walletID.String(), accountID.String())
 
-	_, err = client.Logical().WriteBytes(path, data)
+	encryptedData, err := s.encryptIfRequired(walletID, data)
+	if err != nil {
+		return err
+	}
+
+	err = s.kvWrite(path, encryptedData)
 
 	if err != nil {
+		if _, isConflict := err.(*ConflictError); isConflict {
+			// Ensure that the next retrieval obtains the latest version from Vault.
+			s.cache.remove(accountCacheKey(walletID, accountID))
+			s.evictCachedAccount(walletID, accountID)
+			return err
+		}
 		return errors.Wrap(err, "failed to store key")
 	}
 
-	return nil
+	s.cache.remove(accountCacheKey(walletID, accountID))
+
+	// The account is already in Vault, so failing to cache it is not fatal; any stale cached copy is evicted so that the
+	// account is fetched from Vault next time.
+	if err := s.cacheAccount(walletID, accountID, data); err != nil {
+		s.log.Warn("Failed to cache account", "wallet", walletID, "account", accountID, "error", err)
+		s.evictCachedAccount(walletID, accountID)
+	}
+
+	if err := s.tagObject(path, map[string]string{
+		tagWalletID:    walletID.String(),
+		tagWalletName:  nameOf(walletData),
+		tagAccountName: nameOf(data),
+	}); err != nil {
+		return err
+	}
+
+	return s.mirror(func(secondary wtypes.Store) error {
+		return secondary.StoreAccount(walletID, accountID, data)
+	})
 }
 
 // RetrieveAccount retrieves account-level data.  It will fail if it cannot retrieve the data.
-func (s *Store) RetrieveAccount(walletID uuid.UUID, accountID uuid.UUID) ([]byte, error) {
-	s.Authorize()
+// If a cache directory is configured the cached copy of the account is returned in preference to that held in Vault.
+func (s *Store) RetrieveAccount(walletID uuid.UUID, accountID uuid.UUID) (_ []byte, err error) {
+	defer func() { s.audit("retrieve account", walletID.String(), "", accountID.String(), err) }()
+
+	return s.retrieveAccount(walletID, accountID)
+}
+
+// retrieveAccount retrieves account-level data as per RetrieveAccount, without auditing the retrieval.  It is used by
+// operations that audit themselves.
+func (s *Store) retrieveAccount(walletID uuid.UUID, accountID uuid.UUID) ([]byte, error) {
+	if data, exists := s.cache.get(accountCacheKey(walletID, accountID)); exists {
+		return data, nil
+	}
+	if data, exists := s.cachedAccount(walletID, accountID); exists {
+		s.cache.set(accountCacheKey(walletID, accountID), data)
+		return data, nil
+	}
+
+	if err := s.authorizeRead(); err != nil {
+		return nil, err
+	}
 
-	client := s.client
 	path := s.accountPath(walletID.String(), accountID.String())
 
-	secret, err := client.Logical().Read(path)
+	accountData, err := s.readCommitted(path, nil, s.kvRead)
 
 	if err != nil {
 		return nil, err
 	}
 
-	if secret == nil {
+	if accountData == nil {
 		return nil, errors.New("No account found for ID")
 	}
 
-	byteData, err := json.Marshal(secret.Data)
+	byteData, err := json.Marshal(accountData)
+
+	if err != nil {
+		return nil, err
+	}
+
+	byteData, err = s.decryptIfRequired(walletID, byteData)
 
 	if err != nil {
 		return nil, err
 	}
 
+	// Failing to populate the cache is not fatal; the account will be fetched from Vault next time.
+	if err := s.cacheAccount(walletID, accountID, byteData); err != nil {
+		s.log.Warn("Failed to cache account", "wallet", walletID, "account", accountID, "error", err)
+	}
+	s.cache.set(accountCacheKey(walletID, accountID), byteData)
+
 	return byteData, nil
 }
 
 // RetrieveAccounts retrieves all account-level data for a wallet.
 func (s *Store) RetrieveAccounts(walletID uuid.UUID) <-chan []byte {
-	s.Authorize()
+	return s.RetrieveAccountsContext(context.Background(), walletID)
+}
+
+// RetrieveAccountsContext retrieves all account-level data for a wallet.  Retrieval stops and the channel is closed when
+// ctx is done, so a consumer that stops reading early should cancel ctx to release the goroutine supplying the channel.
+func (s *Store) RetrieveAccountsContext(ctx context.Context, walletID uuid.UUID) <-chan []byte {
+	if err := s.AuthorizeContext(ctx); err != nil {
+		// Errors cannot be passed through the channel; any cached accounts are supplied instead.
+		s.log.Warn("Failed to log in to Vault", "wallet", walletID, "error", err)
+	}
+
+	ch := make(chan []byte, s.channelBuffer)
+	s.spawn(func() {
+		defer close(ch)
+		s.eachAccount(walletID, func(data []byte, err error) bool {
+			if err != nil {
+				// Errors are logged by eachAccount, and cannot be passed through the channel.
+				return ctx.Err() == nil
+			}
+			return s.send(ctx, ch, data)
+		})
+	})
+	return ch
+}
+
+// eachAccount calls yield with the data for each account in a wallet, or an error for each account that cannot be
+// retrieved, until yield returns false.  If the accounts cannot be listed in Vault any cached accounts are used instead.
+// Accounts are supplied in the store's account order.
+func (s *Store) eachAccount(walletID uuid.UUID, yield func([]byte, error) bool) {
+	accounts, err := s.kvList(s.walletPath(walletID.String()))
 
-	client := s.client
-	path := s.walletPath(walletID.String())
-	ch := make(chan []byte, 1024)
-	go func() {
-		secret, err := client.Logical().List(path)
+	var writes map[string]*committedWrite
+	if err == nil {
+		writes, err = s.committedWrites()
+		if err == nil && accounts == nil {
+			accounts = committedAccountKeys(nil, walletID.String(), writes)
+			if len(accounts) == 0 {
+				accounts = nil
+			}
+		}
+	}
 
+	if err != nil || accounts == nil {
+		// Unable to list accounts in Vault; fall back to any cached accounts.
 		if err != nil {
+			s.log.Warn("Failed to list accounts", "wallet", walletID, "error", err)
+		}
+		cached := s.cachedAccounts(walletID)
+		if err != nil && len(cached) == 0 {
+			yield(nil, errors.Wrap(err, "failed to list accounts"))
 			return
 		}
+		s.audit("retrieve cached accounts", walletID.String(), "", "", nil)
+		if s.accountOrder == AccountOrderName {
+			sortAccountsByName(cached)
+		}
+		for _, data := range cached {
+			if !yield(data, nil) {
+				return
+			}
+		}
+		return
+	}
 
-		// Discard this error for now
-		// TODO: Do something with the error
-		accounts, typeError := secret.Data["keys"].([]interface{})
+	keys := make([]string, 0, len(accounts))
+	for _, account := range accounts {
+		if isAccountKey(walletID.String(), account) {
+			keys = append(keys, account)
+		}
+	}
+	keys = committedAccountKeys(keys, walletID.String(), writes)
+
+	// Ordering by name without an index requires every account to be retrieved before any can be supplied.
+	var buffered [][]byte
+	switch s.accountOrder {
+	case AccountOrderID:
+		sort.Strings(keys)
+	case AccountOrderName:
+		index, err := s.accountsIndex(walletID)
+		if err == nil && len(index) > 0 {
+			keys = orderKeysByIndex(keys, index)
+		} else {
+			buffered = make([][]byte, 0, len(keys))
+		}
+	}
 
-		if !typeError {
-			close(ch)
+	for _, account := range keys {
+		data, err := s.fetchAccount(walletID, account, writes)
+		if err != nil {
+			if !yield(nil, err) {
+				return
+			}
+			continue
+		}
+		if data == nil {
+			continue
+		}
+		if buffered != nil {
+			buffered = append(buffered, data)
+			continue
+		}
+		if !yield(data, nil) {
 			return
 		}
+	}
+
+	if buffered != nil {
+		sortAccountsByName(buffered)
+		for _, data := range buffered {
+			if !yield(data, nil) {
+				return
+			}
+		}
+	}
+}
+
+// fetchAccount retrieves the account with the given key in a wallet, from the disk cache if possible, taking account of
+// the given committed writes.  It returns nil if the account no longer exists.
+func (s *Store) fetchAccount(walletID uuid.UUID, account string, writes map[string]*committedWrite) ([]byte, error) {
+	if accountID, err := uuid.Parse(account); err == nil {
+		if data, exists := s.cachedAccount(walletID, accountID); exists {
+			s.audit("retrieve account", walletID.String(), "", account, nil)
+			return data, nil
+		}
+	}
 
-		for _, account := range accounts {
-			if account.(string) != "index" && account.(string) != walletID.String() {
+	accountData, err := s.readCommitted(s.accountPath(walletID.String(), account), writes, s.kvRead)
 
-				// Quietly skip these errors
-				// TODO: Handle errors better through the channel
-				secret, err := client.Logical().Read(s.accountPath(walletID.String(), account.(string)))
+	if err != nil {
+		s.log.Warn("Skipping account; failed to read", "wallet", walletID, "account", account, "error", err)
+		s.audit("retrieve account", walletID.String(), "", account, err)
+		return nil, errors.Wrapf(err, "failed to read account %s", account)
+	}
+	if accountData == nil {
+		s.log.Debug("Skipping account; not found", "wallet", walletID, "account", account)
+		return nil, nil
+	}
 
-				if err != nil {
-					continue
-				}
+	byteData, err := json.Marshal(accountData)
 
-				byteData, err := json.Marshal(secret.Data)
+	if err != nil {
+		s.log.Warn("Skipping account; failed to marshal", "wallet", walletID, "account", account, "error", err)
+		return nil, errors.Wrapf(err, "failed to marshal account %s", account)
+	}
 
-				if err != nil {
-					continue
-				}
+	data, err := s.decryptIfRequired(walletID, byteData)
 
-				data, err := s.decryptIfRequired(byteData)
+	if err != nil {
+		s.log.Warn("Skipping account; failed to decrypt", "wallet", walletID, "account", account, "error", err)
+		s.audit("retrieve account", walletID.String(), "", account, err)
+		return nil, errors.Wrapf(err, "failed to decrypt account %s", account)
+	}
+	s.audit("retrieve account", walletID.String(), "", account, nil)
 
-				if err != nil {
-					continue
-				}
-				ch <- data
-			}
-		}
-		close(ch)
-	}()
-	return ch
+	return data, nil
 }
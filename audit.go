@@ -0,0 +1,134 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuditEvent is a record of an operation carried out by the store.
+type AuditEvent struct {
+	Time       time.Time `json:"time"`
+	Operation  string    `json:"operation"`
+	WalletID   string    `json:"wallet_id,omitempty"`
+	WalletName string    `json:"wallet_name,omitempty"`
+	AccountID  string    `json:"account_id,omitempty"`
+	Principal  string    `json:"principal"`
+	Result     string    `json:"result"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// AuditSink receives audit events.  Sinks that also implement io.Closer are closed when the store is closed.
+type AuditSink interface {
+	Audit(event *AuditEvent) error
+}
+
+// jsonFileAuditSink appends audit events to a file, one JSON object per line.
+type jsonFileAuditSink struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// NewJSONFileAuditSink creates an audit sink that appends events to the named file, one JSON object per line.
+// The file is created if it does not exist.
+func NewJSONFileAuditSink(path string) (AuditSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &jsonFileAuditSink{
+		file: file,
+	}, nil
+}
+
+// Audit implements AuditSink.
+func (s *jsonFileAuditSink) Audit(event *AuditEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, err := s.file.Write(data); err != nil {
+		return err
+	}
+
+	return s.file.Sync()
+}
+
+// Close implements io.Closer.
+func (s *jsonFileAuditSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.file.Close()
+}
+
+// vaultAuditSink writes audit events to Vault, under the store's audit path.
+type vaultAuditSink struct {
+	store *Store
+}
+
+// Audit implements AuditSink.
+func (s *vaultAuditSink) Audit(event *AuditEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	key := s.store.auditEventPath(event.Time, fmt.Sprintf("%d-%s", event.Time.UnixNano(), uuid.New().String()))
+	if s.store.kvVersion == 2 {
+		// Only write if the key does not already exist, so events are never overwritten.
+		_, err = s.store.kvWriteCAS(key, data, 0)
+		return err
+	}
+
+	return s.store.kvWrite(key, data)
+}
+
+// audit sends an audit event for an operation to all of the store's audit sinks.
+func (s *Store) audit(operation string, walletID string, walletName string, accountID string, opErr error) {
+	if len(s.auditSinks) == 0 {
+		return
+	}
+
+	event := &AuditEvent{
+		Time:       time.Now().UTC(),
+		Operation:  operation,
+		WalletID:   walletID,
+		WalletName: walletName,
+		AccountID:  accountID,
+		Principal:  s.principal(),
+		Result:     "success",
+	}
+	if opErr != nil {
+		event.Result = "failure"
+		event.Error = opErr.Error()
+	}
+
+	for _, sink := range s.auditSinks {
+		if err := sink.Audit(event); err != nil {
+			s.log.Warn("Failed to write audit event", "operation", operation, "wallet", walletID, "account", accountID, "error", err)
+		}
+	}
+}
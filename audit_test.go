@@ -0,0 +1,100 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONFileAuditSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	sink, err := NewJSONFileAuditSink(path)
+	require.Nil(t, err)
+
+	store := &Store{
+		role:       "eth",
+		log:        nopLogger{},
+		auditSinks: []AuditSink{sink},
+	}
+	store.audit("store account", "wallet", "", "account", nil)
+	store.audit("retrieve account", "wallet", "", "account", errors.New("not found"))
+
+	// Closing the store closes the sink's file.
+	require.Nil(t, store.Close())
+	assert.NotNil(t, sink.Audit(&AuditEvent{Operation: "after close"}))
+	require.Nil(t, store.Close())
+
+	file, err := os.Open(path)
+	require.Nil(t, err)
+	defer file.Close()
+
+	events := make([]*AuditEvent, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		event := &AuditEvent{}
+		require.Nil(t, json.Unmarshal(scanner.Bytes(), event))
+		events = append(events, event)
+	}
+	require.Len(t, events, 2)
+
+	assert.Equal(t, "store account", events[0].Operation)
+	assert.Equal(t, "wallet", events[0].WalletID)
+	assert.Equal(t, "account", events[0].AccountID)
+	assert.Equal(t, "eth", events[0].Principal)
+	assert.Equal(t, "success", events[0].Result)
+
+	assert.Equal(t, "retrieve account", events[1].Operation)
+	assert.Equal(t, "failure", events[1].Result)
+	assert.Equal(t, "not found", events[1].Error)
+}
+
+// recordingAuditSink records the operations of the audit events it receives.
+type recordingAuditSink struct {
+	operations []string
+}
+
+func (r *recordingAuditSink) Audit(event *AuditEvent) error {
+	r.operations = append(r.operations, event.Operation)
+	return nil
+}
+
+func TestInternalReadsNotAudited(t *testing.T) {
+	sink := &recordingAuditSink{}
+	walletID := uuid.New()
+	store := &Store{
+		log:         nopLogger{},
+		auditSinks:  []AuditSink{sink},
+		walletCache: newMemCache(0, 0),
+	}
+	store.walletCache.set(walletCacheKey(walletID), []byte(`{"uuid":"`+walletID.String()+`","name":"test","type":"hierarchical deterministic"}`))
+
+	// The wallet is read from the cache to check its type, which must not be audited as a separate retrieval.
+	_, err := store.RetrieveDistributedAccounts(walletID)
+	require.NotNil(t, err)
+	assert.Equal(t, []string{"retrieve distributed accounts"}, sink.operations)
+}
@@ -0,0 +1,127 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// Bootstrap prepares Vault for the store, so that new environments can be provisioned through the store alone.  It
+// mounts the KV secrets engine with the store's KV version if it is not already mounted, and with version 2 configures
+// it to retain at most maxVersions versions of each wallet and account (0 for Vault's default), requiring check-and-set
+// if the store uses it.  If the store encrypts with a transit key it also mounts the Transit secrets engine and creates
+// the key if required.  Headers set with WithRequestHeader or WithCallerIdentity are configured to be recorded by Vault's
+// audit devices.
+// Bootstrap requires a Vault role with permission to manage mounts, which the store does not otherwise need.
+func (s *Store) Bootstrap(maxVersions int) (err error) {
+	defer func() { s.audit("bootstrap", "", "", "", err) }()
+
+	if err := s.Authorize(); err != nil {
+		return errors.Wrap(err, "failed to authorize")
+	}
+
+	var mounts map[string]*api.MountOutput
+	err = s.callVault("list mounts", "sys/mounts", func() error {
+		var err error
+		mounts, err = s.client.Sys().ListMounts()
+		return err
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to list mounts")
+	}
+
+	kvOptions := map[string]string{"version": strconv.Itoa(s.kvVersion)}
+	if err := s.ensureMount(mounts, s.kvMount, "kv", kvOptions); err != nil {
+		return err
+	}
+	if mount := mounts[s.kvMount+"/"]; mount != nil && mount.Options["version"] != kvOptions["version"] {
+		return errors.Errorf("KV secrets engine at %s is version %s, but the store uses version %d", s.kvMount, mount.Options["version"], s.kvVersion)
+	}
+
+	if s.kvVersion == 2 {
+		config := map[string]interface{}{
+			"cas_required": s.checkAndSet,
+		}
+		if maxVersions > 0 {
+			config["max_versions"] = maxVersions
+		}
+		err := s.callVault("write config", s.kvMount+"/config", func() error {
+			_, err := s.client.Logical().Write(fmt.Sprintf("/%s/config", s.kvMount), config)
+			return err
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to configure KV secrets engine")
+		}
+	}
+
+	for _, header := range s.auditedHeaders {
+		path := fmt.Sprintf("sys/config/auditing/request-headers/%s", header)
+		err := s.callVault("audit header", path, func() error {
+			_, err := s.client.Logical().Write(path, map[string]interface{}{
+				"hmac": false,
+			})
+			return err
+		})
+		if err != nil {
+			return errors.Wrapf(err, "failed to configure auditing of %s header", header)
+		}
+	}
+
+	if s.transitKey != "" {
+		if err := s.ensureMount(mounts, s.transitMount, "transit", nil); err != nil {
+			return err
+		}
+		// Writing the key is a no-op if it already exists.
+		path := fmt.Sprintf("%s/keys/%s", s.transitMount, s.transitKey)
+		err := s.callVault("create key", path, func() error {
+			_, err := s.client.Logical().Write(path, map[string]interface{}{
+				"type": "aes256-gcm96",
+			})
+			return err
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to create transit key")
+		}
+	}
+
+	return nil
+}
+
+// ensureMount mounts a secrets engine at the given path if nothing is mounted there, failing if a different type of
+// secrets engine is mounted there.
+func (s *Store) ensureMount(mounts map[string]*api.MountOutput, path string, engineType string, options map[string]string) error {
+	if mount, exists := mounts[path+"/"]; exists {
+		if mount.Type != engineType {
+			return errors.Errorf("%s is mounted at %s, but the store requires %s", mount.Type, path, engineType)
+		}
+		return nil
+	}
+
+	err := s.callVault("mount", "sys/mounts/"+path, func() error {
+		return s.client.Sys().Mount(path, &api.MountInput{
+			Type:    engineType,
+			Options: options,
+		})
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to mount %s secrets engine", engineType)
+	}
+	s.log.Debug("Mounted secrets engine", "path", path, "type", engineType)
+
+	return nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	breaker := newCircuitBreaker(2, 20*time.Millisecond)
+
+	require.Nil(t, breaker.allow())
+	breaker.record(true)
+	require.Nil(t, breaker.allow())
+	breaker.record(true)
+
+	// Tripped.
+	err := breaker.allow()
+	require.NotNil(t, err)
+	_, isUnavailable := err.(*UnavailableError)
+	assert.True(t, isUnavailable)
+
+	// After the cooldown a single probe is allowed through.
+	time.Sleep(30 * time.Millisecond)
+	require.Nil(t, breaker.allow())
+	assert.NotNil(t, breaker.allow())
+
+	// A failed probe keeps the breaker open.
+	breaker.record(true)
+	assert.NotNil(t, breaker.allow())
+
+	// A successful probe closes it.
+	time.Sleep(30 * time.Millisecond)
+	require.Nil(t, breaker.allow())
+	breaker.record(false)
+	assert.Nil(t, breaker.allow())
+	assert.Nil(t, breaker.allow())
+}
+
+func TestCallVaultCircuitBreaker(t *testing.T) {
+	store := &Store{
+		log:     nopLogger{},
+		breaker: newCircuitBreaker(1, time.Minute),
+	}
+
+	attempts := 0
+	op := func() error {
+		attempts++
+		return &api.ResponseError{StatusCode: 503}
+	}
+
+	assert.NotNil(t, store.callVault("read", "eth/test", op))
+	assert.Equal(t, 1, attempts)
+
+	// Breaker is now open, so the operation is not attempted.
+	err := store.callVault("read", "eth/test", op)
+	_, isUnavailable := err.(*UnavailableError)
+	assert.True(t, isUnavailable)
+	assert.Equal(t, 1, attempts)
+}
@@ -0,0 +1,131 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/google/uuid"
+)
+
+// cacheWalletDir returns the directory in the local disk cache for the given wallet.
+func (s *Store) cacheWalletDir(walletID uuid.UUID) string {
+	return filepath.Join(s.cacheDir, s.Location(), walletID.String())
+}
+
+// cachedAccount retrieves account data from the local disk cache, if present.
+func (s *Store) cachedAccount(walletID uuid.UUID, accountID uuid.UUID) ([]byte, bool) {
+	if s.cacheDir == "" {
+		return nil, false
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(s.cacheWalletDir(walletID), accountID.String()))
+	if err != nil {
+		return nil, false
+	}
+
+	data, err = s.decryptIfRequired(walletID, data)
+	if err != nil {
+		return nil, false
+	}
+
+	return data, true
+}
+
+// cachedAccounts retrieves all account data for a wallet from the local disk cache.
+func (s *Store) cachedAccounts(walletID uuid.UUID) [][]byte {
+	if s.cacheDir == "" {
+		return nil
+	}
+
+	files, err := ioutil.ReadDir(s.cacheWalletDir(walletID))
+	if err != nil {
+		return nil
+	}
+
+	accounts := make([][]byte, 0, len(files))
+	for _, file := range files {
+		accountID, err := uuid.Parse(file.Name())
+		if err != nil {
+			// Not an account (e.g. a partially-written temporary file).
+			continue
+		}
+		if data, exists := s.cachedAccount(walletID, accountID); exists {
+			accounts = append(accounts, data)
+		}
+	}
+
+	return accounts
+}
+
+// cacheAccount writes account data to the local disk cache, if configured.
+// Data is encrypted with the wallet's passphrase before it is written.
+func (s *Store) cacheAccount(walletID uuid.UUID, accountID uuid.UUID, data []byte) error {
+	if s.cacheDir == "" {
+		return nil
+	}
+
+	dir := s.cacheWalletDir(walletID)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	data, err := s.encryptIfRequired(walletID, data)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename it so readers never see a partial account.
+	file, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), filepath.Join(dir, accountID.String()))
+}
+
+// evictCachedAccount removes account data from the local disk cache, if present.
+func (s *Store) evictCachedAccount(walletID uuid.UUID, accountID uuid.UUID) {
+	if s.cacheDir == "" {
+		return
+	}
+	os.Remove(filepath.Join(s.cacheWalletDir(walletID), accountID.String()))
+}
+
+// invalidate removes any cached copies of the wallet or account held at the given key.
+func (s *Store) invalidate(walletID uuid.UUID, key string) {
+	if key == s.walletHeaderPath(walletID.String()) {
+		s.walletCache.remove(walletCacheKey(walletID))
+		return
+	}
+
+	accountID, err := uuid.Parse(path.Base(key))
+	if err != nil {
+		return
+	}
+	s.cache.remove(accountCacheKey(walletID, accountID))
+	s.evictCachedAccount(walletID, accountID)
+}
@@ -0,0 +1,66 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheAccount(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	store := &Store{
+		cacheDir:     dir,
+		vaultSubPath: "eth",
+	}
+
+	walletID := uuid.New()
+	accountID := uuid.New()
+	data := []byte(fmt.Sprintf(`{"name":"test account","uuid":%q}`, accountID.String()))
+
+	_, exists := store.cachedAccount(walletID, accountID)
+	assert.False(t, exists)
+
+	require.Nil(t, store.cacheAccount(walletID, accountID, data))
+	retData, exists := store.cachedAccount(walletID, accountID)
+	require.True(t, exists)
+	assert.Equal(t, data, retData)
+
+	accounts := store.cachedAccounts(walletID)
+	require.Len(t, accounts, 1)
+	assert.Equal(t, data, accounts[0])
+
+	assert.Len(t, store.cachedAccounts(uuid.New()), 0)
+}
+
+func TestCacheDisabled(t *testing.T) {
+	store := &Store{}
+
+	walletID := uuid.New()
+	accountID := uuid.New()
+
+	require.Nil(t, store.cacheAccount(walletID, accountID, []byte(`{}`)))
+	_, exists := store.cachedAccount(walletID, accountID)
+	assert.False(t, exists)
+	assert.Nil(t, store.cachedAccounts(walletID))
+}
@@ -0,0 +1,297 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// Kinds of issue found by Check.
+const (
+	// CheckOrphanedAccount is an account in a wallet that has no wallet data.
+	CheckOrphanedAccount = "orphaned account"
+	// CheckUndecryptable is a wallet or account that cannot be decrypted, or is not valid JSON once decrypted.
+	CheckUndecryptable = "undecryptable"
+	// CheckDuplicateWalletName is a wallet with the same name as another wallet.
+	CheckDuplicateWalletName = "duplicate wallet name"
+	// CheckDuplicateAccountName is an account with the same name as another account in the same wallet.
+	CheckDuplicateAccountName = "duplicate account name"
+	// CheckUnindexedAccount is an account that is not in its wallet's accounts index.
+	CheckUnindexedAccount = "unindexed account"
+	// CheckMissingAccount is an entry in a wallet's accounts index for an account that does not exist.
+	CheckMissingAccount = "missing account"
+)
+
+// CheckIssue is an inconsistency found by Check.
+type CheckIssue struct {
+	// Kind is the kind of issue.
+	Kind string
+	// WalletID is the ID of the wallet in which the issue was found.
+	WalletID uuid.UUID
+	// Key is the key of the object in Vault with the issue.
+	Key string
+	// Detail describes the issue.
+	Detail string
+	// Fixed is true if the issue was fixed.
+	Fixed bool
+}
+
+// CheckReport is the result of checking the consistency of the store.
+type CheckReport struct {
+	// Wallets is the number of wallets checked.
+	Wallets int
+	// Accounts is the number of accounts checked.
+	Accounts int
+	// Issues are the inconsistencies found.
+	Issues []*CheckIssue
+}
+
+// Consistent returns true if no issues were found, or all issues found were fixed.
+func (r *CheckReport) Consistent() bool {
+	for _, issue := range r.Issues {
+		if !issue.Fixed {
+			return false
+		}
+	}
+	return true
+}
+
+// Check checks the consistency of the store, reporting accounts without wallets, wallets and accounts that cannot be
+// decrypted, duplicate wallet and account names, and accounts indexes that do not match the accounts in their wallets.
+// If fix is true accounts indexes are rebuilt to match the accounts in their wallets; other issues require manual
+// intervention.  The index of a wallet with any account that cannot be decrypted is not rebuilt, so that such accounts
+// are not dropped from it, for example when the store is checked with the wrong passphrase.  An error is only returned if the store cannot be walked.
+func (s *Store) Check(ctx context.Context, fix bool) (_ *CheckReport, err error) {
+	defer func() { s.audit("check", "", "", "", err) }()
+
+	if err := s.AuthorizeContext(ctx); err != nil {
+		return nil, err
+	}
+
+	wallets, err := s.kvList(s.walletsPath())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list wallets")
+	}
+
+	report := &CheckReport{
+		Issues: make([]*CheckIssue, 0),
+	}
+	walletNames := make(map[string]uuid.UUID)
+	for _, wallet := range wallets {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		walletID, err := uuid.Parse(strings.TrimSuffix(wallet, "/"))
+		if err != nil {
+			// Not a wallet.
+			continue
+		}
+		if err := s.checkWallet(walletID, walletNames, fix, report); err != nil {
+			return nil, err
+		}
+	}
+
+	return report, nil
+}
+
+// checkWallet checks a single wallet and its accounts, adding any issues found to the report.
+func (s *Store) checkWallet(walletID uuid.UUID, walletNames map[string]uuid.UUID, fix bool, report *CheckReport) error {
+	headerKey := s.walletHeaderPath(walletID.String())
+	header, _, err := s.checkObject(walletID, headerKey, report)
+	if err != nil {
+		return err
+	}
+	hasHeader := header != nil
+	if hasHeader {
+		report.Wallets++
+		if name := nameOf(header); name != "" {
+			if otherID, exists := walletNames[name]; exists {
+				report.Issues = append(report.Issues, &CheckIssue{
+					Kind:     CheckDuplicateWalletName,
+					WalletID: walletID,
+					Key:      headerKey,
+					Detail:   fmt.Sprintf("wallet %q is also used by wallet %s", name, otherID),
+				})
+			} else {
+				walletNames[name] = walletID
+			}
+		}
+	}
+
+	keys, err := s.kvList(s.walletPath(walletID.String()))
+	if err != nil {
+		return errors.Wrapf(err, "failed to list accounts for wallet %s", walletID)
+	}
+	accounts := make([]*indexEntry, 0, len(keys))
+	accountNames := make(map[string]string)
+	complete := true
+	for _, key := range keys {
+		if !isAccountKey(walletID.String(), key) {
+			continue
+		}
+		accountKey := s.accountPath(walletID.String(), key)
+		data, undecryptable, err := s.checkObject(walletID, accountKey, report)
+		if err != nil {
+			return err
+		}
+		if undecryptable {
+			complete = false
+		}
+		if data == nil {
+			continue
+		}
+		report.Accounts++
+		if !hasHeader {
+			report.Issues = append(report.Issues, &CheckIssue{
+				Kind:     CheckOrphanedAccount,
+				WalletID: walletID,
+				Key:      accountKey,
+				Detail:   "account has no wallet",
+			})
+			continue
+		}
+		name := nameOf(data)
+		if otherKey, exists := accountNames[name]; exists {
+			report.Issues = append(report.Issues, &CheckIssue{
+				Kind:     CheckDuplicateAccountName,
+				WalletID: walletID,
+				Key:      accountKey,
+				Detail:   fmt.Sprintf("account %q is also used by account %s", name, otherKey),
+			})
+		} else {
+			accountNames[name] = key
+		}
+		accounts = append(accounts, &indexEntry{UUID: key, Name: name})
+	}
+
+	if !hasHeader {
+		return nil
+	}
+	return s.checkIndex(walletID, accounts, fix && complete, report)
+}
+
+// checkObject reads and decrypts an object, returning its data or nil if it does not exist or cannot be decrypted, and
+// true if it cannot be decrypted.
+func (s *Store) checkObject(walletID uuid.UUID, key string, report *CheckReport) ([]byte, bool, error) {
+	data, err := s.readObject(key)
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "failed to read %s", key)
+	}
+	if data == nil {
+		return nil, false, nil
+	}
+
+	data, err = s.decryptIfRequired(walletID, data)
+	if err == nil && !json.Valid(data) {
+		err = errors.New("invalid JSON")
+	}
+	if err != nil {
+		report.Issues = append(report.Issues, &CheckIssue{
+			Kind:     CheckUndecryptable,
+			WalletID: walletID,
+			Key:      key,
+			Detail:   err.Error(),
+		})
+		return nil, true, nil
+	}
+
+	return data, false, nil
+}
+
+// checkIndex checks that a wallet's accounts index matches its accounts, rebuilding the index if fix is true.
+func (s *Store) checkIndex(walletID uuid.UUID, accounts []*indexEntry, fix bool, report *CheckReport) error {
+	index, err := s.accountsIndex(walletID)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read accounts index for wallet %s", walletID)
+	}
+
+	issues := indexIssues(walletID, s.walletIndexPath(walletID.String()), accounts, index)
+	if len(issues) > 0 && fix {
+		if err := s.rebuildIndex(walletID, accounts); err != nil {
+			return err
+		}
+		for _, issue := range issues {
+			issue.Fixed = true
+		}
+	}
+	report.Issues = append(report.Issues, issues...)
+
+	return nil
+}
+
+// indexIssues compares a wallet's accounts with the entries in its accounts index.
+func indexIssues(walletID uuid.UUID, key string, accounts []*indexEntry, index []*indexEntry) []*CheckIssue {
+	indexed := make(map[string]string, len(index))
+	for _, entry := range index {
+		indexed[entry.UUID] = entry.Name
+	}
+	present := make(map[string]bool, len(accounts))
+
+	issues := make([]*CheckIssue, 0)
+	for _, account := range accounts {
+		present[account.UUID] = true
+		name, exists := indexed[account.UUID]
+		if !exists {
+			issues = append(issues, &CheckIssue{
+				Kind:     CheckUnindexedAccount,
+				WalletID: walletID,
+				Key:      key,
+				Detail:   fmt.Sprintf("account %s is not indexed", account.UUID),
+			})
+		} else if name != account.Name {
+			issues = append(issues, &CheckIssue{
+				Kind:     CheckUnindexedAccount,
+				WalletID: walletID,
+				Key:      key,
+				Detail:   fmt.Sprintf("account %s is indexed as %q rather than %q", account.UUID, name, account.Name),
+			})
+		}
+	}
+	for _, entry := range index {
+		if !present[entry.UUID] {
+			issues = append(issues, &CheckIssue{
+				Kind:     CheckMissingAccount,
+				WalletID: walletID,
+				Key:      key,
+				Detail:   fmt.Sprintf("indexed account %s does not exist", entry.UUID),
+			})
+		}
+	}
+
+	return issues
+}
+
+// rebuildIndex replaces a wallet's accounts index with one containing the given accounts, ordered by name.
+func (s *Store) rebuildIndex(walletID uuid.UUID, accounts []*indexEntry) error {
+	index := make([]*indexEntry, len(accounts))
+	copy(index, accounts)
+	sort.Slice(index, func(i, j int) bool { return index[i].Name < index[j].Name })
+
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	if err := s.StoreAccountsIndex(walletID, data); err != nil {
+		return errors.Wrapf(err, "failed to rebuild accounts index for wallet %s", walletID)
+	}
+
+	return nil
+}
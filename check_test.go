@@ -0,0 +1,57 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIndexIssues(t *testing.T) {
+	walletID := uuid.New()
+	accounts := []*indexEntry{
+		{UUID: "a", Name: "one"},
+		{UUID: "b", Name: "two"},
+		{UUID: "c", Name: "three"},
+	}
+
+	assert.Len(t, indexIssues(walletID, "index", accounts, accounts), 0)
+
+	index := []*indexEntry{
+		{UUID: "a", Name: "one"},
+		{UUID: "b", Name: "renamed"},
+		{UUID: "d", Name: "four"},
+	}
+	issues := indexIssues(walletID, "index", accounts, index)
+	kinds := make([]string, 0, len(issues))
+	for _, issue := range issues {
+		kinds = append(kinds, issue.Kind)
+		assert.Equal(t, walletID, issue.WalletID)
+	}
+	// b is misnamed, c is not indexed and d does not exist.
+	assert.Equal(t, []string{CheckUnindexedAccount, CheckUnindexedAccount, CheckMissingAccount}, kinds)
+}
+
+func TestCheckReportConsistent(t *testing.T) {
+	report := &CheckReport{}
+	assert.True(t, report.Consistent())
+
+	report.Issues = append(report.Issues, &CheckIssue{Kind: CheckMissingAccount, Fixed: true})
+	assert.True(t, report.Consistent())
+
+	report.Issues = append(report.Issues, &CheckIssue{Kind: CheckUndecryptable})
+	assert.False(t, report.Consistent())
+}
@@ -0,0 +1,212 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"sync"
+
+	"github.com/pkg/errors"
+	ecodec "github.com/wealdtech/go-ecodec"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Cipher is an algorithm with which the store encrypts data with a passphrase.
+type Cipher string
+
+const (
+	// CipherAES256GCM is AES-256 in Galois/Counter Mode.  This is the default.
+	CipherAES256GCM Cipher = "aes-256-gcm"
+	// CipherXChaCha20Poly1305 is XChaCha20-Poly1305.
+	CipherXChaCha20Poly1305 Cipher = "xchacha20-poly1305"
+	// CipherECodec is the encryption provided by go-ecodec, which the store used before ciphers were selectable.  Data
+	// encrypted with it can be read by older releases of the store.
+	CipherECodec Cipher = "ecodec"
+)
+
+// keyCache holds keys derived from passphrases, so that each key is derived only once.  Data encrypted by the store
+// shares a single salt for the lifetime of the store, with a fresh nonce for each object, so that reading back many
+// objects written by the same store requires only one key derivation.
+type keyCache struct {
+	mutex sync.Mutex
+	salt  []byte
+	keys  map[string][]byte
+}
+
+// newKeyCache creates a new key cache.
+func newKeyCache() *keyCache {
+	return &keyCache{
+		keys: make(map[string][]byte),
+	}
+}
+
+// sealingSalt returns the salt with which to derive keys for new data.  It is nil-safe, returning a fresh salt each
+// time if there is no cache.
+func (c *keyCache) sealingSalt() ([]byte, error) {
+	if c != nil {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		if c.salt != nil {
+			return c.salt, nil
+		}
+	}
+
+	salt := make([]byte, keySaltBytes)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, errors.Wrap(err, "failed to generate salt")
+	}
+	if c != nil {
+		c.salt = salt
+	}
+	return salt, nil
+}
+
+// key derives the key for a passphrase and salt with the given key derivation, or returns it from the cache if it has
+// already been derived.  It is nil-safe, deriving the key each time if there is no cache.
+func (c *keyCache) key(kdf kdfParams, passphrase []byte, salt []byte) ([]byte, error) {
+	digest := sha256.New()
+	_, _ = digest.Write([]byte(kdf.String()))
+	_, _ = digest.Write(salt)
+	_, _ = digest.Write(passphrase)
+	id := hex.EncodeToString(digest.Sum(nil))
+
+	if c != nil {
+		c.mutex.Lock()
+		key, exists := c.keys[id]
+		c.mutex.Unlock()
+		if exists {
+			return key, nil
+		}
+	}
+
+	key, err := kdf.derive(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	if c != nil {
+		c.mutex.Lock()
+		c.keys[id] = key
+		c.mutex.Unlock()
+	}
+	return key, nil
+}
+
+// validCipher returns true if the cipher is supported.
+func validCipher(algorithm Cipher) bool {
+	switch algorithm {
+	case CipherAES256GCM, CipherXChaCha20Poly1305, CipherECodec:
+		return true
+	default:
+		return false
+	}
+}
+
+// newAEAD creates an AEAD for the given cipher and key.
+func newAEAD(algorithm Cipher, key []byte) (cipher.AEAD, error) {
+	switch algorithm {
+	case CipherAES256GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case CipherXChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	default:
+		return nil, errors.Errorf("unsupported cipher %q", algorithm)
+	}
+}
+
+// sealingCipher returns the cipher with which the store encrypts data with a passphrase.
+func (s *Store) sealingCipher() Cipher {
+	if s.cipher == "" {
+		return CipherAES256GCM
+	}
+	return s.cipher
+}
+
+// envelopeCipher returns the cipher with which the data in an envelope was encrypted with a passphrase.  Envelopes
+// without a cipher were encrypted with go-ecodec.
+func envelopeCipher(env *envelope) Cipher {
+	if env.Cipher == "" {
+		return CipherECodec
+	}
+	return Cipher(env.Cipher)
+}
+
+// encryptWithPassphrase encrypts data with a passphrase using the store's cipher and key derivation, recording the
+// cipher, salt and key derivation in the envelope and returning the ciphertext.
+func (s *Store) encryptWithPassphrase(env *envelope, data []byte, passphrase []byte) ([]byte, error) {
+	algorithm := s.sealingCipher()
+	if algorithm == CipherECodec {
+		return ecodec.Encrypt(data, passphrase)
+	}
+
+	salt, err := s.keys.sealingSalt()
+	if err != nil {
+		return nil, err
+	}
+	kdf := s.sealingKDF()
+	key, err := s.keys.key(kdf, passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(algorithm, key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Wrap(err, "failed to generate nonce")
+	}
+
+	env.Cipher = string(algorithm)
+	env.Salt = base64.StdEncoding.EncodeToString(salt)
+	env.KDF = &kdf
+	// The nonce is held at the start of the ciphertext.
+	return aead.Seal(nonce, nonce, data, nil), nil
+}
+
+// decryptWithPassphrase decrypts ciphertext from an envelope with a passphrase, using the cipher recorded in the
+// envelope.
+func (s *Store) decryptWithPassphrase(env *envelope, ciphertext []byte, passphrase []byte) ([]byte, error) {
+	algorithm := envelopeCipher(env)
+	if algorithm == CipherECodec {
+		return ecodec.Decrypt(ciphertext, passphrase)
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(env.Salt)
+	if err != nil || len(salt) == 0 {
+		return nil, errors.New("invalid salt")
+	}
+	key, err := s.keys.key(envelopeKDF(env), passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(algorithm, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	return aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], nil)
+}
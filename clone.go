@@ -0,0 +1,118 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// CloneWallet copies a wallet, along with its accounts and accounts index, to a new wallet with the given name,
+// returning the ID of the new wallet.  Accounts keep their IDs.  Where the store has no secondary store or key provider
+// and does not tag objects, accounts are copied as stored without being decrypted.
+// If cloning fails part way through the incomplete clone is left in place, and can be removed with DeleteWallet.
+func (s *Store) CloneWallet(srcID uuid.UUID, dstName string) (_ uuid.UUID, err error) {
+	dstID := uuid.New()
+	defer func() { s.audit("clone wallet", dstID.String(), dstName, "", err) }()
+
+	if dstName == "" {
+		return uuid.Nil, errors.New("no name supplied")
+	}
+
+	if err := s.Authorize(); err != nil {
+		return uuid.Nil, err
+	}
+
+	wallets, err := s.ListWalletNames()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	for _, wallet := range wallets {
+		if wallet.Name == dstName {
+			return uuid.Nil, errors.New("wallet already exists")
+		}
+	}
+
+	// Hold the source wallet's lock so that the clone is consistent.
+	unlock, err := s.lockWallet(srcID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer unlock()
+
+	s.walletCache.remove(walletCacheKey(srcID))
+	walletData, err := s.retrieveWalletByID(srcID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	walletData, err = setField(walletData, "uuid", dstID.String())
+	if err != nil {
+		return uuid.Nil, err
+	}
+	walletData, err = renameObject(walletData, dstName)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if err := s.StoreWallet(dstID, dstName, walletData); err != nil {
+		return uuid.Nil, errors.Wrap(err, "failed to store wallet")
+	}
+
+	accounts, err := s.kvList(s.walletPath(srcID.String()))
+	if err != nil {
+		return uuid.Nil, errors.Wrap(err, "failed to list accounts")
+	}
+	for _, account := range accounts {
+		if !isAccountKey(srcID.String(), account) {
+			continue
+		}
+		if err := s.cloneAccount(srcID, dstID, account); err != nil {
+			return uuid.Nil, errors.Wrapf(err, "failed to clone account %s", account)
+		}
+	}
+
+	if index, err := s.RetrieveAccountsIndex(srcID); err == nil {
+		if err := s.StoreAccountsIndex(dstID, index); err != nil {
+			return uuid.Nil, errors.Wrap(err, "failed to store accounts index")
+		}
+	}
+
+	return dstID, nil
+}
+
+// cloneAccount copies the account held at the given key in one wallet to another wallet.
+func (s *Store) cloneAccount(srcID uuid.UUID, dstID uuid.UUID, account string) error {
+	accountID, err := uuid.Parse(account)
+	if err != nil {
+		return errors.Wrap(err, "invalid account ID")
+	}
+	data, err := s.readObject(s.accountPath(srcID.String(), account))
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		// Removed since it was listed.
+		return nil
+	}
+
+	// With a key provider each wallet has its own passphrase, so accounts must be re-encrypted for the new wallet.
+	if s.secondary == nil && s.tags == nil && s.keyProvider == nil {
+		return s.kvWrite(s.accountPath(dstID.String(), account), data)
+	}
+
+	data, err = s.decryptIfRequired(srcID, data)
+	if err != nil {
+		return err
+	}
+	return s.StoreAccount(dstID, accountID, data)
+}
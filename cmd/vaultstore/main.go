@@ -0,0 +1,344 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command vaultstore administers wallets held in a Vault store.
+//
+// Usage:
+//
+//	vaultstore [flags] <command> [arguments]
+//
+// Commands are:
+//
+//	wallets                          list the wallets in the store
+//	accounts <wallet>                list the accounts in a wallet
+//	inspect <wallet> [<account>]     print a wallet or account
+//	verify                           verify every object in the store
+//	export <file>                    export the store to an archive
+//	import [flags] <file>            import an archive into the store
+//	migrate [flags]                  copy another store into this store
+//	delete <wallet> <account>        delete an account
+//
+// Wallets can be given by name or ID, and accounts by ID.  The store's passphrase, if any, is read from the
+// VAULTSTORE_PASSPHRASE environment variable, that of the store being migrated from VAULTSTORE_FROM_PASSPHRASE and that
+// of archives from VAULTSTORE_ARCHIVE_PASSPHRASE, so that they do not appear in process listings.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	vault "github.com/stakedllc/go-eth2-wallet-store-vault"
+)
+
+// storeFlags are the flags that configure access to a store.
+type storeFlags struct {
+	address    *string
+	role       *string
+	token      *string
+	subPath    *string
+	kvMount    *string
+	kvVersion  *int
+	transitKey *string
+}
+
+// addStoreFlags adds flags configuring access to a store to a flag set, with names starting with prefix.
+func addStoreFlags(flags *flag.FlagSet, prefix string) *storeFlags {
+	return &storeFlags{
+		address:    flags.String(prefix+"address", "http://vault.vault:8200", "address of the Vault server"),
+		role:       flags.String(prefix+"role", "eth", "Vault role with which to log in with the Kubernetes service account token"),
+		token:      flags.String(prefix+"token", os.Getenv("VAULT_TOKEN"), "Vault token, in place of logging in with the Kubernetes service account token"),
+		subPath:    flags.String(prefix+"sub-path", "eth", "path under which wallets are held"),
+		kvMount:    flags.String(prefix+"kv-mount", "secret", "path at which the KV secrets engine is mounted"),
+		kvVersion:  flags.Int(prefix+"kv-version", 1, "version of the KV secrets engine"),
+		transitKey: flags.String(prefix+"transit-key", "", "name of a Vault transit key with which data is encrypted"),
+	}
+}
+
+// open opens the store configured by the flags, with the given passphrase.
+func (f *storeFlags) open(passphrase string) (*vault.Store, error) {
+	opts := []vault.Option{
+		vault.WithVaultAddress(*f.address),
+		vault.WithRole(*f.role),
+		vault.WithVaultSubPath(*f.subPath),
+		vault.WithKVMount(*f.kvMount),
+		vault.WithKVVersion(*f.kvVersion),
+	}
+	if *f.token != "" {
+		opts = append(opts, vault.WithToken(*f.token))
+	}
+	if passphrase != "" {
+		opts = append(opts, vault.WithPassphrase([]byte(passphrase)))
+	}
+	if *f.transitKey != "" {
+		opts = append(opts, vault.WithTransitKey(*f.transitKey))
+	}
+
+	store, err := vault.New(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return store.(*vault.Store), nil
+}
+
+func main() {
+	flags := flag.NewFlagSet("vaultstore", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: vaultstore [flags] <wallets|accounts|inspect|verify|export|import|migrate|delete> [arguments]\n")
+		flags.PrintDefaults()
+	}
+	config := addStoreFlags(flags, "")
+	flags.Parse(os.Args[1:])
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	store, err := config.open(os.Getenv("VAULTSTORE_PASSPHRASE"))
+	if err != nil {
+		fail(errors.Wrap(err, "failed to open store"))
+	}
+	defer store.Close()
+
+	args := flags.Args()[1:]
+	switch flags.Arg(0) {
+	case "wallets":
+		err = listWallets(store)
+	case "accounts":
+		err = listAccounts(store, args)
+	case "inspect":
+		err = inspect(store, args)
+	case "verify":
+		err = verify(store)
+	case "export":
+		err = export(store, args)
+	case "import":
+		err = importArchive(store, args)
+	case "migrate":
+		err = migrate(store, args)
+	case "delete":
+		err = deleteAccount(store, args)
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+// fail reports an error and exits.
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "vaultstore: %v\n", err)
+	os.Exit(1)
+}
+
+// walletID obtains the ID of a wallet given by name or ID.
+func walletID(store *vault.Store, wallet string) (uuid.UUID, error) {
+	if id, err := uuid.Parse(wallet); err == nil {
+		return id, nil
+	}
+
+	data, err := store.RetrieveWallet(wallet)
+	if err != nil {
+		return uuid.Nil, errors.Wrapf(err, "failed to find wallet %q", wallet)
+	}
+	info := &struct {
+		UUID string `json:"uuid"`
+	}{}
+	if err := json.Unmarshal(data, info); err != nil {
+		return uuid.Nil, errors.Wrapf(err, "invalid wallet %q", wallet)
+	}
+	return uuid.Parse(info.UUID)
+}
+
+func listWallets(store *vault.Store) error {
+	wallets, err := store.ListWalletNames()
+	if err != nil {
+		return err
+	}
+	for _, wallet := range wallets {
+		fmt.Printf("%s\t%s\n", wallet.ID, wallet.Name)
+	}
+	return nil
+}
+
+func listAccounts(store *vault.Store, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: accounts <wallet>")
+	}
+	id, err := walletID(store, args[0])
+	if err != nil {
+		return err
+	}
+
+	accounts, err := store.ListAccountIDs(id)
+	if err != nil {
+		return err
+	}
+	for _, account := range accounts {
+		fmt.Printf("%s\t%s\n", account.ID, account.Name)
+	}
+	return nil
+}
+
+func inspect(store *vault.Store, args []string) error {
+	if len(args) != 1 && len(args) != 2 {
+		return errors.New("usage: inspect <wallet> [<account>]")
+	}
+	id, err := walletID(store, args[0])
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	if len(args) == 1 {
+		data, err = store.RetrieveWalletByID(id)
+	} else {
+		accountID, parseErr := uuid.Parse(args[1])
+		if parseErr != nil {
+			return errors.Wrap(parseErr, "invalid account ID")
+		}
+		data, err = store.RetrieveAccount(id, accountID)
+	}
+	if err != nil {
+		return err
+	}
+
+	var object map[string]interface{}
+	if err := json.Unmarshal(data, &object); err != nil {
+		return errors.Wrap(err, "invalid data")
+	}
+	output, err := json.MarshalIndent(object, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(output))
+	return nil
+}
+
+func verify(store *vault.Store) error {
+	results, err := store.Verify(context.Background())
+	if err != nil {
+		return err
+	}
+
+	failures := 0
+	for _, result := range results {
+		if result.Error != nil {
+			failures++
+			fmt.Printf("%s\t%s\t%v\n", result.Kind, result.Key, result.Error)
+		}
+	}
+	if failures > 0 {
+		return errors.Errorf("%d of %d objects failed verification", failures, len(results))
+	}
+	fmt.Printf("%d objects verified\n", len(results))
+	return nil
+}
+
+func export(store *vault.Store, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: export <file>")
+	}
+
+	file, err := os.OpenFile(args[0], os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if err := store.Export(file, []byte(os.Getenv("VAULTSTORE_ARCHIVE_PASSPHRASE"))); err != nil {
+		file.Close()
+		os.Remove(args[0])
+		return err
+	}
+	return file.Close()
+}
+
+func importArchive(store *vault.Store, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	policyName := flags.String("policy", "skip", "handling of objects that already exist: skip, overwrite or fail")
+	dryRun := flags.Bool("dry-run", false, "report the changes that would be made without making them")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("usage: import [-policy skip|overwrite|fail] [-dry-run] <file>")
+	}
+
+	policies := map[string]vault.ImportConflictPolicy{
+		"skip":      vault.ImportSkip,
+		"overwrite": vault.ImportOverwrite,
+		"fail":      vault.ImportFail,
+	}
+	policy, exists := policies[*policyName]
+	if !exists {
+		return errors.Errorf("unknown policy %q", *policyName)
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	changes, err := store.Import(file, []byte(os.Getenv("VAULTSTORE_ARCHIVE_PASSPHRASE")), policy, *dryRun)
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		if change.Kind == "account" {
+			fmt.Printf("%s\t%s\t%s/%s\n", change.Action, change.Kind, change.WalletID, change.AccountID)
+		} else {
+			fmt.Printf("%s\t%s\t%s\n", change.Action, change.Kind, change.WalletID)
+		}
+	}
+	return nil
+}
+
+func migrate(store *vault.Store, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	config := addStoreFlags(flags, "from-")
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		return errors.New("usage: migrate [-from-address ...] [-from-sub-path ...] ...")
+	}
+
+	from, err := config.open(os.Getenv("VAULTSTORE_FROM_PASSPHRASE"))
+	if err != nil {
+		return errors.Wrap(err, "failed to open source store")
+	}
+	defer from.Close()
+
+	return store.Migrate(from, func(progress *vault.MaintenanceProgress) {
+		fmt.Printf("%s\n", progress.Key)
+	})
+}
+
+func deleteAccount(store *vault.Store, args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: delete <wallet> <account>")
+	}
+	id, err := walletID(store, args[0])
+	if err != nil {
+		return err
+	}
+	accountID, err := uuid.Parse(args[1])
+	if err != nil {
+		return errors.Wrap(err, "invalid account ID")
+	}
+
+	return store.DeleteAccount(id, accountID)
+}
@@ -0,0 +1,96 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"sync"
+)
+
+// defaultConcurrency is the default number of objects fetched from Vault at once.
+const defaultConcurrency = 8
+
+// fetchResult is the result of fetching a single object.
+type fetchResult struct {
+	data []byte
+	err  error
+}
+
+// fetchConcurrently calls fetch for each key, with at most concurrency calls in progress at once, and calls yield with
+// each result as it arrives until yield returns false.  Results for which fetch returns neither data nor an error are
+// skipped.  yield is only ever called from the calling goroutine, and all fetches have finished when this returns.
+func fetchConcurrently(keys []string, concurrency int, fetch func(string) ([]byte, error), yield func([]byte, error) bool) {
+	if concurrency > len(keys) {
+		concurrency = len(keys)
+	}
+	if concurrency <= 1 {
+		for _, key := range keys {
+			data, err := fetch(key)
+			if data == nil && err == nil {
+				continue
+			}
+			if !yield(data, err) {
+				return
+			}
+		}
+		return
+	}
+
+	jobs := make(chan string)
+	results := make(chan fetchResult)
+	stop := make(chan struct{})
+
+	go func() {
+		defer close(jobs)
+		for _, key := range keys {
+			select {
+			case jobs <- key:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			for key := range jobs {
+				data, err := fetch(key)
+				if data == nil && err == nil {
+					continue
+				}
+				select {
+				case results <- fetchResult{data: data, err: err}:
+				case <-stop:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	for result := range results {
+		if !yield(result.data, result.err) {
+			close(stop)
+			break
+		}
+	}
+	// Wait for fetches in progress to finish.
+	for range results {
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"errors"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetchConcurrently(t *testing.T) {
+	keys := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+
+	tests := []struct {
+		name        string
+		concurrency int
+	}{
+		{
+			name:        "Sequential",
+			concurrency: 1,
+		},
+		{
+			name:        "Concurrent",
+			concurrency: 3,
+		},
+		{
+			name:        "MoreWorkersThanKeys",
+			concurrency: 20,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var inFlight, maxInFlight int32
+			fetch := func(key string) ([]byte, error) {
+				current := atomic.AddInt32(&inFlight, 1)
+				defer atomic.AddInt32(&inFlight, -1)
+				for {
+					max := atomic.LoadInt32(&maxInFlight)
+					if current <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, current) {
+						break
+					}
+				}
+				time.Sleep(time.Millisecond)
+				switch key {
+				case "c":
+					return nil, errors.New("bad")
+				case "e":
+					return nil, nil
+				}
+				return []byte(key), nil
+			}
+
+			var results []string
+			errs := 0
+			fetchConcurrently(keys, test.concurrency, fetch, func(data []byte, err error) bool {
+				if err != nil {
+					errs++
+				} else {
+					results = append(results, string(data))
+				}
+				return true
+			})
+			sort.Strings(results)
+			assert.Equal(t, []string{"a", "b", "d", "f", "g", "h"}, results)
+			assert.Equal(t, 1, errs)
+			assert.True(t, int(maxInFlight) <= test.concurrency)
+			assert.Equal(t, int32(0), atomic.LoadInt32(&inFlight))
+		})
+	}
+}
+
+func TestFetchConcurrentlyStop(t *testing.T) {
+	keys := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+
+	var fetched int32
+	fetch := func(key string) ([]byte, error) {
+		atomic.AddInt32(&fetched, 1)
+		return []byte(key), nil
+	}
+
+	yielded := 0
+	fetchConcurrently(keys, 2, fetch, func(data []byte, err error) bool {
+		yielded++
+		return false
+	})
+	assert.Equal(t, 1, yielded)
+	assert.True(t, atomic.LoadInt32(&fetched) < int32(len(keys)))
+}
@@ -0,0 +1,60 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopyObject(t *testing.T) {
+	var stored []byte
+	retrieve := func() ([]byte, error) {
+		if stored == nil {
+			return nil, errors.New("not found")
+		}
+		return stored, nil
+	}
+	store := func() error {
+		stored = []byte("data")
+		return nil
+	}
+
+	copied, err := copyObject([]byte("data"), retrieve, store, true)
+	require.Nil(t, err)
+	assert.True(t, copied)
+
+	// Already present.
+	copied, err = copyObject([]byte("data"), retrieve, store, true)
+	require.Nil(t, err)
+	assert.False(t, copied)
+
+	// Destination holds the same JSON, formatted differently.
+	stored = []byte(`{"name":"test",  "uuid":"abc"}`)
+	copied, err = copyObject([]byte(`{"uuid":"abc","name":"test"}`), retrieve, store, true)
+	require.Nil(t, err)
+	assert.False(t, copied)
+
+	// Destination does not hold what was written.
+	stored = nil
+	corrupt := func() error {
+		stored = []byte("other")
+		return nil
+	}
+	_, err = copyObject([]byte("data"), retrieve, corrupt, true)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,418 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// DeleteAccount deletes an account along with its metadata.  The deletion is recoverable with RestoreAccount until the
+// account is purged.
+// Note that this does not update the wallet's accounts index; that is up to higher-level functions.
+// This requires version 2 of the KV secrets engine.
+func (s *Store) DeleteAccount(walletID uuid.UUID, accountID uuid.UUID) (err error) {
+	defer func() { s.audit("delete account", walletID.String(), "", accountID.String(), err) }()
+
+	if s.kvVersion != 2 {
+		return errors.New("deletion requires version 2 of the KV secrets engine")
+	}
+	if err := s.Authorize(); err != nil {
+		return err
+	}
+
+	unlock, err := s.lockWallet(walletID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	key := s.accountPath(walletID.String(), accountID.String())
+	if data, err := s.kvRead(key); err != nil {
+		return err
+	} else if data == nil {
+		return errors.New("account not found")
+	}
+	if err := s.kvDelete(key); err != nil {
+		return errors.Wrap(err, "failed to delete account")
+	}
+	s.invalidate(walletID, key)
+
+	return s.deleteMetadata(s.accountMetadataPath(walletID.String(), accountID.String()))
+}
+
+// DeleteAccounts deletes multiple accounts and their metadata from a wallet, removing them from the wallet's accounts index with a single
+// update.  Accounts are deleted concurrently, and accounts that do not exist are ignored.  If any deletions fail the
+// index is still updated for the accounts that were deleted, and the first failure is returned.  As with DeleteAccount
+// each deletion is recoverable with RestoreAccount until it is purged, although the accounts index is not restored.
+// With version 1 of the KV secrets engine deletions are permanent.
+func (s *Store) DeleteAccounts(walletID uuid.UUID, accountIDs []uuid.UUID) (err error) {
+	defer func() { s.audit("delete accounts", walletID.String(), "", "", err) }()
+
+	if err := s.Authorize(); err != nil {
+		return err
+	}
+
+	unlock, err := s.lockWallet(walletID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	keys := make([]string, len(accountIDs))
+	for i := range accountIDs {
+		keys[i] = accountIDs[i].String()
+	}
+	var mu sync.Mutex
+	deleted := make(map[string]bool, len(keys))
+	fetchConcurrently(keys, s.concurrency, func(accountID string) ([]byte, error) {
+		key := s.accountPath(walletID.String(), accountID)
+		if data, err := s.kvRead(key); err != nil {
+			return nil, errors.Wrapf(err, "failed to read account %s", accountID)
+		} else if data == nil {
+			return nil, nil
+		}
+		if err := s.kvDelete(key); err != nil {
+			return nil, errors.Wrapf(err, "failed to delete account %s", accountID)
+		}
+		s.invalidate(walletID, key)
+		if err := s.deleteMetadata(s.accountMetadataPath(walletID.String(), accountID)); err != nil {
+			return nil, errors.Wrapf(err, "failed to delete metadata for account %s", accountID)
+		}
+		s.audit("delete account", walletID.String(), "", accountID, nil)
+		mu.Lock()
+		deleted[accountID] = true
+		mu.Unlock()
+		return nil, nil
+	}, func(_ []byte, deleteErr error) bool {
+		if err == nil {
+			err = deleteErr
+		}
+		return true
+	})
+
+	if indexErr := s.removeFromIndex(walletID, deleted); indexErr != nil && err == nil {
+		err = indexErr
+	}
+
+	return err
+}
+
+// RestoreAccount restores a deleted account, along with any metadata deleted with it.  It will fail if the account has not been deleted, or has been purged.
+func (s *Store) RestoreAccount(walletID uuid.UUID, accountID uuid.UUID) (err error) {
+	defer func() { s.audit("restore account", walletID.String(), "", accountID.String(), err) }()
+
+	if s.kvVersion != 2 {
+		return errors.New("deletion requires version 2 of the KV secrets engine")
+	}
+	if err := s.Authorize(); err != nil {
+		return err
+	}
+
+	unlock, err := s.lockWallet(walletID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	key := s.accountPath(walletID.String(), accountID.String())
+	version, err := s.deletedVersion(key)
+	if err != nil {
+		return err
+	}
+	if err := s.kvUndelete(key, version.Version); err != nil {
+		return errors.Wrap(err, "failed to restore account")
+	}
+	s.invalidate(walletID, key)
+
+	return s.restoreMetadata(s.accountMetadataPath(walletID.String(), accountID.String()), version.Deleted)
+}
+
+// DeleteWallet deletes a wallet along with its accounts, accounts index and metadata.  The deletion is recoverable with
+// RestoreWallet until the wallet is purged.
+// This requires version 2 of the KV secrets engine.
+func (s *Store) DeleteWallet(walletID uuid.UUID) (err error) {
+	defer func() { s.audit("delete wallet", walletID.String(), "", "", err) }()
+
+	if s.kvVersion != 2 {
+		return errors.New("deletion requires version 2 of the KV secrets engine")
+	}
+	if err := s.Authorize(); err != nil {
+		return err
+	}
+
+	unlock, err := s.lockWallet(walletID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	headerKey := s.walletHeaderPath(walletID.String())
+	if data, err := s.kvRead(headerKey); err != nil {
+		return err
+	} else if data == nil {
+		return errors.New("wallet not found")
+	}
+
+	// The header is deleted first so that the wallet disappears before its accounts do.  Accounts deleted along with
+	// the wallet are then those deleted no earlier than the header, which RestoreWallet relies upon.
+	if err := s.kvDelete(headerKey); err != nil {
+		return errors.Wrap(err, "failed to delete wallet")
+	}
+	s.invalidate(walletID, headerKey)
+
+	keys, err := s.kvList(s.walletPath(walletID.String()))
+	if err != nil {
+		return errors.Wrap(err, "failed to list accounts")
+	}
+	for _, key := range keys {
+		if key == walletID.String() || key == "lock" {
+			continue
+		}
+		path := s.accountPath(walletID.String(), key)
+		if data, err := s.kvRead(path); err != nil {
+			return err
+		} else if data == nil {
+			// Already deleted.
+			continue
+		}
+		if err := s.kvDelete(path); err != nil {
+			return errors.Wrapf(err, "failed to delete %s", key)
+		}
+		s.invalidate(walletID, path)
+	}
+
+	// The metadata directory holds the metadata of the wallet itself as well as that of its accounts.
+	metadataKeys, err := s.kvList(s.walletMetadataDirPath(walletID.String()))
+	if err != nil {
+		return errors.Wrap(err, "failed to list metadata")
+	}
+	for _, key := range metadataKeys {
+		if err := s.deleteMetadata(s.accountMetadataPath(walletID.String(), key)); err != nil {
+			return errors.Wrapf(err, "failed to delete metadata for %s", key)
+		}
+	}
+
+	return nil
+}
+
+// RestoreWallet restores a deleted wallet, along with the accounts, accounts index and metadata deleted with it.  Accounts deleted
+// individually before the wallet was deleted are not restored.
+func (s *Store) RestoreWallet(walletID uuid.UUID) (err error) {
+	defer func() { s.audit("restore wallet", walletID.String(), "", "", err) }()
+
+	if s.kvVersion != 2 {
+		return errors.New("deletion requires version 2 of the KV secrets engine")
+	}
+	if err := s.Authorize(); err != nil {
+		return err
+	}
+
+	unlock, err := s.lockWallet(walletID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	headerKey := s.walletHeaderPath(walletID.String())
+	header, err := s.deletedVersion(headerKey)
+	if err != nil {
+		return err
+	}
+
+	keys, err := s.kvList(s.walletPath(walletID.String()))
+	if err != nil {
+		return errors.Wrap(err, "failed to list accounts")
+	}
+	for _, key := range keys {
+		if key == walletID.String() || key == "lock" {
+			continue
+		}
+		path := s.accountPath(walletID.String(), key)
+		version, err := s.deletedVersion(path)
+		if err != nil || version.Deleted.Before(header.Deleted) {
+			// Not deleted, or deleted before the wallet.
+			continue
+		}
+		if err := s.kvUndelete(path, version.Version); err != nil {
+			return errors.Wrapf(err, "failed to restore %s", key)
+		}
+		s.invalidate(walletID, path)
+	}
+
+	metadataKeys, err := s.kvList(s.walletMetadataDirPath(walletID.String()))
+	if err != nil {
+		return errors.Wrap(err, "failed to list metadata")
+	}
+	for _, key := range metadataKeys {
+		if err := s.restoreMetadata(s.accountMetadataPath(walletID.String(), key), header.Deleted); err != nil {
+			return err
+		}
+	}
+
+	// The header is restored last so that the wallet does not reappear without its accounts.
+	if err := s.kvUndelete(headerKey, header.Version); err != nil {
+		return errors.Wrap(err, "failed to restore wallet")
+	}
+	s.invalidate(walletID, headerKey)
+
+	return nil
+}
+
+// Purge permanently removes wallets, accounts, accounts indexes and metadata that were deleted more than retention ago,
+// including all of their previous versions.  Purged data cannot be restored.
+func (s *Store) Purge(retention time.Duration) (err error) {
+	defer func() { s.audit("purge", "", "", "", err) }()
+
+	if s.kvVersion != 2 {
+		return errors.New("deletion requires version 2 of the KV secrets engine")
+	}
+	if err := s.Authorize(); err != nil {
+		return err
+	}
+
+	cutoff := time.Now().Add(-retention)
+	wallets, err := s.kvList(s.walletsPath())
+	if err != nil {
+		return errors.Wrap(err, "failed to list wallets")
+	}
+	for _, wallet := range wallets {
+		walletID, err := uuid.Parse(strings.TrimSuffix(wallet, "/"))
+		if err != nil {
+			// Not a wallet.
+			continue
+		}
+		keys, err := s.kvList(s.walletPath(walletID.String()))
+		if err != nil {
+			return errors.Wrapf(err, "failed to list accounts for wallet %s", walletID)
+		}
+		for _, key := range keys {
+			if key == "lock" {
+				continue
+			}
+			if err := s.purgeDeleted(s.accountPath(walletID.String(), key), cutoff); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Metadata is held apart from the wallets, so is listed separately in case its wallet has already been purged.
+	metadataWallets, err := s.kvList(fmt.Sprintf("%s-metadata", s.Location()))
+	if err != nil {
+		return errors.Wrap(err, "failed to list metadata")
+	}
+	for _, wallet := range metadataWallets {
+		walletID, err := uuid.Parse(strings.TrimSuffix(wallet, "/"))
+		if err != nil {
+			continue
+		}
+		keys, err := s.kvList(s.walletMetadataDirPath(walletID.String()))
+		if err != nil {
+			return errors.Wrapf(err, "failed to list metadata for wallet %s", walletID)
+		}
+		for _, key := range keys {
+			if err := s.purgeDeleted(s.accountMetadataPath(walletID.String(), key), cutoff); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// purgeDeleted permanently removes a key if it was deleted before cutoff.
+func (s *Store) purgeDeleted(key string, cutoff time.Time) error {
+	version, err := s.deletedVersion(key)
+	if err != nil || version.Deleted.After(cutoff) {
+		return nil
+	}
+	if err := s.kvDestroy(key); err != nil {
+		return errors.Wrapf(err, "failed to purge %s", key)
+	}
+	s.log.Debug("Purged deleted object", "key", key, "deleted", version.Deleted)
+
+	return nil
+}
+
+// restoreMetadata restores the metadata held at a key if it was deleted no earlier than since, being deleted along with
+// the object it describes.
+func (s *Store) restoreMetadata(key string, since time.Time) error {
+	version, err := s.deletedVersion(key)
+	if err != nil || version.Deleted.Before(since) {
+		// No metadata, or not deleted along with its object.
+		return nil
+	}
+	if err := s.kvUndelete(key, version.Version); err != nil {
+		return errors.Wrapf(err, "failed to restore %s", key)
+	}
+
+	return nil
+}
+
+// deletedVersion returns the current version of a key, which must have been deleted but not destroyed.
+func (s *Store) deletedVersion(key string) (*ObjectVersion, error) {
+	versions, err := s.kvVersions(key)
+	if err != nil {
+		return nil, err
+	}
+	for _, version := range versions {
+		if !version.Current {
+			continue
+		}
+		if version.Destroyed {
+			return nil, errors.New("permanently removed")
+		}
+		if version.Deleted.IsZero() {
+			return nil, errors.New("not deleted")
+		}
+		return version, nil
+	}
+
+	return nil, errors.New("not found")
+}
+
+// kvDelete deletes the current version of a key.  The data is retained by Vault until it is destroyed.
+func (s *Store) kvDelete(key string) error {
+	return s.callVault("delete", key, func() error {
+		_, err := s.client.Logical().Delete(s.kvPath("data", key))
+		return err
+	})
+}
+
+// kvUndelete restores a deleted version of a key.
+func (s *Store) kvUndelete(key string, version int) error {
+	return s.callVault("undelete", key, func() error {
+		_, err := s.client.Logical().Write(s.kvPath("undelete", key), map[string]interface{}{
+			"versions": []int{version},
+		})
+		return err
+	})
+}
+
+// kvDestroy permanently removes all versions of a key, along with its metadata.
+func (s *Store) kvDestroy(key string) error {
+	err := s.callVault("destroy", key, func() error {
+		_, err := s.client.Logical().Delete(s.kvPath("metadata", key))
+		return err
+	})
+	if err == nil {
+		s.forgetVersion(key)
+	}
+	return err
+}
@@ -0,0 +1,130 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// distributedWallet is the type of wallet whose accounts are shares of keys distributed between participants.
+const distributedWallet = "distributed"
+
+// DistributedAccount describes this participant's share of an account in a distributed wallet, without its secret
+// key share.
+type DistributedAccount struct {
+	// ID is the ID of the account.
+	ID uuid.UUID
+	// Name is the name of the account.
+	Name string
+	// Pubkey is the public key of this participant's share, hex-encoded.
+	Pubkey string
+	// CompositePubkey is the public key of the account as a whole, hex-encoded.
+	CompositePubkey string
+	// SigningThreshold is the number of participants required to sign.
+	SigningThreshold uint32
+	// VerificationVector is the verification vector of the account's shares, each element hex-encoded.
+	VerificationVector []string
+	// Participants maps the ID of each participant to its address.
+	Participants map[uint64]string
+}
+
+// distributedAccountData is the non-secret part of an account in a distributed wallet, as stored.
+type distributedAccountData struct {
+	UUID               string            `json:"uuid"`
+	Name               string            `json:"name"`
+	Pubkey             string            `json:"pubkey"`
+	CompositePubkey    string            `json:"composite_pubkey"`
+	SigningThreshold   uint32            `json:"signing_threshold"`
+	VerificationVector []string          `json:"verification_vector"`
+	Participants       map[string]string `json:"participants"`
+}
+
+// RetrieveDistributedAccounts retrieves the details of this participant's share of each account in a distributed
+// wallet, including the verification vectors and the participants with which the account's key is shared.
+// Secret key shares are not included, and are never decrypted with their account passphrases, so this can be used to
+// configure peers without access to any keys.  Each account is nonetheless retrieved in full, decrypted if the store
+// encrypts its data, and audited as a retrieval of the account.
+func (s *Store) RetrieveDistributedAccounts(walletID uuid.UUID) (_ []*DistributedAccount, err error) {
+	defer func() { s.audit("retrieve distributed accounts", walletID.String(), "", "", err) }()
+
+	walletData, err := s.retrieveWalletByID(walletID)
+	if err != nil {
+		return nil, errors.New("unknown wallet")
+	}
+	if walletType := typeOf(walletData); walletType != distributedWallet {
+		return nil, errors.Errorf("%s wallet is not distributed", walletType)
+	}
+
+	if err := s.authorizeRead(); err != nil {
+		return nil, err
+	}
+
+	accounts := make([]*DistributedAccount, 0)
+	s.eachAccount(walletID, func(data []byte, accountErr error) bool {
+		if accountErr != nil {
+			err = accountErr
+			return false
+		}
+		account, parseErr := parseDistributedAccount(data)
+		if parseErr != nil {
+			err = parseErr
+			return false
+		}
+		accounts = append(accounts, account)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return accounts, nil
+}
+
+// parseDistributedAccount parses the non-secret details of an account in a distributed wallet.
+func parseDistributedAccount(data []byte) (*DistributedAccount, error) {
+	info := &distributedAccountData{}
+	if err := json.Unmarshal(data, info); err != nil {
+		return nil, errors.Wrap(err, "invalid account")
+	}
+	id, err := uuid.Parse(info.UUID)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid account ID")
+	}
+	if info.SigningThreshold == 0 {
+		return nil, errors.Errorf("account %s is not distributed", id)
+	}
+
+	participants := make(map[uint64]string, len(info.Participants))
+	for participant, address := range info.Participants {
+		participantID, err := strconv.ParseUint(participant, 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid participant ID in account %s", id)
+		}
+		participants[participantID] = address
+	}
+
+	return &DistributedAccount{
+		ID:                 id,
+		Name:               info.Name,
+		Pubkey:             info.Pubkey,
+		CompositePubkey:    info.CompositePubkey,
+		SigningThreshold:   info.SigningThreshold,
+		VerificationVector: info.VerificationVector,
+		Participants:       participants,
+	}, nil
+}
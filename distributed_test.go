@@ -0,0 +1,77 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDistributedAccount(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		expected *DistributedAccount
+		err      string
+	}{
+		{
+			name: "Invalid",
+			data: []byte(`[]`),
+			err:  "invalid account",
+		},
+		{
+			name: "NotDistributed",
+			data: []byte(`{"uuid":"c9958061-63d4-4a80-bcf3-25f3dda22340","name":"Test","pubkey":"a99a"}`),
+			err:  "account c9958061-63d4-4a80-bcf3-25f3dda22340 is not distributed",
+		},
+		{
+			name: "BadParticipant",
+			data: []byte(`{"uuid":"c9958061-63d4-4a80-bcf3-25f3dda22340","name":"Test","signing_threshold":2,"participants":{"one":"host1:8881"}}`),
+			err:  "invalid participant ID in account c9958061-63d4-4a80-bcf3-25f3dda22340",
+		},
+		{
+			name: "Good",
+			data: []byte(`{"uuid":"c9958061-63d4-4a80-bcf3-25f3dda22340","name":"Test","pubkey":"a99a","composite_pubkey":"b88b","signing_threshold":2,"verification_vector":["c77c","d66d"],"participants":{"1":"host1:8881","2":"host2:8881","3":"host3:8881"},"crypto":{"checksum":{}}}`),
+			expected: &DistributedAccount{
+				ID:                 uuid.MustParse("c9958061-63d4-4a80-bcf3-25f3dda22340"),
+				Name:               "Test",
+				Pubkey:             "a99a",
+				CompositePubkey:    "b88b",
+				SigningThreshold:   2,
+				VerificationVector: []string{"c77c", "d66d"},
+				Participants: map[uint64]string{
+					1: "host1:8881",
+					2: "host2:8881",
+					3: "host3:8881",
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			account, err := parseDistributedAccount(test.data)
+			if test.err != "" {
+				require.NotNil(t, err)
+				assert.Equal(t, test.err, err.Error()[:len(test.err)])
+			} else {
+				require.Nil(t, err)
+				assert.Equal(t, test.expected, account)
+			}
+		})
+	}
+}
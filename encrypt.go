@@ -13,38 +13,274 @@
 
 package vault
 
-// encryptIfRequired encrypts data if required.
-func (s *Store) encryptIfRequired(data []byte) ([]byte, error) {
-	// if len(data) == 0 {
-	// 	return data, nil
-	// }
-	// if len(data) < 16 {
-	// 	return nil, errors.New("data must be at least 16 bytes")
-	// }
-	// var err error
-	// if len(s.passphrase) > 0 {
-	// 	data, err = ecodec.Encrypt(data, s.passphrase)
-	// }
-	// return data, err
-
-	// Implementation is currently mocked out
-	return data, nil
-}
-
-// decryptIfRequired decrypts data if required.
-func (s *Store) decryptIfRequired(data []byte) ([]byte, error) {
-	// if len(data) == 0 {
-	// 	return data, nil
-	// }
-	// if len(data) < 16 {
-	// 	return nil, errors.New("data must be at least 16 bytes")
-	// }
-	// var err error
-	// if len(s.passphrase) > 0 {
-	// 	data, err = ecodec.Decrypt(data, s.passphrase)
-	// }
-	// return data, err
-
-	// Implementation is currently mocked out
-	return data, nil
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+const (
+	// encryptionPassphrase is the encryption type for data encrypted with a passphrase.
+	encryptionPassphrase = "passphrase"
+	// encryptionTransit is the encryption type for data encrypted by Vault's Transit secrets engine.
+	encryptionTransit = "vault-transit"
+	// compressionGzip is the compression type for gzip-compressed data.
+	compressionGzip = "gzip"
+	// envelopeVersion is the version of the envelope format written by this store.  Envelopes written before the format
+	// was versioned have no version; version 2 added the cipher and salt of data encrypted with a passphrase, and version 3
+	// its key derivation.
+	envelopeVersion = 3
+)
+
+// envelope is the form in which encrypted, compressed or checksummed data is stored.
+// Vault only stores JSON objects, so binary data is held base64-encoded: encrypted data in ciphertext, and data that is
+// compressed but not encrypted in payload.  Data that is only checksummed is held as-is in plaintext.
+// Version is the version of the envelope format, and Created the time at which the envelope was written, in seconds since
+// the Unix epoch.  Cipher, Salt and KDF record the cipher, key derivation salt and key derivation of data encrypted with a
+// passphrase; data encrypted with a passphrase without a cipher was encrypted with go-ecodec, and data with a salt but no
+// key derivation had its key derived with scrypt.
+type envelope struct {
+	Version     int             `json:"version,omitempty"`
+	Created     int64           `json:"created,omitempty"`
+	Encryption  string          `json:"encryption,omitempty"`
+	Cipher      string          `json:"cipher,omitempty"`
+	Salt        string          `json:"salt,omitempty"`
+	KDF         *kdfParams      `json:"kdf,omitempty"`
+	Key         string          `json:"key,omitempty"`
+	Compression string          `json:"compression,omitempty"`
+	Ciphertext  string          `json:"ciphertext,omitempty"`
+	Payload     string          `json:"payload,omitempty"`
+	Plaintext   json.RawMessage `json:"plaintext,omitempty"`
+	Checksum    string          `json:"checksum,omitempty"`
+}
+
+// openEnvelope returns the envelope held in data, or nil if data is not an envelope.
+func openEnvelope(data []byte) *envelope {
+	env := &envelope{}
+	if err := json.Unmarshal(data, env); err != nil {
+		return nil
+	}
+	if env.Encryption != "" && env.Ciphertext != "" {
+		return env
+	}
+	if env.Compression != "" && env.Payload != "" {
+		return env
+	}
+	if env.Checksum != "" && len(env.Plaintext) > 0 {
+		return env
+	}
+	return nil
+}
+
+// encryptIfRequired encrypts data belonging to the given wallet if required.
+func (s *Store) encryptIfRequired(walletID uuid.UUID, data []byte) ([]byte, error) {
+	passphrase, err := s.walletPassphrase(walletID)
+	if err != nil {
+		return nil, err
+	}
+	return s.seal(data, passphrase, s.transitKey)
+}
+
+// decryptIfRequired decrypts data belonging to the given wallet if required.
+func (s *Store) decryptIfRequired(walletID uuid.UUID, data []byte) ([]byte, error) {
+	passphrase, err := s.walletPassphrase(walletID)
+	if err != nil {
+		return nil, err
+	}
+	return s.unseal(data, passphrase)
+}
+
+// walletPassphrase returns the passphrase with which data belonging to the given wallet is encrypted: that supplied by
+// the store's key provider if it has one, otherwise the store's passphrase.
+func (s *Store) walletPassphrase(walletID uuid.UUID) ([]byte, error) {
+	if s.keyProvider == nil {
+		return s.passphrase, nil
+	}
+	passphrase, err := s.keyProvider(walletID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to obtain passphrase for wallet %s", walletID)
+	}
+	if len(passphrase) == 0 {
+		return s.passphrase, nil
+	}
+	return passphrase, nil
+}
+
+// seal places data in an envelope according to the store's configuration, encrypting it with the transit key if
+// supplied or otherwise the passphrase if supplied.  If no envelope is required data is returned as-is.
+func (s *Store) seal(data []byte, passphrase []byte, transitKey string) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+	if transitKey == "" && len(passphrase) == 0 && !s.checksums && !s.compression {
+		return data, nil
+	}
+
+	env := &envelope{
+		Version: envelopeVersion,
+		Created: time.Now().Unix(),
+	}
+	var err error
+	// Data encrypted with a transit key is authenticated by Transit, and an unkeyed hash of it alongside the ciphertext
+	// would allow guesses of the plaintext to be confirmed, so it is not checksummed.
+	if s.checksums && transitKey == "" {
+		if env.Checksum, err = checksum(data, passphrase); err != nil {
+			return nil, err
+		}
+	}
+
+	payload := data
+	if s.compression {
+		if payload, err = compress(payload); err != nil {
+			return nil, errors.Wrap(err, "failed to compress")
+		}
+		env.Compression = compressionGzip
+	}
+
+	switch {
+	case transitKey != "":
+		env.Encryption = encryptionTransit
+		env.Key = transitKey
+		if env.Ciphertext, err = s.transitEncrypt(transitKey, payload); err != nil {
+			return nil, errors.Wrap(err, "failed to encrypt with transit")
+		}
+	case len(passphrase) > 0:
+		ciphertext, err := s.encryptWithPassphrase(env, payload, passphrase)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to encrypt with passphrase")
+		}
+		env.Encryption = encryptionPassphrase
+		env.Ciphertext = base64.StdEncoding.EncodeToString(ciphertext)
+	case s.compression:
+		env.Payload = base64.StdEncoding.EncodeToString(payload)
+	default:
+		env.Plaintext = data
+	}
+
+	return json.Marshal(env)
+}
+
+// unseal returns the data held in an envelope, decrypting it with the given passphrase if it was encrypted with a
+// passphrase.  Data that is not in an envelope is returned as-is.
+func (s *Store) unseal(data []byte, passphrase []byte) ([]byte, error) {
+	env := openEnvelope(data)
+	if env == nil {
+		return data, nil
+	}
+	if env.Version > envelopeVersion {
+		return nil, errors.Errorf("unsupported envelope version %d", env.Version)
+	}
+
+	var payload []byte
+	var err error
+	switch env.Encryption {
+	case "":
+		if env.Payload != "" {
+			if payload, err = base64.StdEncoding.DecodeString(env.Payload); err != nil {
+				return nil, errors.Wrap(err, "invalid payload")
+			}
+		} else {
+			payload = env.Plaintext
+		}
+	case encryptionPassphrase:
+		if len(passphrase) == 0 {
+			return nil, errors.New("data is encrypted but no passphrase supplied")
+		}
+		ciphertext, err := base64.StdEncoding.DecodeString(env.Ciphertext)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid ciphertext")
+		}
+		if payload, err = s.decryptWithPassphrase(env, ciphertext, passphrase); err != nil {
+			return nil, errors.Wrap(err, "failed to decrypt with passphrase")
+		}
+	case encryptionTransit:
+		if payload, err = s.transitDecrypt(env.Key, env.Ciphertext); err != nil {
+			return nil, errors.Wrap(err, "failed to decrypt with transit")
+		}
+	default:
+		return nil, errors.Errorf("unsupported encryption %q", env.Encryption)
+	}
+
+	plaintext := payload
+	switch env.Compression {
+	case "":
+	case compressionGzip:
+		if plaintext, err = decompress(payload); err != nil {
+			return nil, errors.Wrap(err, "failed to decompress")
+		}
+	default:
+		return nil, errors.Errorf("unsupported compression %q", env.Compression)
+	}
+
+	if env.Checksum != "" {
+		var checksumKey []byte
+		if env.Encryption == encryptionPassphrase {
+			checksumKey = passphrase
+		}
+		sum, err := checksum(plaintext, checksumKey)
+		if err != nil {
+			return nil, err
+		}
+		if !hmac.Equal([]byte(sum), []byte(env.Checksum)) {
+			return nil, errors.New("checksum mismatch; data is corrupt")
+		}
+	}
+
+	return plaintext, nil
+}
+
+// checksum calculates the checksum of JSON data.  If a key is supplied the checksum is an HMAC with that key, so that
+// it does not reveal information about encrypted data.
+// The checksum is calculated over a canonical form of the data, as Vault does not preserve the formatting of stored JSON.
+func checksum(data []byte, key []byte) (string, error) {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return "", errors.Wrap(err, "data is not JSON")
+	}
+	canonical, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	if len(key) == 0 {
+		sum := sha256.Sum256(canonical)
+		return "sha256:" + hex.EncodeToString(sum[:]), nil
+	}
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write(canonical)
+	return "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// compress compresses data with gzip.
+func compress(data []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	writer := gzip.NewWriter(buf)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress decompresses gzip-compressed data.
+func decompress(data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
 }
@@ -15,29 +15,23 @@ package vault_test
 
 import (
 	"fmt"
-	"math/rand"
 	"testing"
-	"time"
 
 	"github.com/google/uuid"
+	vault "github.com/stakedllc/go-eth2-wallet-store-vault"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
-	vault "github.com/Stakedllc/go-eth2-wallet-store-vault"
 )
 
 func TestStoreRetrieveEncryptedWallet(t *testing.T) {
-	rand.Seed(time.Now().Unix())
-	id := fmt.Sprintf("%s-%d", t.Name(), rand.Int31())
-	store, err := vault.New(vault.WithID([]byte(id)), vault.WithPassphrase([]byte("test")))
-	if err != nil {
-		t.Skip("unable to access Vault; skipping test")
-	}
+	store := newStore(t, vault.WithPassphrase([]byte("test")))
+	defer store.Close()
 
 	walletID := uuid.New()
 	walletName := "test"
 	data := []byte(fmt.Sprintf(`{"uuid":%q,"name":%q}`, walletID, walletName))
 
-	err = store.StoreWallet(walletID, walletName, data)
+	err := store.StoreWallet(walletID, walletName, data)
 	require.Nil(t, err)
 	retData, err := store.RetrieveWallet(walletName)
 	require.Nil(t, err)
@@ -53,12 +47,8 @@ func TestStoreRetrieveEncryptedWallet(t *testing.T) {
 }
 
 func TestStoreRetrieveEncryptedAccount(t *testing.T) {
-	rand.Seed(time.Now().Unix())
-	id := fmt.Sprintf("%s-%d", t.Name(), rand.Int31())
-	store, err := vault.New(vault.WithID([]byte(id)), vault.WithPassphrase([]byte("test")))
-	if err != nil {
-		t.Skip("unable to access Vault; skipping test")
-	}
+	store := newStore(t, vault.WithPassphrase([]byte("test")))
+	defer store.Close()
 
 	walletID := uuid.New()
 	walletName := "test wallet"
@@ -67,7 +57,7 @@ func TestStoreRetrieveEncryptedAccount(t *testing.T) {
 	accountName := "test account"
 	accountData := []byte(fmt.Sprintf(`{"name":%q,"uuid":%q}`, accountName, accountID.String()))
 
-	err = store.StoreWallet(walletID, walletName, walletData)
+	err := store.StoreWallet(walletID, walletName, walletData)
 	require.Nil(t, err)
 
 	err = store.StoreAccount(walletID, accountID, accountData)
@@ -84,23 +74,20 @@ func TestStoreRetrieveEncryptedAccount(t *testing.T) {
 }
 
 func TestBadWalletKey(t *testing.T) {
-	rand.Seed(time.Now().Unix())
-	id := fmt.Sprintf("%s-%d", t.Name(), rand.Int31())
-	store, err := vault.New(vault.WithID([]byte(id)), vault.WithPassphrase([]byte("test")))
-	if err != nil {
-		t.Skip("unable to access Vault; skipping test")
-	}
+	mount := fmt.Sprintf("test-%s", uuid.New())
+	store := newStore(t, vault.WithKVMount(mount), vault.WithPassphrase([]byte("test")))
+	defer store.Close()
 
 	walletID := uuid.New()
 	walletName := "test wallet"
 	data := []byte(fmt.Sprintf(`{"uuid":%q,"name":%q}`, walletID, walletName))
 
-	err = store.StoreWallet(walletID, walletName, data)
+	err := store.StoreWallet(walletID, walletName, data)
 	require.Nil(t, err)
 
 	// Open wallet with store with different key; should fail
-	store, err = vault.New(vault.WithID([]byte(id)), vault.WithPassphrase([]byte("badkey")))
-	require.Nil(t, err)
-	_, err = store.RetrieveWallet(walletName)
+	badStore := newStore(t, vault.WithKVMount(mount), vault.WithPassphrase([]byte("badkey")))
+	defer badStore.Close()
+	_, err = badStore.RetrieveWallet(walletName)
 	require.NotNil(t, err)
 }
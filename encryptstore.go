@@ -0,0 +1,96 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"bytes"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// Encrypt encrypts every unencrypted wallet and account in the store, using the store's passphrase or transit key.
+// Each object is read back and checked after it has been rewritten.
+// If supplied, progress is called after each object is processed; objects that were already encrypted are reported as
+// skipped.  Encryption stops at the first failure, and can be resumed by calling Encrypt again.
+func (s *Store) Encrypt(progress func(*MaintenanceProgress)) error {
+	if s.transitKey == "" && len(s.passphrase) == 0 && s.keyProvider == nil {
+		return errors.New("store has no passphrase or transit key with which to encrypt")
+	}
+
+	if err := s.Authorize(); err != nil {
+		return err
+	}
+
+	status := &MaintenanceProgress{}
+	return s.walkObjects(func(kind string, walletID uuid.UUID, key string) error {
+		encrypted, err := s.encryptObject(walletID, key)
+		if err != nil {
+			return errors.Wrapf(err, "failed to encrypt %s %s", kind, key)
+		}
+		status.report(key, encrypted, progress)
+		return nil
+	})
+}
+
+// encryptObject encrypts a single object if it is not already encrypted, returning true if it was rewritten.
+func (s *Store) encryptObject(walletID uuid.UUID, key string) (bool, error) {
+	unlock, err := s.lockWallet(walletID)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	data, err := s.readObject(key)
+	if err != nil {
+		return false, err
+	}
+	if data == nil {
+		return false, nil
+	}
+	if env := openEnvelope(data); env != nil {
+		if env.Encryption != "" {
+			// Already encrypted.
+			return false, nil
+		}
+		// Checksummed but unencrypted.
+		if data, err = s.decryptIfRequired(walletID, data); err != nil {
+			return false, err
+		}
+	}
+
+	ciphertext, err := s.encryptIfRequired(walletID, data)
+	if err != nil {
+		return false, err
+	}
+	if err := s.kvWrite(key, ciphertext); err != nil {
+		return false, err
+	}
+	s.invalidate(walletID, key)
+
+	// Confirm that the object as now stored decrypts to the original data.
+	stored, err := s.readObject(key)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to read back encrypted data")
+	}
+	plaintext, err := s.decryptIfRequired(walletID, stored)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to decrypt encrypted data")
+	}
+	if !bytes.Equal(plaintext, data) {
+		return false, errors.New("encrypted data does not match original")
+	}
+
+	return true, nil
+}
@@ -0,0 +1,361 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPassphraseEnvelope(t *testing.T) {
+	store := &Store{
+		checksums: true,
+	}
+	data := []byte(`{"name":"test account","uuid":"c9958061-63d4-4a80-bcf3-25f3dda22340"}`)
+
+	ciphertext, err := store.seal(data, []byte("secret"), "")
+	require.Nil(t, err)
+	env := openEnvelope(ciphertext)
+	require.NotNil(t, env)
+	assert.Equal(t, encryptionPassphrase, env.Encryption)
+
+	decrypted, err := store.unseal(ciphertext, []byte("secret"))
+	require.Nil(t, err)
+	assert.Equal(t, data, decrypted)
+
+	_, err = store.unseal(ciphertext, []byte("wrong"))
+	assert.NotNil(t, err)
+
+	_, err = store.unseal(ciphertext, nil)
+	assert.NotNil(t, err)
+
+	// Unencrypted data is returned as-is regardless of passphrase.
+	decrypted, err = store.unseal(data, []byte("secret"))
+	require.Nil(t, err)
+	assert.Equal(t, data, decrypted)
+}
+
+func TestChecksumEnvelope(t *testing.T) {
+	store := &Store{
+		checksums: true,
+	}
+	data := []byte(`{"name":"test account","uuid":"c9958061-63d4-4a80-bcf3-25f3dda22340"}`)
+
+	stored, err := store.encryptIfRequired(uuid.Nil, data)
+	require.Nil(t, err)
+	env := openEnvelope(stored)
+	require.NotNil(t, env)
+	assert.Equal(t, "", env.Encryption)
+
+	retrieved, err := store.decryptIfRequired(uuid.Nil, stored)
+	require.Nil(t, err)
+	assert.Equal(t, data, retrieved)
+
+	// Formatting changes do not affect the checksum.
+	env.Plaintext = []byte(`{"uuid": "c9958061-63d4-4a80-bcf3-25f3dda22340", "name": "test account"}`)
+	reformatted, err := json.Marshal(env)
+	require.Nil(t, err)
+	_, err = store.decryptIfRequired(uuid.Nil, reformatted)
+	assert.Nil(t, err)
+
+	// Content changes do.
+	env.Plaintext = []byte(`{"name":"test accounts","uuid":"c9958061-63d4-4a80-bcf3-25f3dda22340"}`)
+	corrupted, err := json.Marshal(env)
+	require.Nil(t, err)
+	_, err = store.decryptIfRequired(uuid.Nil, corrupted)
+	assert.NotNil(t, err)
+}
+
+func TestCompressedEnvelope(t *testing.T) {
+	data := []byte(`{"name":"test account","uuid":"c9958061-63d4-4a80-bcf3-25f3dda22340"}`)
+
+	for _, passphrase := range [][]byte{nil, []byte("secret")} {
+		store := &Store{
+			passphrase:  passphrase,
+			compression: true,
+			checksums:   true,
+		}
+
+		stored, err := store.encryptIfRequired(uuid.Nil, data)
+		require.Nil(t, err)
+		env := openEnvelope(stored)
+		require.NotNil(t, env)
+		assert.Equal(t, compressionGzip, env.Compression)
+
+		retrieved, err := store.decryptIfRequired(uuid.Nil, stored)
+		require.Nil(t, err)
+		assert.Equal(t, data, retrieved)
+	}
+}
+
+func TestUnsealedData(t *testing.T) {
+	store := &Store{}
+	data := []byte(`{"name":"test account","uuid":"c9958061-63d4-4a80-bcf3-25f3dda22340"}`)
+
+	// No options leaves data untouched.
+	stored, err := store.encryptIfRequired(uuid.Nil, data)
+	require.Nil(t, err)
+	assert.Equal(t, data, stored)
+	assert.Nil(t, openEnvelope(stored))
+}
+
+func TestEnvelopeVersion(t *testing.T) {
+	store := &Store{
+		checksums: true,
+	}
+	data := []byte(`{"name":"test account","uuid":"c9958061-63d4-4a80-bcf3-25f3dda22340"}`)
+
+	stored, err := store.seal(data, []byte("secret"), "")
+	require.Nil(t, err)
+	env := openEnvelope(stored)
+	require.NotNil(t, env)
+	assert.Equal(t, envelopeVersion, env.Version)
+	assert.True(t, env.Created > 0)
+	assert.False(t, needsUpgrade(env))
+
+	// Envelopes written before the format was versioned are still read.
+	env.Version = 0
+	env.Created = 0
+	legacy, err := json.Marshal(env)
+	require.Nil(t, err)
+	legacyEnv := openEnvelope(legacy)
+	require.NotNil(t, legacyEnv)
+	assert.True(t, needsUpgrade(legacyEnv))
+	retrieved, err := store.unseal(legacy, []byte("secret"))
+	require.Nil(t, err)
+	assert.Equal(t, data, retrieved)
+
+	// Envelopes of later formats are refused.
+	env.Version = envelopeVersion + 1
+	future, err := json.Marshal(env)
+	require.Nil(t, err)
+	_, err = store.unseal(future, []byte("secret"))
+	assert.NotNil(t, err)
+
+	// Data not in an envelope does not need upgrading.
+	assert.False(t, needsUpgrade(openEnvelope(data)))
+}
+
+func TestKeyProvider(t *testing.T) {
+	walletA := uuid.New()
+	walletB := uuid.New()
+	walletC := uuid.New()
+	store := &Store{
+		passphrase: []byte("store secret"),
+		checksums:  true,
+		keyProvider: func(walletID uuid.UUID) ([]byte, error) {
+			switch walletID {
+			case walletA:
+				return []byte("secret A"), nil
+			case walletB:
+				return []byte("secret B"), nil
+			case walletC:
+				return nil, nil
+			}
+			return nil, errors.New("unknown wallet")
+		},
+	}
+	data := []byte(`{"name":"test account","uuid":"c9958061-63d4-4a80-bcf3-25f3dda22340"}`)
+
+	stored, err := store.encryptIfRequired(walletA, data)
+	require.Nil(t, err)
+	retrieved, err := store.decryptIfRequired(walletA, stored)
+	require.Nil(t, err)
+	assert.Equal(t, data, retrieved)
+
+	// Other wallets' passphrases cannot decrypt the data.
+	_, err = store.decryptIfRequired(walletB, stored)
+	assert.NotNil(t, err)
+	_, err = store.unseal(stored, store.passphrase)
+	assert.NotNil(t, err)
+
+	// An empty passphrase falls back to the store's passphrase.
+	stored, err = store.encryptIfRequired(walletC, data)
+	require.Nil(t, err)
+	retrieved, err = store.unseal(stored, store.passphrase)
+	require.Nil(t, err)
+	assert.Equal(t, data, retrieved)
+
+	// Provider failures are returned.
+	_, err = store.encryptIfRequired(uuid.New(), data)
+	assert.NotNil(t, err)
+}
+
+func TestCiphers(t *testing.T) {
+	data := []byte(`{"name":"test account","uuid":"c9958061-63d4-4a80-bcf3-25f3dda22340"}`)
+
+	tests := []struct {
+		name   string
+		cipher Cipher
+		stored string
+	}{
+		{
+			name:   "Default",
+			stored: string(CipherAES256GCM),
+		},
+		{
+			name:   "AES256GCM",
+			cipher: CipherAES256GCM,
+			stored: string(CipherAES256GCM),
+		},
+		{
+			name:   "XChaCha20Poly1305",
+			cipher: CipherXChaCha20Poly1305,
+			stored: string(CipherXChaCha20Poly1305),
+		},
+		{
+			name:   "ECodec",
+			cipher: CipherECodec,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := &Store{
+				cipher:    test.cipher,
+				keys:      newKeyCache(),
+				checksums: true,
+			}
+
+			sealed, err := store.seal(data, []byte("secret"), "")
+			require.Nil(t, err)
+			env := openEnvelope(sealed)
+			require.NotNil(t, env)
+			assert.Equal(t, test.stored, env.Cipher)
+
+			unsealed, err := store.unseal(sealed, []byte("secret"))
+			require.Nil(t, err)
+			assert.Equal(t, data, unsealed)
+
+			_, err = store.unseal(sealed, []byte("wrong"))
+			assert.NotNil(t, err)
+
+			// Data is read whatever the store's cipher.
+			other := &Store{
+				cipher: CipherXChaCha20Poly1305,
+			}
+			unsealed, err = other.unseal(sealed, []byte("secret"))
+			require.Nil(t, err)
+			assert.Equal(t, data, unsealed)
+		})
+	}
+}
+
+func TestCipherNonces(t *testing.T) {
+	store := &Store{
+		keys: newKeyCache(),
+	}
+	data := []byte(`{"name":"test account"}`)
+
+	first, err := store.seal(data, []byte("secret"), "")
+	require.Nil(t, err)
+	second, err := store.seal(data, []byte("secret"), "")
+	require.Nil(t, err)
+
+	// The salt, and so the key, is shared but each object has its own nonce.
+	firstEnv := openEnvelope(first)
+	secondEnv := openEnvelope(second)
+	assert.Equal(t, firstEnv.Salt, secondEnv.Salt)
+	assert.NotEqual(t, firstEnv.Ciphertext, secondEnv.Ciphertext)
+	assert.Len(t, store.keys.keys, 1)
+}
+
+func TestNeedsReencryption(t *testing.T) {
+	data := []byte(`{"name":"test account","uuid":"c9958061-63d4-4a80-bcf3-25f3dda22340"}`)
+	aes := &Store{
+		cipher:    CipherAES256GCM,
+		keys:      newKeyCache(),
+		checksums: true,
+	}
+	chacha := &Store{
+		cipher:    CipherXChaCha20Poly1305,
+		keys:      newKeyCache(),
+		checksums: true,
+	}
+	legacy := &Store{
+		cipher:    CipherECodec,
+		keys:      newKeyCache(),
+		checksums: true,
+	}
+
+	sealed, err := aes.seal(data, []byte("secret"), "")
+	require.Nil(t, err)
+	env := openEnvelope(sealed)
+	require.NotNil(t, env)
+	assert.False(t, aes.needsReencryption(env))
+	assert.True(t, chacha.needsReencryption(env))
+	assert.True(t, legacy.needsReencryption(env))
+
+	sealed, err = legacy.seal(data, []byte("secret"), "")
+	require.Nil(t, err)
+	env = openEnvelope(sealed)
+	require.NotNil(t, env)
+	assert.False(t, legacy.needsReencryption(env))
+	assert.True(t, aes.needsReencryption(env))
+
+	// Unencrypted data is not re-encrypted.
+	sealed, err = aes.seal(data, nil, "")
+	require.Nil(t, err)
+	assert.False(t, chacha.needsReencryption(openEnvelope(sealed)))
+}
+
+func TestTransitEnvelope(t *testing.T) {
+	// A stand-in for the Transit secrets engine, whose "ciphertext" is the base64-encoded plaintext.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := make(map[string]string)
+		require.Nil(t, json.NewDecoder(r.Body).Decode(&request))
+		switch r.URL.Path {
+		case "/v1/transit/encrypt/key":
+			_, _ = fmt.Fprintf(w, `{"data":{"ciphertext":"vault:v1:%s"}}`, request["plaintext"])
+		case "/v1/transit/decrypt/key":
+			_, _ = fmt.Fprintf(w, `{"data":{"plaintext":%q}}`, strings.TrimPrefix(request["ciphertext"], "vault:v1:"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	client, err := api.NewClient(&api.Config{Address: server.URL})
+	require.Nil(t, err)
+
+	store := &Store{
+		log:          nopLogger{},
+		client:       client,
+		transitMount: "transit",
+		transitKey:   "key",
+		checksums:    true,
+	}
+	data := []byte(`{"name":"test account","uuid":"c9958061-63d4-4a80-bcf3-25f3dda22340"}`)
+
+	stored, err := store.encryptIfRequired(uuid.Nil, data)
+	require.Nil(t, err)
+	env := openEnvelope(stored)
+	require.NotNil(t, env)
+	assert.Equal(t, encryptionTransit, env.Encryption)
+	// An unkeyed hash of the plaintext would allow guesses to be confirmed, so is not stored.
+	assert.Equal(t, "", env.Checksum)
+
+	retrieved, err := store.decryptIfRequired(uuid.Nil, stored)
+	require.Nil(t, err)
+	assert.Equal(t, data, retrieved)
+}
@@ -0,0 +1,196 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// StoreError is returned when an operation in Vault fails.  It records where the failure occurred, so that callers can
+// report it without parsing its message, and whether it is likely to be transient and so worth retrying.
+type StoreError struct {
+	// Op is the Vault operation that failed, for example "read" or "write".
+	Op string
+	// Backend is the name of the backend in which the operation failed; always "vault".
+	Backend string
+	// Store is the URL of the store, as returned by its URL method.
+	Store string
+	// Path is the path on which the operation was carried out.  Paths of wallets and accounts are relative to the store's
+	// KV secrets engine.
+	Path string
+	// WalletID is the ID of the wallet to which the path relates, or uuid.Nil if it does not relate to a wallet.
+	WalletID uuid.UUID
+	// AccountID is the ID of the account to which the path relates, or uuid.Nil if it does not relate to an account.
+	AccountID uuid.UUID
+	// Err is the error returned by Vault.
+	Err error
+
+	transient bool
+}
+
+// Error implements the error interface.
+func (e *StoreError) Error() string {
+	return fmt.Sprintf("%s %s %s (store %s): %v", e.Backend, e.Op, e.Path, e.Store, e.Err)
+}
+
+// Unwrap returns the error returned by Vault.
+func (e *StoreError) Unwrap() error {
+	return e.Err
+}
+
+// Cause returns the error returned by Vault.
+func (e *StoreError) Cause() error {
+	return e.Err
+}
+
+// IsTransient returns true if the failure is likely to be transient, as decided by the store's retry policy, in which
+// case the operation can be retried later.  The store will already have retried the operation according to its policy.
+func (e *StoreError) IsTransient() bool {
+	return e.transient
+}
+
+// IsTransient returns true if err, or any error that it wraps, is likely to be transient.
+func IsTransient(err error) bool {
+	var transient interface{ IsTransient() bool }
+	return errors.As(err, &transient) && transient.IsTransient()
+}
+
+// AuthFailure is the reason for which the store failed to log in to Vault.
+type AuthFailure string
+
+const (
+	// AuthFailureSealed is a failure to log in because Vault is sealed.
+	AuthFailureSealed AuthFailure = "sealed"
+	// AuthFailurePermissionDenied is a failure to log in because Vault rejected the store's credentials.
+	AuthFailurePermissionDenied AuthFailure = "permission denied"
+	// AuthFailureNetwork is a failure to log in because Vault could not be reached.
+	AuthFailureNetwork AuthFailure = "network"
+	// AuthFailureCancelled is a failure to log in because the context was done or the store was closed.
+	AuthFailureCancelled AuthFailure = "cancelled"
+	// AuthFailureOther is a failure to log in for any other reason.
+	AuthFailureOther AuthFailure = "other"
+)
+
+// AuthError is returned when the store fails to log in to Vault, in which case operations that require Vault are not
+// attempted.
+type AuthError struct {
+	// Reason is the reason for which logging in failed.
+	Reason AuthFailure
+	// Attempts is the number of attempts made to log in.
+	Attempts int
+	// Err is the error returned by the final attempt.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("failed to log in to vault (%s) after %d attempt(s): %v", e.Reason, e.Attempts, e.Err)
+}
+
+// Unwrap returns the error returned by the final attempt.
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// Cause returns the error returned by the final attempt.
+func (e *AuthError) Cause() error {
+	return e.Err
+}
+
+// IsTransient returns true if logging in is likely to succeed if tried again later.
+func (e *AuthError) IsTransient() bool {
+	switch e.Reason {
+	case AuthFailureSealed, AuthFailureNetwork:
+		return true
+	case AuthFailureOther:
+		return IsTransient(e.Err)
+	default:
+		return false
+	}
+}
+
+// ConflictError is returned when a write is rejected because the data has been changed by another writer since it
+// was last read by this store.  The caller can retrieve the data again and retry the write.
+type ConflictError struct {
+	Key string
+}
+
+// Error implements the error interface.
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s has been modified by another writer", e.Key)
+}
+
+// UnavailableError is returned without contacting Vault when the circuit breaker has been tripped by repeated
+// failures.  Operations will be attempted again once the breaker's cooldown has expired.
+type UnavailableError struct {
+	RetryAt time.Time
+}
+
+// Error implements the error interface.
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("vault unavailable; retry after %s", e.RetryAt.Format(time.RFC3339))
+}
+
+// IsTransient returns true, as Vault will be tried again once the circuit breaker's cooldown has expired.
+func (e *UnavailableError) IsTransient() bool {
+	return true
+}
+
+// RateLimitedError is returned when Vault rejects a request because too many requests have been made, in which case the
+// request has not been carried out.  RetryAfter is the time Vault asked to be left before further requests, if it gave
+// one.
+type RateLimitedError struct {
+	RetryAfter time.Duration
+}
+
+// Error implements the error interface.
+func (e *RateLimitedError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("vault rate limit exceeded; retry after %s", e.RetryAfter)
+	}
+	return "vault rate limit exceeded"
+}
+
+// IsTransient returns true, as Vault will accept requests again once the rate limit allows.
+func (e *RateLimitedError) IsTransient() bool {
+	return true
+}
+
+// SealedError is returned when Vault is sealed, and so cannot serve requests until it is unsealed.
+type SealedError struct{}
+
+// Error implements the error interface.
+func (e *SealedError) Error() string {
+	return "vault is sealed"
+}
+
+// IsTransient returns true, as Vault can serve requests again once it is unsealed.
+func (e *SealedError) IsTransient() bool {
+	return true
+}
+
+// ValidationError is returned when payload validation is enabled and the data supplied to be stored is not a
+// well-formed wallet or account.
+type ValidationError struct {
+	Reason string
+}
+
+// Error implements the error interface.
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid payload: %s", e.Reason)
+}
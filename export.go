@@ -0,0 +1,132 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// Archive entries are named <wallet ID>/wallet.json for the wallet, <wallet ID>/index.json for its accounts index and
+// <wallet ID>/accounts/<account ID>.json for each account.
+const (
+	archiveWalletName = "wallet.json"
+	archiveIndexName  = "index.json"
+	archiveAccountDir = "accounts"
+)
+
+// Export writes every wallet, accounts index and account in the store to w as a gzipped tar archive, suitable for
+// offline backup and restoration with Import.
+// Data is written unencrypted by the store, so if a passphrase is supplied wallets and accounts are encrypted with it in
+// the archive; otherwise the archive should be protected by other means.
+func (s *Store) Export(w io.Writer, passphrase []byte) (err error) {
+	defer func() { s.audit("export", "", "", "", err) }()
+
+	if err := s.Authorize(); err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	// Keys for the archive are derived afresh rather than shared with the store.
+	keys := newKeyCache()
+
+	err = s.walkObjects(func(kind string, walletID uuid.UUID, key string) error {
+		data, err := s.readObject(key)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read %s", key)
+		}
+		if data == nil {
+			// Removed since it was listed.
+			return nil
+		}
+		if data, err = s.decryptIfRequired(walletID, data); err != nil {
+			return errors.Wrapf(err, "failed to decrypt %s", key)
+		}
+		if len(passphrase) > 0 {
+			if data, err = sealExport(data, passphrase, keys); err != nil {
+				return errors.Wrapf(err, "failed to encrypt %s", key)
+			}
+		}
+
+		if kind == objectWallet {
+			if err := writeArchiveEntry(tw, path.Join(walletID.String(), archiveWalletName), data, now); err != nil {
+				return err
+			}
+			index, err := s.RetrieveAccountsIndex(walletID)
+			if err != nil {
+				// Wallets without accounts have no index.
+				s.log.Debug("Not exporting accounts index", "wallet", walletID, "error", err)
+				return nil
+			}
+			return writeArchiveEntry(tw, path.Join(walletID.String(), archiveIndexName), index, now)
+		}
+
+		return writeArchiveEntry(tw, path.Join(walletID.String(), archiveAccountDir, fmt.Sprintf("%s.json", path.Base(key))), data, now)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return errors.Wrap(err, "failed to finish archive")
+	}
+	return gz.Close()
+}
+
+// sealExport encrypts data for an archive with the given passphrase, in the same envelope as is used by the store.
+// Archives are always sealed with AES-256-GCM and a key derived with Argon2id, whatever the store's own cipher and key
+// derivation, with the cipher, salt and key derivation recorded in the envelope.  Entries sealed with the same key cache
+// share a salt, so the key is derived only once for an archive.
+func sealExport(data []byte, passphrase []byte, keys *keyCache) ([]byte, error) {
+	sealer := &Store{keys: keys}
+	env := &envelope{
+		Version:    envelopeVersion,
+		Created:    time.Now().Unix(),
+		Encryption: encryptionPassphrase,
+	}
+	ciphertext, err := sealer.encryptWithPassphrase(env, data, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	env.Ciphertext = base64.StdEncoding.EncodeToString(ciphertext)
+
+	return json.Marshal(env)
+}
+
+func writeArchiveEntry(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	}); err != nil {
+		return errors.Wrapf(err, "failed to write archive entry %s", name)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return errors.Wrapf(err, "failed to write archive entry %s", name)
+	}
+
+	return nil
+}
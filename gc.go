@@ -0,0 +1,154 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// GCObject is an unreferenced object found by GC.
+type GCObject struct {
+	// WalletID is the ID of the wallet to which the object belongs.
+	WalletID uuid.UUID
+	// Key is the key of the object in Vault.
+	Key string
+	// Updated is the time at which the object was last written.
+	Updated time.Time
+	// Removed is true if the object was removed.
+	Removed bool
+}
+
+// GC removes objects that are not referenced by any wallet: accounts and accounts indexes of wallets that have no
+// wallet data, for example after a partially-failed write, and metadata for wallets and accounts that do not exist.
+// Objects written within gracePeriod are left alone, so that GC does not race with writes in progress.
+// If dryRun is true nothing is removed, and the objects that would have been removed are returned.  Removal is a
+// deletion that can be undone until it is purged with Purge.  This requires version 2 of the KV secrets engine.
+func (s *Store) GC(gracePeriod time.Duration, dryRun bool) (_ []*GCObject, err error) {
+	defer func() { s.audit("gc", "", "", "", err) }()
+
+	if s.kvVersion != 2 {
+		return nil, errors.New("garbage collection requires version 2 of the KV secrets engine")
+	}
+	if err := s.Authorize(); err != nil {
+		return nil, err
+	}
+
+	candidates, err := s.unreferencedObjects()
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(-gracePeriod)
+	objects := make([]*GCObject, 0, len(candidates))
+	for _, candidate := range candidates {
+		versions, err := s.kvVersions(candidate.Key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to obtain versions of %s", candidate.Key)
+		}
+		for _, version := range versions {
+			if version.Current {
+				candidate.Updated = version.Created
+			}
+		}
+		if candidate.Updated.After(cutoff) {
+			continue
+		}
+		if !dryRun {
+			if err := s.kvDelete(candidate.Key); err != nil {
+				return nil, errors.Wrapf(err, "failed to remove %s", candidate.Key)
+			}
+			s.invalidate(candidate.WalletID, candidate.Key)
+			candidate.Removed = true
+			s.log.Debug("Removed unreferenced object", "key", candidate.Key)
+		}
+		objects = append(objects, candidate)
+	}
+
+	return objects, nil
+}
+
+// unreferencedObjects finds objects with data that are not referenced by any wallet.
+func (s *Store) unreferencedObjects() ([]*GCObject, error) {
+	objects := make([]*GCObject, 0)
+
+	wallets, err := s.kvList(s.walletsPath())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list wallets")
+	}
+	for _, wallet := range wallets {
+		walletID, err := uuid.Parse(strings.TrimSuffix(wallet, "/"))
+		if err != nil {
+			// Not a wallet.
+			continue
+		}
+		header, err := s.kvRead(s.walletHeaderPath(walletID.String()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read wallet %s", walletID)
+		}
+		if header != nil {
+			continue
+		}
+		keys, err := s.kvList(s.walletPath(walletID.String()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list accounts for wallet %s", walletID)
+		}
+		for _, key := range keys {
+			if key == "lock" || key == walletID.String() {
+				continue
+			}
+			objectKey := s.accountPath(walletID.String(), key)
+			if data, err := s.kvRead(objectKey); err != nil {
+				return nil, errors.Wrapf(err, "failed to read %s", objectKey)
+			} else if data != nil {
+				objects = append(objects, &GCObject{WalletID: walletID, Key: objectKey})
+			}
+		}
+	}
+
+	metadataWallets, err := s.kvList(fmt.Sprintf("%s-metadata", s.Location()))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list metadata")
+	}
+	for _, wallet := range metadataWallets {
+		walletID, err := uuid.Parse(strings.TrimSuffix(wallet, "/"))
+		if err != nil {
+			continue
+		}
+		keys, err := s.kvList(s.walletMetadataDirPath(walletID.String()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list metadata for wallet %s", walletID)
+		}
+		for _, key := range keys {
+			referent := s.accountPath(walletID.String(), key)
+			if data, err := s.kvRead(referent); err != nil {
+				return nil, errors.Wrapf(err, "failed to read %s", referent)
+			} else if data != nil {
+				continue
+			}
+			metadataKey := s.accountMetadataPath(walletID.String(), key)
+			if data, err := s.kvRead(metadataKey); err != nil {
+				return nil, errors.Wrapf(err, "failed to read %s", metadataKey)
+			} else if data != nil {
+				objects = append(objects, &GCObject{WalletID: walletID, Key: metadataKey})
+			}
+		}
+	}
+
+	return objects, nil
+}
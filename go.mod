@@ -12,4 +12,5 @@ require (
 	github.com/wealdtech/go-eth2-util v1.2.2
 	github.com/wealdtech/go-eth2-wallet-types/v2 v2.2.0
 	github.com/wealdtech/go-indexer v1.0.0
+	golang.org/x/crypto v0.0.0-20200604202706-70a84ac30bf9
 )
@@ -0,0 +1,74 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// AccountHandle refers to an account in a wallet without holding its data, so that the accounts of a large wallet can
+// be listed cheaply and only those required retrieved.  Vault does not report the size of the data it holds, so handles
+// do not include it.
+type AccountHandle struct {
+	// ID is the ID of the account.
+	ID uuid.UUID
+	// Name is the name of the account from the wallet's accounts index, or empty if it is not in the index.
+	Name     string
+	store    *Store
+	walletID uuid.UUID
+}
+
+// ListAccountHandles lists handles to the accounts in a wallet.  Accounts are listed from Vault and named from the
+// wallet's accounts index as with ListAccountIDs; no account is retrieved until its handle's Fetch is called.
+func (s *Store) ListAccountHandles(walletID uuid.UUID) ([]*AccountHandle, error) {
+	summaries, err := s.ListAccountIDs(walletID)
+	if err != nil {
+		return nil, err
+	}
+
+	handles := make([]*AccountHandle, len(summaries))
+	for i, summary := range summaries {
+		handles[i] = &AccountHandle{
+			ID:       summary.ID,
+			Name:     summary.Name,
+			store:    s,
+			walletID: walletID,
+		}
+	}
+
+	return handles, nil
+}
+
+// Fetch retrieves and decrypts the account's data.
+func (h *AccountHandle) Fetch() ([]byte, error) {
+	return h.store.RetrieveAccount(h.walletID, h.ID)
+}
+
+// Modified obtains the time at which the account was last written, without retrieving its data.
+// This requires version 2 of the KV secrets engine.
+func (h *AccountHandle) Modified() (time.Time, error) {
+	versions, err := h.store.ListAccountVersions(h.walletID, h.ID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, version := range versions {
+		if version.Current {
+			return version.Created, nil
+		}
+	}
+	return time.Time{}, errors.New("account not found")
+}
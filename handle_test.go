@@ -0,0 +1,46 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccountHandle(t *testing.T) {
+	store := &Store{
+		log:       nopLogger{},
+		cache:     newMemCache(0, 0),
+		kvVersion: 1,
+	}
+	walletID := uuid.New()
+	accountID := uuid.New()
+	store.cache.set(accountCacheKey(walletID, accountID), []byte(`{"name":"Test"}`))
+
+	handle := &AccountHandle{
+		ID:       accountID,
+		Name:     "Test",
+		store:    store,
+		walletID: walletID,
+	}
+	data, err := handle.Fetch()
+	require.Nil(t, err)
+	assert.Equal(t, `{"name":"Test"}`, string(data))
+
+	_, err = handle.Modified()
+	assert.EqualError(t, err, "versions require version 2 of the KV secrets engine")
+}
@@ -0,0 +1,185 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// Health checks carried out by Healthy.
+const (
+	// HealthCheckVault checks that Vault is reachable and unsealed.
+	HealthCheckVault = "vault"
+	// HealthCheckAuth checks that the store can log in to Vault and obtain a valid token.
+	HealthCheckAuth = "auth"
+	// HealthCheckStorage checks that the store can list wallets.
+	HealthCheckStorage = "storage"
+	// HealthCheckDecryption checks that the store can decrypt its data.
+	HealthCheckDecryption = "decryption"
+)
+
+// HealthCheck is the result of a single health check.
+type HealthCheck struct {
+	// Name is the name of the check.
+	Name string
+	// Error is the reason the check failed, or nil if it passed.
+	Error error
+	// Duration is the time taken by the check.
+	Duration time.Duration
+}
+
+// HealthReport is the result of checking the health of the store.
+type HealthReport struct {
+	// Checks are the results of the individual checks, in the order in which they were carried out.
+	Checks []*HealthCheck
+}
+
+// Healthy returns true if all checks passed.
+func (r *HealthReport) Healthy() bool {
+	for _, check := range r.Checks {
+		if check.Error != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Healthy checks that the store is able to serve requests: that Vault is reachable and unsealed, that the store can log
+// in and obtain a valid token, that it can list wallets, and that it can decrypt them.  Checks stop at the first failure,
+// as later checks depend on earlier ones.  An error is only returned if ctx is done before the checks complete.
+func (s *Store) Healthy(ctx context.Context) (*HealthReport, error) {
+	report := &HealthReport{
+		Checks: make([]*HealthCheck, 0),
+	}
+	checks := []struct {
+		name  string
+		check func() error
+	}{
+		{HealthCheckVault, s.checkVault},
+		{HealthCheckAuth, s.checkAuth},
+		{HealthCheckStorage, s.checkStorage},
+		{HealthCheckDecryption, s.checkDecryption},
+	}
+	for _, check := range checks {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		started := time.Now()
+		err := check.check()
+		report.Checks = append(report.Checks, &HealthCheck{
+			Name:     check.name,
+			Error:    err,
+			Duration: time.Since(started),
+		})
+		if err != nil {
+			s.log.Warn("Health check failed", "check", check.name, "error", err)
+			break
+		}
+	}
+
+	return report, nil
+}
+
+func (s *Store) checkVault() error {
+	var health *api.HealthResponse
+	err := s.callVault("health", "sys/health", func() error {
+		var err error
+		health, err = s.client.Sys().Health()
+		return err
+	})
+	if err != nil {
+		return errors.Wrap(err, "Vault unreachable")
+	}
+	if !health.Initialized {
+		return errors.New("Vault is not initialized")
+	}
+	if health.Sealed {
+		return &SealedError{}
+	}
+
+	return nil
+}
+
+func (s *Store) checkAuth() error {
+	if err := s.Authorize(); err != nil {
+		return errors.Wrap(err, "failed to log in")
+	}
+	err := s.callVault("lookup token", "auth/token/lookup-self", func() error {
+		_, err := s.client.Auth().Token().LookupSelf()
+		return err
+	})
+	if err != nil {
+		return errors.Wrap(err, "token is not valid")
+	}
+
+	return nil
+}
+
+func (s *Store) checkStorage() error {
+	if _, err := s.kvList(s.walletsPath()); err != nil {
+		return errors.Wrap(err, "failed to list wallets")
+	}
+
+	return nil
+}
+
+// checkDecryption checks that the store's encryption key works.  With a transit key this is a round trip through
+// Vault; otherwise the first wallet found is decrypted.
+func (s *Store) checkDecryption() error {
+	if s.transitKey != "" {
+		probe := []byte(`{}`)
+		ciphertext, err := s.transitEncrypt(s.transitKey, probe)
+		if err != nil {
+			return errors.Wrap(err, "failed to encrypt with transit key")
+		}
+		plaintext, err := s.transitDecrypt(s.transitKey, ciphertext)
+		if err != nil {
+			return errors.Wrap(err, "failed to decrypt with transit key")
+		}
+		if !bytes.Equal(probe, plaintext) {
+			return errors.New("transit key round trip mismatch")
+		}
+		return nil
+	}
+
+	wallets, err := s.kvList(s.walletsPath())
+	if err != nil {
+		return errors.Wrap(err, "failed to list wallets")
+	}
+	for _, wallet := range wallets {
+		walletID, err := uuid.Parse(strings.TrimSuffix(wallet, "/"))
+		if err != nil {
+			// Not a wallet.
+			continue
+		}
+		data, err := s.readObject(s.walletHeaderPath(walletID.String()))
+		if err != nil || data == nil {
+			continue
+		}
+		if _, err := s.decryptIfRequired(walletID, data); err != nil {
+			return errors.Wrapf(err, "failed to decrypt wallet %s", strings.TrimSuffix(wallet, "/"))
+		}
+		return nil
+	}
+
+	// No wallets to check against.
+	return nil
+}
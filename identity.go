@@ -0,0 +1,81 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"net/http"
+	"os"
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+// Headers identifying the caller in requests to Vault.
+const (
+	headerCallerService  = "X-Caller-Service"
+	headerCallerInstance = "X-Caller-Instance"
+	headerRequestID      = "X-Request-Id"
+)
+
+// WithCallerIdentity identifies the service and instance using the store in each request to Vault, with the
+// X-Caller-Service and X-Caller-Instance headers, and gives each request a unique X-Request-Id header.  If instance is
+// empty the host name is used.  Vault only records request headers in its audit logs once they have been configured
+// for auditing, which Bootstrap does for these and any headers set with WithRequestHeader.
+func WithCallerIdentity(service string, instance string) Option {
+	return optionFunc(func(o *options) {
+		if instance == "" {
+			instance, _ = os.Hostname()
+		}
+		if o.headers == nil {
+			o.headers = make(http.Header)
+		}
+		o.headers.Set(headerCallerService, service)
+		o.headers.Set(headerCallerInstance, instance)
+		o.requestIDs = true
+	})
+}
+
+// requestIDTransport adds a unique X-Request-Id header to each request.
+type requestIDTransport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *requestIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	// Round trippers must not modify the request they are given.
+	req = req.Clone(req.Context())
+	req.Header.Set(headerRequestID, uuid.New().String())
+
+	return base.RoundTrip(req)
+}
+
+// auditedHeaders returns the names of the headers that the store sends to identify itself, which Bootstrap configures
+// Vault to record in its audit logs.
+func auditedHeaders(headers http.Header, requestIDs bool) []string {
+	names := make([]string, 0, len(headers)+1)
+	for name := range headers {
+		names = append(names, http.CanonicalHeaderKey(name))
+	}
+	if requestIDs {
+		names = append(names, headerRequestID)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,302 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// ImportConflictPolicy defines how Import handles objects in the archive that already exist in the store.
+type ImportConflictPolicy int
+
+const (
+	// ImportSkip leaves existing objects untouched.
+	ImportSkip ImportConflictPolicy = iota
+	// ImportOverwrite replaces existing objects with those in the archive.
+	ImportOverwrite
+	// ImportFail fails the import, before anything is written, if any object already exists.
+	ImportFail
+)
+
+// Actions reported by Import.
+const (
+	// ImportCreated is reported for an object that did not exist in the store.
+	ImportCreated = "create"
+	// ImportOverwritten is reported for an existing object that is replaced.
+	ImportOverwritten = "overwrite"
+	// ImportSkipped is reported for an existing object that is left untouched.
+	ImportSkipped = "skip"
+)
+
+// objectIndex is the kind of an accounts index.
+const objectIndex = "index"
+
+// ImportChange is a change made, or that would be made, to the store by Import.
+type ImportChange struct {
+	// Kind is the kind of the object: "wallet", "index" or "account".
+	Kind string
+	// WalletID is the ID of the wallet to which the object belongs.
+	WalletID uuid.UUID
+	// AccountID is the ID of the account, for accounts.
+	AccountID uuid.UUID
+	// Action is the action taken: ImportCreated, ImportOverwritten or ImportSkipped.
+	Action string
+}
+
+// archiveWallet is a wallet read from an archive.
+type archiveWallet struct {
+	id       uuid.UUID
+	header   []byte
+	index    []byte
+	accounts map[uuid.UUID][]byte
+}
+
+// Import writes the wallets, accounts indexes and accounts held in an archive created by Export to the store.  If the
+// archive was encrypted, passphrase must be the passphrase with which it was encrypted.
+// Objects that already exist are handled according to policy.  The accounts index of a wallet is always updated when
+// any of its accounts are written, retaining entries for accounts that are in the store but not the archive.
+// If dryRun is true nothing is written, and the returned changes are those that would have been made.
+func (s *Store) Import(r io.Reader, passphrase []byte, policy ImportConflictPolicy, dryRun bool) (_ []*ImportChange, err error) {
+	defer func() { s.audit("import", "", "", "", err) }()
+
+	wallets, err := readArchive(r, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.Authorize(); err != nil {
+		return nil, err
+	}
+
+	changes := make([]*ImportChange, 0)
+	for _, wallet := range wallets {
+		walletChanges, err := s.planImport(wallet, policy)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, walletChanges...)
+	}
+
+	if policy == ImportFail {
+		for _, change := range changes {
+			if change.Action == ImportOverwritten {
+				return nil, errors.Errorf("%s %s already exists", change.Kind, objectName(change))
+			}
+		}
+	}
+	if dryRun {
+		return changes, nil
+	}
+
+	for _, change := range changes {
+		if change.Action == ImportSkipped {
+			continue
+		}
+		wallet := wallets[change.WalletID]
+		switch change.Kind {
+		case objectWallet:
+			info := &struct {
+				Name string `json:"name"`
+			}{}
+			if err := json.Unmarshal(wallet.header, info); err != nil {
+				return nil, errors.Wrapf(err, "invalid wallet %s", wallet.id)
+			}
+			err = s.StoreWallet(wallet.id, info.Name, wallet.header)
+		case objectAccount:
+			err = s.StoreAccount(wallet.id, change.AccountID, wallet.accounts[change.AccountID])
+		case objectIndex:
+			err = s.StoreAccountsIndex(wallet.id, wallet.index)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to import %s %s", change.Kind, objectName(change))
+		}
+	}
+
+	return changes, nil
+}
+
+// planImport works out the changes required to import a wallet.  Changes are ordered so that the wallet is written
+// before its accounts, and the accounts before the index.
+func (s *Store) planImport(wallet *archiveWallet, policy ImportConflictPolicy) ([]*ImportChange, error) {
+	action := func(exists bool) string {
+		switch {
+		case !exists:
+			return ImportCreated
+		case policy == ImportSkip:
+			return ImportSkipped
+		default:
+			return ImportOverwritten
+		}
+	}
+
+	changes := make([]*ImportChange, 0, len(wallet.accounts)+2)
+	if wallet.header != nil {
+		_, err := s.retrieveWalletByID(wallet.id)
+		changes = append(changes, &ImportChange{
+			Kind:     objectWallet,
+			WalletID: wallet.id,
+			Action:   action(err == nil),
+		})
+	}
+
+	accountIDs := make([]uuid.UUID, 0, len(wallet.accounts))
+	for accountID := range wallet.accounts {
+		accountIDs = append(accountIDs, accountID)
+	}
+	sort.Slice(accountIDs, func(i, j int) bool { return accountIDs[i].String() < accountIDs[j].String() })
+	accountsWritten := false
+	for _, accountID := range accountIDs {
+		_, err := s.retrieveAccount(wallet.id, accountID)
+		change := &ImportChange{
+			Kind:      objectAccount,
+			WalletID:  wallet.id,
+			AccountID: accountID,
+			Action:    action(err == nil),
+		}
+		if change.Action != ImportSkipped {
+			accountsWritten = true
+		}
+		changes = append(changes, change)
+	}
+
+	if wallet.index != nil {
+		existing, err := s.RetrieveAccountsIndex(wallet.id)
+		exists := err == nil
+		change := &ImportChange{
+			Kind:     objectIndex,
+			WalletID: wallet.id,
+			Action:   action(exists),
+		}
+		if exists && accountsWritten {
+			// The index must cover the accounts written, so it is updated regardless of policy.
+			if wallet.index, err = mergeIndexes(existing, wallet.index); err != nil {
+				return nil, errors.Wrapf(err, "failed to merge accounts index for wallet %s", wallet.id)
+			}
+			change.Action = ImportOverwritten
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+// readArchive reads the wallets held in an archive created by Export, decrypting them if required.
+func readArchive(r io.Reader, passphrase []byte) (map[uuid.UUID]*archiveWallet, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid archive")
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	// Decryption only requires a store for transit keys, which are never used in archives.
+	unsealer := &Store{}
+	wallets := make(map[uuid.UUID]*archiveWallet)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid archive")
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		parts := strings.Split(path.Clean(header.Name), "/")
+		walletID, err := uuid.Parse(parts[0])
+		if err != nil {
+			return nil, errors.Errorf("unexpected archive entry %s", header.Name)
+		}
+		wallet, exists := wallets[walletID]
+		if !exists {
+			wallet = &archiveWallet{
+				id:       walletID,
+				accounts: make(map[uuid.UUID][]byte),
+			}
+			wallets[walletID] = wallet
+		}
+
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read archive entry %s", header.Name)
+		}
+
+		switch {
+		case len(parts) == 2 && parts[1] == archiveWalletName:
+			if wallet.header, err = unsealer.unseal(data, passphrase); err != nil {
+				return nil, errors.Wrapf(err, "failed to decrypt wallet %s", walletID)
+			}
+		case len(parts) == 2 && parts[1] == archiveIndexName:
+			wallet.index = data
+		case len(parts) == 3 && parts[1] == archiveAccountDir:
+			accountID, err := uuid.Parse(strings.TrimSuffix(parts[2], ".json"))
+			if err != nil {
+				return nil, errors.Errorf("unexpected archive entry %s", header.Name)
+			}
+			if wallet.accounts[accountID], err = unsealer.unseal(data, passphrase); err != nil {
+				return nil, errors.Wrapf(err, "failed to decrypt account %s", accountID)
+			}
+		default:
+			return nil, errors.Errorf("unexpected archive entry %s", header.Name)
+		}
+	}
+
+	return wallets, nil
+}
+
+// mergeIndexes merges two accounts indexes, with entries in update replacing those in existing with the same UUID.
+func mergeIndexes(existing []byte, update []byte) ([]byte, error) {
+	var existingEntries []map[string]interface{}
+	if err := json.Unmarshal(existing, &existingEntries); err != nil {
+		return nil, err
+	}
+	var updateEntries []map[string]interface{}
+	if err := json.Unmarshal(update, &updateEntries); err != nil {
+		return nil, err
+	}
+
+	updated := make(map[interface{}]bool, len(updateEntries))
+	for _, entry := range updateEntries {
+		updated[entry["uuid"]] = true
+	}
+	merged := make([]map[string]interface{}, 0, len(existingEntries)+len(updateEntries))
+	for _, entry := range existingEntries {
+		if !updated[entry["uuid"]] {
+			merged = append(merged, entry)
+		}
+	}
+	merged = append(merged, updateEntries...)
+
+	return json.Marshal(merged)
+}
+
+// objectName returns a human-readable name for the object of a change.
+func objectName(change *ImportChange) string {
+	if change.Kind == objectAccount {
+		return change.WalletID.String() + "/" + change.AccountID.String()
+	}
+	return change.WalletID.String()
+}
@@ -0,0 +1,83 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchiveRoundTrip(t *testing.T) {
+	walletID := uuid.New()
+	accountID := uuid.New()
+	wallet := []byte(`{"name":"test wallet","uuid":"` + walletID.String() + `"}`)
+	index := []byte(`[{"uuid":"` + accountID.String() + `","name":"test account"}]`)
+	account := []byte(`{"name":"test account","uuid":"` + accountID.String() + `"}`)
+	passphrase := []byte("export secret")
+	keys := newKeyCache()
+
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	sealedWallet, err := sealExport(wallet, passphrase, keys)
+	require.Nil(t, err)
+	env := &envelope{}
+	require.Nil(t, json.Unmarshal(sealedWallet, env))
+	assert.Equal(t, string(CipherAES256GCM), env.Cipher)
+	require.NotNil(t, env.KDF)
+	assert.Equal(t, KDFArgon2id, env.KDF.Name)
+	require.Nil(t, writeArchiveEntry(tw, path.Join(walletID.String(), archiveWalletName), sealedWallet, time.Now()))
+	require.Nil(t, writeArchiveEntry(tw, path.Join(walletID.String(), archiveIndexName), index, time.Now()))
+	sealedAccount, err := sealExport(account, passphrase, keys)
+	require.Nil(t, err)
+	require.Nil(t, writeArchiveEntry(tw, path.Join(walletID.String(), archiveAccountDir, accountID.String()+".json"), sealedAccount, time.Now()))
+	require.Nil(t, tw.Close())
+	require.Nil(t, gz.Close())
+	archive := buf.Bytes()
+
+	wallets, err := readArchive(bytes.NewReader(archive), passphrase)
+	require.Nil(t, err)
+	require.Len(t, wallets, 1)
+	require.NotNil(t, wallets[walletID])
+	assert.Equal(t, wallet, wallets[walletID].header)
+	assert.Equal(t, index, wallets[walletID].index)
+	assert.Equal(t, account, wallets[walletID].accounts[accountID])
+
+	_, err = readArchive(bytes.NewReader(archive), []byte("wrong"))
+	assert.NotNil(t, err)
+
+	_, err = readArchive(bytes.NewReader([]byte("not an archive")), passphrase)
+	assert.NotNil(t, err)
+}
+
+func TestMergeIndexes(t *testing.T) {
+	existing := []byte(`[{"uuid":"a","name":"one"},{"uuid":"b","name":"two"}]`)
+	update := []byte(`[{"uuid":"b","name":"renamed"},{"uuid":"c","name":"three"}]`)
+
+	merged, err := mergeIndexes(existing, update)
+	require.Nil(t, err)
+	assert.JSONEq(t, `[{"uuid":"a","name":"one"},{"uuid":"b","name":"renamed"},{"uuid":"c","name":"three"}]`, string(merged))
+
+	_, err = mergeIndexes([]byte("bad"), update)
+	assert.NotNil(t, err)
+}
@@ -18,14 +18,26 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
+	wtypes "github.com/wealdtech/go-eth2-wallet-types/v2"
 )
 
 // StoreAccountsIndex stores the account index.
 func (s *Store) StoreAccountsIndex(walletID uuid.UUID, data []byte) error {
-	s.Authorize()
+	if err := s.Authorize(); err != nil {
+		return err
+	}
+
+	unlock, err := s.lockWallet(walletID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	client := s.client
-	var err error
+	return s.storeAccountsIndex(walletID, data)
+}
+
+// storeAccountsIndex stores the account index as per StoreAccountsIndex.  The caller must hold the wallet's lock.
+func (s *Store) storeAccountsIndex(walletID uuid.UUID, data []byte) error {
 	var structuredData map[string]interface{}
 
 	// Do not encrypt empty index.
@@ -33,7 +45,7 @@ func (s *Store) StoreAccountsIndex(walletID uuid.UUID, data []byte) error {
 		// Add an extra step to force the index into a JSON object
 		// Vault has some opposition to storing an array as the base object
 		var rawMessage []interface{}
-		err = json.Unmarshal(data, &rawMessage)
+		err := json.Unmarshal(data, &rawMessage)
 
 		if err != nil {
 			return err
@@ -44,7 +56,7 @@ func (s *Store) StoreAccountsIndex(walletID uuid.UUID, data []byte) error {
 		}
 	} else {
 		var rawMessage []interface{}
-		err = json.Unmarshal(data, &rawMessage)
+		err := json.Unmarshal(data, &rawMessage)
 
 		if err != nil {
 			return err
@@ -57,28 +69,42 @@ func (s *Store) StoreAccountsIndex(walletID uuid.UUID, data []byte) error {
 
 	path := s.walletIndexPath(walletID.String())
 
-	_, err = client.Logical().Write(path, structuredData)
+	byteData, err := json.Marshal(structuredData)
+
+	if err != nil {
+		return err
+	}
+
+	err = s.kvWrite(path, byteData)
 
 	if err != nil {
 		return errors.Wrap(err, "failed to store key")
 	}
-	return nil
+
+	return s.mirror(func(secondary wtypes.Store) error {
+		return secondary.StoreAccountsIndex(walletID, data)
+	})
 }
 
 // RetrieveAccountsIndex retrieves the account index.
 func (s *Store) RetrieveAccountsIndex(walletID uuid.UUID) ([]byte, error) {
-	s.Authorize()
+	if err := s.authorizeRead(); err != nil {
+		return nil, err
+	}
 
-	client := s.client
 	path := s.walletIndexPath(walletID.String())
 
-	secret, err := client.Logical().Read(path)
+	indexData, err := s.readCommitted(path, nil, s.kvRead)
 
 	if err != nil {
 		return nil, err
 	}
 
-	byteData, err := json.Marshal(secret.Data["data"])
+	if indexData == nil {
+		return nil, errors.New("index not found")
+	}
+
+	byteData, err := json.Marshal(indexData["data"])
 
 	if err != nil {
 		return nil, err
@@ -0,0 +1,429 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	vault "github.com/stakedllc/go-eth2-wallet-store-vault"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// walletData returns the data for a wallet with the given ID and name.
+func walletData(id uuid.UUID, name string) []byte {
+	return []byte(fmt.Sprintf(`{"name":%q,"uuid":%q}`, name, id))
+}
+
+// accountData returns the data for an account with the given ID and name.
+func accountData(id uuid.UUID, name string) []byte {
+	return []byte(fmt.Sprintf(`{"name":%q,"uuid":%q}`, name, id))
+}
+
+func TestCheckUndecryptableAccounts(t *testing.T) {
+	mount := fmt.Sprintf("test-%s", uuid.New())
+	// The wallet and its index are written unencrypted, and the accounts with a passphrase.
+	plain := newStore(t, vault.WithKVMount(mount))
+	defer plain.Close()
+	encrypted := newStore(t, vault.WithKVMount(mount), vault.WithPassphrase([]byte("secret")))
+	defer encrypted.Close()
+
+	walletID := uuid.New()
+	require.Nil(t, plain.StoreWallet(walletID, "Test wallet", walletData(walletID, "Test wallet")))
+	accountID := uuid.New()
+	require.Nil(t, encrypted.StoreAccount(walletID, accountID, accountData(accountID, "Test account")))
+	index := []byte(fmt.Sprintf(`[{"uuid":%q,"name":"Test account"}]`, accountID))
+	require.Nil(t, plain.StoreAccountsIndex(walletID, index))
+
+	// Checking with the wrong passphrase reports the account but leaves the index alone.
+	wrong := newStore(t, vault.WithKVMount(mount), vault.WithPassphrase([]byte("wrong")))
+	defer wrong.Close()
+	report, err := wrong.Check(context.Background(), true)
+	require.Nil(t, err)
+	assert.False(t, report.Consistent())
+	kinds := make([]string, 0, len(report.Issues))
+	for _, issue := range report.Issues {
+		kinds = append(kinds, issue.Kind)
+		assert.False(t, issue.Fixed)
+	}
+	assert.Contains(t, kinds, vault.CheckUndecryptable)
+
+	stored, err := plain.RetrieveAccountsIndex(walletID)
+	require.Nil(t, err)
+	assert.JSONEq(t, string(index), string(stored))
+
+	// Checking with the right passphrase finds nothing to fix.
+	report, err = encrypted.Check(context.Background(), true)
+	require.Nil(t, err)
+	assert.True(t, report.Consistent())
+}
+
+// keystoreData returns an EIP-2335 keystore with the given ID and description.
+func keystoreData(id uuid.UUID, description string) []byte {
+	return []byte(fmt.Sprintf(`{"crypto":{"kdf":{"function":"pbkdf2"}},"description":%q,"pubkey":"a1b2","uuid":%q,"version":4}`, description, id))
+}
+
+// nonDeterministicWalletData returns the data for a non-deterministic wallet with the given ID and name.
+func nonDeterministicWalletData(id uuid.UUID, name string) []byte {
+	return []byte(fmt.Sprintf(`{"name":%q,"type":"non-deterministic","uuid":%q}`, name, id))
+}
+
+// indexIDs returns the account IDs in an accounts index.
+func indexIDs(t *testing.T, index []byte) []string {
+	entries := make([]struct {
+		UUID string `json:"uuid"`
+	}, 0)
+	require.Nil(t, json.Unmarshal(index, &entries))
+	ids := make([]string, len(entries))
+	for i := range entries {
+		ids[i] = entries[i].UUID
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestTransactionCommit(t *testing.T) {
+	store := newStore(t)
+	defer store.Close()
+
+	walletID := uuid.New()
+	accountIDs := []uuid.UUID{uuid.New(), uuid.New()}
+	txn := store.NewTransaction()
+	require.Nil(t, txn.StoreWallet(walletID, "Test wallet", walletData(walletID, "Test wallet")))
+	for i, accountID := range accountIDs {
+		require.Nil(t, txn.StoreAccount(walletID, accountID, accountData(accountID, fmt.Sprintf("Test account %d", i))))
+	}
+	require.Nil(t, txn.Commit())
+	assert.NotNil(t, txn.Commit())
+
+	data, err := store.RetrieveWalletByID(walletID)
+	require.Nil(t, err)
+	assert.JSONEq(t, string(walletData(walletID, "Test wallet")), string(data))
+	for i, accountID := range accountIDs {
+		data, err := store.RetrieveAccount(walletID, accountID)
+		require.Nil(t, err)
+		assert.JSONEq(t, string(accountData(accountID, fmt.Sprintf("Test account %d", i))), string(data))
+	}
+	data, err = store.RetrieveAccountsIndex(walletID)
+	require.Nil(t, err)
+	assert.ElementsMatch(t, []string{accountIDs[0].String(), accountIDs[1].String()}, indexIDs(t, data))
+
+	txn = store.NewTransaction()
+	require.Nil(t, txn.DeleteAccount(walletID, accountIDs[0]))
+	require.Nil(t, txn.Commit())
+	_, err = store.RetrieveAccount(walletID, accountIDs[0])
+	assert.NotNil(t, err)
+	_, err = store.RetrieveAccount(walletID, accountIDs[1])
+	assert.Nil(t, err)
+	data, err = store.RetrieveAccountsIndex(walletID)
+	require.Nil(t, err)
+	assert.Equal(t, []string{accountIDs[1].String()}, indexIDs(t, data))
+
+	// Nothing is left to recover.
+	require.Nil(t, store.RecoverTransactions(0))
+
+	// Accounts cannot be stored in a wallet that does not exist.
+	txn = store.NewTransaction()
+	require.Nil(t, txn.StoreAccount(uuid.New(), accountIDs[0], accountData(accountIDs[0], "Orphan")))
+	assert.NotNil(t, txn.Commit())
+}
+
+func TestCheckAndSet(t *testing.T) {
+	mount := fmt.Sprintf("test-%s", uuid.New())
+	store := newStore(t, vault.WithKVMount(mount), vault.WithCheckAndSet(true))
+	defer store.Close()
+	other := newStore(t, vault.WithKVMount(mount), vault.WithCheckAndSet(true))
+	defer other.Close()
+
+	walletID := uuid.New()
+	require.Nil(t, store.StoreWallet(walletID, "Test wallet", walletData(walletID, "Test wallet")))
+
+	// A write made without seeing another store's write conflicts.
+	_, err := other.RetrieveWalletByID(walletID)
+	require.Nil(t, err)
+	require.Nil(t, other.StoreWallet(walletID, "Test wallet", walletData(walletID, "Test wallet")))
+	err = store.StoreWallet(walletID, "Test wallet", walletData(walletID, "Test wallet"))
+	_, isConflict := err.(*vault.ConflictError)
+	assert.True(t, isConflict, "expected conflict, got %v", err)
+
+	// Explicit versions.
+	_, version, err := store.RetrieveWalletWithVersion(walletID)
+	require.Nil(t, err)
+	require.Nil(t, store.StoreWalletAtVersion(walletID, "Test wallet", walletData(walletID, "Test wallet"), version))
+	err = store.StoreWalletAtVersion(walletID, "Test wallet", walletData(walletID, "Test wallet"), version)
+	_, isConflict = err.(*vault.ConflictError)
+	assert.True(t, isConflict, "expected conflict, got %v", err)
+}
+
+func TestWalletLocking(t *testing.T) {
+	mount := fmt.Sprintf("test-%s", uuid.New())
+	stores := make([]*vault.Store, 2)
+	for i := range stores {
+		stores[i] = newStore(t, vault.WithKVMount(mount), vault.WithWalletLocking(10*time.Second, 30*time.Second))
+		defer stores[i].Close()
+	}
+
+	walletID := uuid.New()
+	require.Nil(t, stores[0].StoreWallet(walletID, "Test wallet", nonDeterministicWalletData(walletID, "Test wallet")))
+
+	// Imports from stores in different processes must not lose each other's index entries.
+	const imports = 5
+	var wg sync.WaitGroup
+	errs := make(chan error, len(stores)*imports)
+	expected := make([]string, 0, len(stores)*imports)
+	for i := range stores {
+		keystores := make([][]byte, imports)
+		for j := range keystores {
+			id := uuid.New()
+			expected = append(expected, id.String())
+			keystores[j] = keystoreData(id, fmt.Sprintf("Store %d account %d", i, j))
+		}
+		wg.Add(1)
+		go func(store *vault.Store, keystores [][]byte) {
+			defer wg.Done()
+			for _, keystore := range keystores {
+				_, err := store.ImportKeystore(walletID, keystore)
+				errs <- err
+			}
+		}(stores[i], keystores)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.Nil(t, err)
+	}
+
+	index, err := stores[0].RetrieveAccountsIndex(walletID)
+	require.Nil(t, err)
+	sort.Strings(expected)
+	assert.Equal(t, expected, indexIDs(t, index))
+}
+
+func TestDeleteRestoreAccounts(t *testing.T) {
+	store := newStore(t)
+	defer store.Close()
+
+	walletID := uuid.New()
+	require.Nil(t, store.StoreWallet(walletID, "Test wallet", walletData(walletID, "Test wallet")))
+	accountIDs := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+	for i, accountID := range accountIDs {
+		require.Nil(t, store.StoreAccount(walletID, accountID, accountData(accountID, fmt.Sprintf("Test account %d", i))))
+	}
+	index := []byte(fmt.Sprintf(`[{"uuid":%q,"name":"Test account 0"},{"uuid":%q,"name":"Test account 1"},{"uuid":%q,"name":"Test account 2"}]`, accountIDs[0], accountIDs[1], accountIDs[2]))
+	require.Nil(t, store.StoreAccountsIndex(walletID, index))
+
+	metadata := map[string]string{"role": "validator"}
+	require.Nil(t, store.StoreAccountMetadata(walletID, accountIDs[0], metadata))
+
+	// Metadata is deleted and restored along with its account.
+	require.Nil(t, store.DeleteAccount(walletID, accountIDs[0]))
+	_, err := store.RetrieveAccount(walletID, accountIDs[0])
+	assert.NotNil(t, err)
+	deleted, err := store.RetrieveAccountMetadata(walletID, accountIDs[0])
+	require.Nil(t, err)
+	assert.Len(t, deleted, 0)
+	require.Nil(t, store.RestoreAccount(walletID, accountIDs[0]))
+	data, err := store.RetrieveAccount(walletID, accountIDs[0])
+	require.Nil(t, err)
+	assert.JSONEq(t, string(accountData(accountIDs[0], "Test account 0")), string(data))
+	restored, err := store.RetrieveAccountMetadata(walletID, accountIDs[0])
+	require.Nil(t, err)
+	assert.Equal(t, metadata, restored)
+
+	// Deleting many accounts updates the index, ignoring accounts that do not exist.
+	require.Nil(t, store.DeleteAccounts(walletID, []uuid.UUID{accountIDs[1], accountIDs[2], uuid.New()}))
+	index, err = store.RetrieveAccountsIndex(walletID)
+	require.Nil(t, err)
+	assert.Equal(t, []string{accountIDs[0].String()}, indexIDs(t, index))
+	require.Nil(t, store.RestoreAccount(walletID, accountIDs[1]))
+	_, err = store.RetrieveAccount(walletID, accountIDs[1])
+	assert.Nil(t, err)
+
+	// Purged accounts take their metadata with them.
+	require.Nil(t, store.DeleteAccount(walletID, accountIDs[0]))
+	require.Nil(t, store.Purge(0))
+	assert.NotNil(t, store.RestoreAccount(walletID, accountIDs[0]))
+	purged, err := store.RetrieveAccountMetadata(walletID, accountIDs[0])
+	require.Nil(t, err)
+	assert.Len(t, purged, 0)
+}
+
+func TestDeleteAccountsKVv1(t *testing.T) {
+	store := newStore(t, vault.WithKVVersion(1))
+	defer store.Close()
+
+	walletID := uuid.New()
+	require.Nil(t, store.StoreWallet(walletID, "Test wallet", walletData(walletID, "Test wallet")))
+	accountID := uuid.New()
+	require.Nil(t, store.StoreAccount(walletID, accountID, accountData(accountID, "Test account")))
+	require.Nil(t, store.StoreAccountsIndex(walletID, []byte(fmt.Sprintf(`[{"uuid":%q,"name":"Test account"}]`, accountID))))
+
+	require.Nil(t, store.DeleteAccounts(walletID, []uuid.UUID{accountID}))
+	_, err := store.RetrieveAccount(walletID, accountID)
+	assert.NotNil(t, err)
+	index, err := store.RetrieveAccountsIndex(walletID)
+	require.Nil(t, err)
+	assert.Len(t, indexIDs(t, index), 0)
+
+	// Deletion is permanent.
+	assert.NotNil(t, store.RestoreAccount(walletID, accountID))
+}
+
+func TestMoveAccountMetadata(t *testing.T) {
+	store := newStore(t)
+	defer store.Close()
+
+	srcID := uuid.New()
+	require.Nil(t, store.StoreWallet(srcID, "Source wallet", nonDeterministicWalletData(srcID, "Source wallet")))
+	dstID := uuid.New()
+	require.Nil(t, store.StoreWallet(dstID, "Destination wallet", nonDeterministicWalletData(dstID, "Destination wallet")))
+	accountID := uuid.New()
+	require.Nil(t, store.StoreAccount(srcID, accountID, accountData(accountID, "Test account")))
+	metadata := map[string]string{"role": "validator"}
+	require.Nil(t, store.StoreAccountMetadata(srcID, accountID, metadata))
+
+	// Copies carry the metadata.
+	copyID, err := store.CopyAccount(srcID, accountID, dstID)
+	require.Nil(t, err)
+	copied, err := store.RetrieveAccountMetadata(dstID, copyID)
+	require.Nil(t, err)
+	assert.Equal(t, metadata, copied)
+
+	// Moves carry the metadata and remove it from the source wallet.
+	otherID := uuid.New()
+	require.Nil(t, store.StoreWallet(otherID, "Other wallet", nonDeterministicWalletData(otherID, "Other wallet")))
+	movedID, err := store.MoveAccount(srcID, accountID, otherID)
+	require.Nil(t, err)
+	moved, err := store.RetrieveAccountMetadata(otherID, movedID)
+	require.Nil(t, err)
+	assert.Equal(t, metadata, moved)
+	remaining, err := store.RetrieveAccountMetadata(srcID, accountID)
+	require.Nil(t, err)
+	assert.Len(t, remaining, 0)
+}
+
+func TestExportImport(t *testing.T) {
+	store := newStore(t, vault.WithPassphrase([]byte("secret")))
+	defer store.Close()
+
+	walletID := uuid.New()
+	require.Nil(t, store.StoreWallet(walletID, "Test wallet", walletData(walletID, "Test wallet")))
+	accountID := uuid.New()
+	require.Nil(t, store.StoreAccount(walletID, accountID, accountData(accountID, "Test account")))
+	index := []byte(fmt.Sprintf(`[{"uuid":%q,"name":"Test account"}]`, accountID))
+	require.Nil(t, store.StoreAccountsIndex(walletID, index))
+
+	archive := &bytes.Buffer{}
+	require.Nil(t, store.Export(archive, []byte("archive secret")))
+
+	restored := newStore(t)
+	defer restored.Close()
+	_, err := restored.Import(bytes.NewReader(archive.Bytes()), []byte("wrong"), vault.ImportFail, false)
+	assert.NotNil(t, err)
+	changes, err := restored.Import(bytes.NewReader(archive.Bytes()), []byte("archive secret"), vault.ImportFail, false)
+	require.Nil(t, err)
+	assert.True(t, len(changes) > 0)
+
+	data, err := restored.RetrieveWalletByID(walletID)
+	require.Nil(t, err)
+	assert.JSONEq(t, string(walletData(walletID, "Test wallet")), string(data))
+	data, err = restored.RetrieveAccount(walletID, accountID)
+	require.Nil(t, err)
+	assert.JSONEq(t, string(accountData(accountID, "Test account")), string(data))
+	data, err = restored.RetrieveAccountsIndex(walletID)
+	require.Nil(t, err)
+	assert.JSONEq(t, string(index), string(data))
+
+	// Everything now exists, so a further import fails.
+	_, err = restored.Import(bytes.NewReader(archive.Bytes()), []byte("archive secret"), vault.ImportFail, false)
+	assert.NotNil(t, err)
+}
+
+func TestKeystoreExportImport(t *testing.T) {
+	store := newStore(t)
+	defer store.Close()
+
+	walletID := uuid.New()
+	require.Nil(t, store.StoreWallet(walletID, "Test wallet", nonDeterministicWalletData(walletID, "Test wallet")))
+	keystoreIDs := []uuid.UUID{uuid.New(), uuid.New()}
+	accountIDs, err := store.ImportKeystores(walletID, [][]byte{
+		keystoreData(keystoreIDs[0], "Validator"),
+		keystoreData(keystoreIDs[1], "Validator"),
+	})
+	require.Nil(t, err)
+	assert.Equal(t, keystoreIDs, accountIDs)
+
+	dir, err := ioutil.TempDir("", "keystores")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	files, err := store.ExportKeystores(walletID, dir, nil, nil, nil)
+	require.Nil(t, err)
+	require.Len(t, files, 2)
+
+	// Import the exported keystores into a second wallet.
+	copyID := uuid.New()
+	require.Nil(t, store.StoreWallet(copyID, "Copy wallet", nonDeterministicWalletData(copyID, "Copy wallet")))
+	keystores := make([][]byte, len(files))
+	for i, file := range files {
+		keystores[i], err = ioutil.ReadFile(file)
+		require.Nil(t, err)
+	}
+	_, err = store.ImportKeystores(copyID, keystores)
+	require.Nil(t, err)
+	names := make([]string, 0)
+	for data := range store.RetrieveAccounts(copyID) {
+		info := &struct {
+			Name string `json:"name"`
+		}{}
+		require.Nil(t, json.Unmarshal(data, info))
+		names = append(names, info.Name)
+	}
+	sort.Strings(names)
+	assert.Equal(t, []string{"Validator", "Validator-2"}, names)
+}
+
+func TestCloneWalletKeyProvider(t *testing.T) {
+	// Each wallet is encrypted with a passphrase of its own.
+	store := newStore(t, vault.WithKeyProvider(func(walletID uuid.UUID) ([]byte, error) {
+		return []byte(walletID.String()), nil
+	}))
+	defer store.Close()
+
+	walletID := uuid.New()
+	require.Nil(t, store.StoreWallet(walletID, "Test wallet", walletData(walletID, "Test wallet")))
+	accountID := uuid.New()
+	require.Nil(t, store.StoreAccount(walletID, accountID, accountData(accountID, "Test account")))
+
+	cloneID, err := store.CloneWallet(walletID, "Clone wallet")
+	require.Nil(t, err)
+
+	data, err := store.RetrieveAccount(cloneID, accountID)
+	require.Nil(t, err)
+	assert.JSONEq(t, string(accountData(accountID, "Test account")), string(data))
+	accounts := 0
+	for range store.RetrieveAccounts(cloneID) {
+		accounts++
+	}
+	assert.Equal(t, 1, accounts)
+}
@@ -0,0 +1,46 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.23
+
+package vault
+
+import (
+	"iter"
+
+	"github.com/google/uuid"
+)
+
+// Wallets returns an iterator over wallet-level data for all wallets.  Unlike RetrieveWallets, wallets that cannot be
+// retrieved are reported as errors rather than skipped, and no work is done beyond the point at which iteration stops.
+func (s *Store) Wallets() iter.Seq2[[]byte, error] {
+	return func(yield func([]byte, error) bool) {
+		if err := s.authorizeRead(); err != nil {
+			yield(nil, err)
+			return
+		}
+		s.eachWallet(yield)
+	}
+}
+
+// Accounts returns an iterator over all account-level data for a wallet.  Unlike RetrieveAccounts, accounts that cannot
+// be retrieved are reported as errors rather than skipped, and no work is done beyond the point at which iteration stops.
+func (s *Store) Accounts(walletID uuid.UUID) iter.Seq2[[]byte, error] {
+	return func(yield func([]byte, error) bool) {
+		if err := s.authorizeRead(); err != nil {
+			yield(nil, err)
+			return
+		}
+		s.eachAccount(walletID, yield)
+	}
+}
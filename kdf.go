@@ -0,0 +1,154 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// KDF is a function with which the store derives encryption keys from a passphrase.
+type KDF string
+
+const (
+	// KDFArgon2id is Argon2id.  This is the default.
+	KDFArgon2id KDF = "argon2id"
+	// KDFScrypt is scrypt.
+	KDFScrypt KDF = "scrypt"
+)
+
+// Sizes of the keys derived and of the salts with which they are derived, in bytes.
+const (
+	keySize      = 32
+	keySaltBytes = 16
+)
+
+// kdfParams are the function and parameters with which a key is derived, as recorded alongside the data encrypted with
+// it.  N, R and P are used by scrypt; Time, Memory (in KiB) and Threads by Argon2id.
+type kdfParams struct {
+	Name    KDF    `json:"name"`
+	N       int    `json:"n,omitempty"`
+	R       int    `json:"r,omitempty"`
+	P       int    `json:"p,omitempty"`
+	Time    uint32 `json:"time,omitempty"`
+	Memory  uint32 `json:"memory,omitempty"`
+	Threads uint8  `json:"threads,omitempty"`
+}
+
+// Limits on the parameters of key derivations, so that the parameters recorded with a corrupt or hostile object cannot
+// exhaust the memory or CPU of the process reading it.
+const (
+	maxKDFMemory       = 1 << 30
+	maxArgon2idTime    = 16
+	maxArgon2idThreads = 64
+	maxScryptRP        = 256
+)
+
+var (
+	// defaultKDF is the key derivation used unless the store is configured otherwise.
+	defaultKDF = kdfParams{Name: KDFArgon2id, Time: 3, Memory: 64 * 1024, Threads: 4}
+	// legacyKDF is the key derivation used for data encrypted before the key derivation was recorded with it.
+	legacyKDF = kdfParams{Name: KDFScrypt, N: 1 << 15, R: 8, P: 1}
+)
+
+// validate returns an error if the parameters cannot be used to derive a key, or exceed the limits on the memory and
+// time that a key derivation may take.
+func (p kdfParams) validate() error {
+	switch p.Name {
+	case KDFArgon2id:
+		if p.Time < 1 {
+			return errors.New("argon2id time must be at least 1")
+		}
+		if p.Threads < 1 {
+			return errors.New("argon2id threads must be at least 1")
+		}
+		if p.Memory < 8*uint32(p.Threads) {
+			return errors.New("argon2id memory must be at least 8KiB per thread")
+		}
+		if p.Time > maxArgon2idTime {
+			return errors.Errorf("argon2id time must be at most %d", maxArgon2idTime)
+		}
+		if p.Threads > maxArgon2idThreads {
+			return errors.Errorf("argon2id threads must be at most %d", maxArgon2idThreads)
+		}
+		if uint64(p.Memory)*1024 > maxKDFMemory {
+			return errors.Errorf("argon2id memory must be at most %dKiB", maxKDFMemory/1024)
+		}
+	case KDFScrypt:
+		if p.N <= 1 || p.N&(p.N-1) != 0 {
+			return errors.New("scrypt N must be a power of 2 greater than 1")
+		}
+		if p.R < 1 || p.P < 1 || uint64(p.R)*uint64(p.P) >= 1<<30 {
+			return errors.New("scrypt r and p must be at least 1, with r*p less than 2^30")
+		}
+		if uint64(p.R)*uint64(p.P) > maxScryptRP {
+			return errors.Errorf("scrypt r*p must be at most %d", maxScryptRP)
+		}
+		if uint64(p.N) > maxKDFMemory/128/uint64(p.R) {
+			return errors.Errorf("scrypt memory (128*N*r bytes) must be at most %d bytes", maxKDFMemory)
+		}
+	default:
+		return errors.Errorf("unsupported key derivation function %q", p.Name)
+	}
+	return nil
+}
+
+// String returns a representation of the parameters that is unique to them.
+func (p kdfParams) String() string {
+	switch p.Name {
+	case KDFArgon2id:
+		return fmt.Sprintf("%s:t=%d,m=%d,p=%d", p.Name, p.Time, p.Memory, p.Threads)
+	case KDFScrypt:
+		return fmt.Sprintf("%s:n=%d,r=%d,p=%d", p.Name, p.N, p.R, p.P)
+	default:
+		return string(p.Name)
+	}
+}
+
+// derive derives a key from a passphrase and salt.
+func (p kdfParams) derive(passphrase []byte, salt []byte) ([]byte, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	switch p.Name {
+	case KDFArgon2id:
+		return argon2.IDKey(passphrase, salt, p.Time, p.Memory, p.Threads, keySize), nil
+	default:
+		key, err := scrypt.Key(passphrase, salt, p.N, p.R, p.P, keySize)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to derive key")
+		}
+		return key, nil
+	}
+}
+
+// sealingKDF returns the key derivation with which the store derives keys for new data.
+func (s *Store) sealingKDF() kdfParams {
+	if s.kdf.Name == "" {
+		return defaultKDF
+	}
+	return s.kdf
+}
+
+// envelopeKDF returns the key derivation with which the key for the data in an envelope was derived.  The parameters are
+// checked against the limits on key derivations before any key is derived with them.
+func envelopeKDF(env *envelope) kdfParams {
+	if env.KDF == nil {
+		return legacyKDF
+	}
+	return *env.KDF
+}
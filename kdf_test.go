@@ -0,0 +1,256 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKDFs(t *testing.T) {
+	data := []byte(`{"name":"test account","uuid":"c9958061-63d4-4a80-bcf3-25f3dda22340"}`)
+
+	tests := []struct {
+		name   string
+		kdf    kdfParams
+		stored kdfParams
+	}{
+		{
+			name:   "Default",
+			stored: defaultKDF,
+		},
+		{
+			name:   "Argon2id",
+			kdf:    kdfParams{Name: KDFArgon2id, Time: 1, Memory: 1024, Threads: 1},
+			stored: kdfParams{Name: KDFArgon2id, Time: 1, Memory: 1024, Threads: 1},
+		},
+		{
+			name:   "Scrypt",
+			kdf:    kdfParams{Name: KDFScrypt, N: 1 << 10, R: 8, P: 1},
+			stored: kdfParams{Name: KDFScrypt, N: 1 << 10, R: 8, P: 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := &Store{
+				kdf:       test.kdf,
+				keys:      newKeyCache(),
+				checksums: true,
+			}
+
+			sealed, err := store.seal(data, []byte("secret"), "")
+			require.Nil(t, err)
+			env := openEnvelope(sealed)
+			require.NotNil(t, env)
+			require.NotNil(t, env.KDF)
+			assert.Equal(t, test.stored, *env.KDF)
+
+			unsealed, err := store.unseal(sealed, []byte("secret"))
+			require.Nil(t, err)
+			assert.Equal(t, data, unsealed)
+
+			// Data is read whatever the store's key derivation.
+			other := &Store{
+				kdf: kdfParams{Name: KDFScrypt, N: 1 << 12, R: 8, P: 1},
+			}
+			unsealed, err = other.unseal(sealed, []byte("secret"))
+			require.Nil(t, err)
+			assert.Equal(t, data, unsealed)
+		})
+	}
+}
+
+func TestLegacyKDF(t *testing.T) {
+	data := []byte(`{"name":"test account","uuid":"c9958061-63d4-4a80-bcf3-25f3dda22340"}`)
+	store := &Store{
+		kdf:       legacyKDF,
+		checksums: true,
+	}
+
+	sealed, err := store.seal(data, []byte("secret"), "")
+	require.Nil(t, err)
+
+	// Envelopes written before the key derivation was recorded had their keys derived with scrypt.
+	env := openEnvelope(sealed)
+	require.NotNil(t, env)
+	env.KDF = nil
+	env.Version = 2
+	legacy, err := json.Marshal(env)
+	require.Nil(t, err)
+
+	unsealed, err := (&Store{}).unseal(legacy, []byte("secret"))
+	require.Nil(t, err)
+	assert.Equal(t, data, unsealed)
+	assert.True(t, needsUpgrade(openEnvelope(legacy)))
+}
+
+func TestKDFValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		kdf  kdfParams
+		err  string
+	}{
+		{
+			name: "Argon2id",
+			kdf:  kdfParams{Name: KDFArgon2id, Time: 1, Memory: 8, Threads: 1},
+		},
+		{
+			name: "Argon2idTimeZero",
+			kdf:  kdfParams{Name: KDFArgon2id, Memory: 1024, Threads: 1},
+			err:  "argon2id time must be at least 1",
+		},
+		{
+			name: "Argon2idThreadsZero",
+			kdf:  kdfParams{Name: KDFArgon2id, Time: 1, Memory: 1024},
+			err:  "argon2id threads must be at least 1",
+		},
+		{
+			name: "Argon2idMemoryLow",
+			kdf:  kdfParams{Name: KDFArgon2id, Time: 1, Memory: 31, Threads: 4},
+			err:  "argon2id memory must be at least 8KiB per thread",
+		},
+		{
+			name: "Scrypt",
+			kdf:  kdfParams{Name: KDFScrypt, N: 2, R: 1, P: 1},
+		},
+		{
+			name: "ScryptNNotPowerOfTwo",
+			kdf:  kdfParams{Name: KDFScrypt, N: 1000, R: 8, P: 1},
+			err:  "scrypt N must be a power of 2 greater than 1",
+		},
+		{
+			name: "ScryptRZero",
+			kdf:  kdfParams{Name: KDFScrypt, N: 1024, P: 1},
+			err:  "scrypt r and p must be at least 1, with r*p less than 2^30",
+		},
+		{
+			name: "Argon2idTimeHigh",
+			kdf:  kdfParams{Name: KDFArgon2id, Time: 17, Memory: 1024, Threads: 1},
+			err:  "argon2id time must be at most 16",
+		},
+		{
+			name: "Argon2idThreadsHigh",
+			kdf:  kdfParams{Name: KDFArgon2id, Time: 1, Memory: 1 << 20, Threads: 65},
+			err:  "argon2id threads must be at most 64",
+		},
+		{
+			name: "Argon2idMemoryMax",
+			kdf:  kdfParams{Name: KDFArgon2id, Time: 1, Memory: 1 << 20, Threads: 4},
+		},
+		{
+			name: "Argon2idMemoryHigh",
+			kdf:  kdfParams{Name: KDFArgon2id, Time: 1, Memory: 4294967295, Threads: 4},
+			err:  "argon2id memory must be at most 1048576KiB",
+		},
+		{
+			name: "ScryptMemoryMax",
+			kdf:  kdfParams{Name: KDFScrypt, N: 1 << 20, R: 8, P: 1},
+		},
+		{
+			name: "ScryptMemoryHigh",
+			kdf:  kdfParams{Name: KDFScrypt, N: 1 << 30, R: 8, P: 1},
+			err:  "scrypt memory (128*N*r bytes) must be at most 1073741824 bytes",
+		},
+		{
+			name: "ScryptRPHigh",
+			kdf:  kdfParams{Name: KDFScrypt, N: 1024, R: 8, P: 64},
+			err:  "scrypt r*p must be at most 256",
+		},
+		{
+			name: "Unknown",
+			kdf:  kdfParams{Name: "pbkdf2"},
+			err:  `unsupported key derivation function "pbkdf2"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.kdf.validate()
+			if test.err != "" {
+				require.NotNil(t, err)
+				assert.Equal(t, test.err, err.Error())
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+func TestKDFOptions(t *testing.T) {
+	store, err := New(WithToken("test"))
+	require.Nil(t, err)
+	assert.Equal(t, defaultKDF, store.(*Store).kdf)
+
+	store, err = New(WithToken("test"), WithScrypt(1<<16, 8, 1))
+	require.Nil(t, err)
+	assert.Equal(t, kdfParams{Name: KDFScrypt, N: 1 << 16, R: 8, P: 1}, store.(*Store).kdf)
+
+	_, err = New(WithToken("test"), WithArgon2id(0, 64*1024, 4))
+	assert.NotNil(t, err)
+}
+
+func TestKDFReencryption(t *testing.T) {
+	data := []byte(`{"name":"test account","uuid":"c9958061-63d4-4a80-bcf3-25f3dda22340"}`)
+	weak := &Store{
+		kdf:       kdfParams{Name: KDFArgon2id, Time: 1, Memory: 1024, Threads: 1},
+		checksums: true,
+	}
+	strong := &Store{
+		kdf:       kdfParams{Name: KDFArgon2id, Time: 2, Memory: 2048, Threads: 1},
+		checksums: true,
+	}
+
+	sealed, err := weak.seal(data, []byte("secret"), "")
+	require.Nil(t, err)
+	env := openEnvelope(sealed)
+	assert.False(t, weak.needsReencryption(env))
+	assert.True(t, strong.needsReencryption(env))
+
+	// The key derivation of data encrypted with go-ecodec is not considered.
+	legacy := &Store{
+		cipher:    CipherECodec,
+		kdf:       strong.kdf,
+		checksums: true,
+	}
+	sealed, err = weak.seal(data, []byte("secret"), "")
+	require.Nil(t, err)
+	assert.True(t, legacy.needsReencryption(openEnvelope(sealed)))
+	sealed, err = legacy.seal(data, []byte("secret"), "")
+	require.Nil(t, err)
+	assert.False(t, legacy.needsReencryption(openEnvelope(sealed)))
+}
+
+func TestHostileKDF(t *testing.T) {
+	data := []byte(`{"name":"test account","uuid":"c9958061-63d4-4a80-bcf3-25f3dda22340"}`)
+	store := &Store{
+		kdf:       kdfParams{Name: KDFArgon2id, Time: 1, Memory: 1024, Threads: 1},
+		checksums: true,
+	}
+	sealed, err := store.seal(data, []byte("secret"), "")
+	require.Nil(t, err)
+
+	// An object recording excessive parameters is refused rather than having its key derived.
+	env := openEnvelope(sealed)
+	require.NotNil(t, env)
+	env.KDF.Memory = 4294967295
+	hostile, err := json.Marshal(env)
+	require.Nil(t, err)
+	_, err = store.unseal(hostile, []byte("secret"))
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "argon2id memory must be at most")
+}
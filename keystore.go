@@ -0,0 +1,316 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	wtypes "github.com/wealdtech/go-eth2-wallet-types/v2"
+)
+
+// keystore is an EIP-2335 keystore.
+type keystore struct {
+	Crypto      map[string]interface{} `json:"crypto"`
+	Description string                 `json:"description,omitempty"`
+	Pubkey      string                 `json:"pubkey"`
+	Path        string                 `json:"path"`
+	UUID        string                 `json:"uuid"`
+	Version     int                    `json:"version"`
+}
+
+// keystoreAccount is an account as stored by a non-deterministic wallet, whose crypto is an EIP-2335 keystore.
+type keystoreAccount struct {
+	UUID      string                 `json:"uuid"`
+	Name      string                 `json:"name"`
+	Pubkey    string                 `json:"pubkey"`
+	Crypto    map[string]interface{} `json:"crypto"`
+	Encryptor string                 `json:"encryptor"`
+	Path      string                 `json:"path,omitempty"`
+	Version   int                    `json:"version"`
+}
+
+// indexEntry is an entry in a wallet's accounts index.
+type indexEntry struct {
+	UUID string `json:"uuid"`
+	Name string `json:"name"`
+}
+
+const (
+	// keystoreVersion is the version of EIP-2335 keystores.
+	keystoreVersion = 4
+	// keystoreEncryptor is the name of the encryptor for EIP-2335 keystores.
+	keystoreEncryptor = "keystore"
+	// nonDeterministicWallet is the type of wallet into which keystores can be imported.
+	nonDeterministicWallet = "non-deterministic"
+)
+
+// ImportKeystore imports an EIP-2335 keystore as an account in a non-deterministic wallet, returning the ID of the
+// account.  The account is named after the keystore's description, or its public key if it has no description, with a
+// suffix if required to make the name unique in the wallet.  The keystore's UUID is used as the account ID unless the
+// wallet already has an account with that ID.  The wallet's accounts index is updated to include the account.
+func (s *Store) ImportKeystore(walletID uuid.UUID, keystore []byte) (uuid.UUID, error) {
+	accountIDs, err := s.ImportKeystores(walletID, [][]byte{keystore})
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return accountIDs[0], nil
+}
+
+// ImportKeystores imports multiple EIP-2335 keystores as accounts in a non-deterministic wallet, returning the IDs of
+// the accounts.  See ImportKeystore for details.  All keystores are checked before any are imported.
+func (s *Store) ImportKeystores(walletID uuid.UUID, keystores [][]byte) (_ []uuid.UUID, err error) {
+	defer func() { s.audit("import keystores", walletID.String(), "", "", err) }()
+
+	walletData, err := s.retrieveWalletByID(walletID)
+	if err != nil {
+		return nil, errors.New("unknown wallet")
+	}
+	wallet := &struct {
+		Type string `json:"type"`
+	}{}
+	if err := json.Unmarshal(walletData, wallet); err != nil {
+		return nil, errors.Wrap(err, "invalid wallet")
+	}
+	if wallet.Type != nonDeterministicWallet {
+		return nil, errors.Errorf("cannot import keystores into %s wallet", wallet.Type)
+	}
+
+	if err := s.Authorize(); err != nil {
+		return nil, err
+	}
+
+	// Hold the wallet lock from reading the index to writing it back, so concurrent imports cannot lose each other's
+	// accounts or choose the same names.
+	unlock, err := s.lockWallet(walletID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	index, err := s.accountsIndex(walletID)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool, len(index))
+	ids := make(map[string]bool, len(index))
+	for _, entry := range index {
+		names[entry.Name] = true
+		ids[entry.UUID] = true
+	}
+
+	accountIDs := make([]uuid.UUID, len(keystores))
+	accounts := make([][]byte, len(keystores))
+	for i := range keystores {
+		account, err := keystoreToAccount(keystores[i], names, ids)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid keystore %d", i)
+		}
+		names[account.Name] = true
+		ids[account.UUID] = true
+		index = append(index, &indexEntry{UUID: account.UUID, Name: account.Name})
+		if accountIDs[i], err = uuid.Parse(account.UUID); err != nil {
+			return nil, err
+		}
+		if accounts[i], err = json.Marshal(account); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range accounts {
+		if err := s.storeAccount(walletID, accountIDs[i], accounts[i]); err != nil {
+			return nil, errors.Wrapf(err, "failed to store account for keystore %d", i)
+		}
+	}
+
+	indexData, err := json.Marshal(index)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.storeAccountsIndex(walletID, indexData); err != nil {
+		return nil, errors.Wrap(err, "failed to update accounts index")
+	}
+
+	return accountIDs, nil
+}
+
+// accountsIndex returns the entries in a wallet's accounts index, which is empty if the wallet has no index.
+func (s *Store) accountsIndex(walletID uuid.UUID) ([]*indexEntry, error) {
+	if err := s.authorizeRead(); err != nil {
+		return nil, err
+	}
+
+	indexData, err := s.readCommitted(s.walletIndexPath(walletID.String()), nil, s.kvRead)
+	if err != nil {
+		return nil, err
+	}
+	index := make([]*indexEntry, 0)
+	if indexData == nil || indexData["data"] == nil {
+		return index, nil
+	}
+
+	byteData, err := json.Marshal(indexData["data"])
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(byteData, &index); err != nil {
+		return nil, errors.Wrap(err, "invalid accounts index")
+	}
+
+	return index, nil
+}
+
+// keystoreToAccount converts an EIP-2335 keystore to an account, choosing a name and ID not already in use.
+func keystoreToAccount(data []byte, names map[string]bool, ids map[string]bool) (*keystoreAccount, error) {
+	ks := &keystore{}
+	if err := json.Unmarshal(data, ks); err != nil {
+		return nil, err
+	}
+	if ks.Version != keystoreVersion {
+		return nil, errors.Errorf("unsupported keystore version %d", ks.Version)
+	}
+	if ks.Crypto == nil {
+		return nil, errors.New("no crypto")
+	}
+	pubkey := strings.TrimPrefix(ks.Pubkey, "0x")
+	if pubkey == "" {
+		return nil, errors.New("no public key")
+	}
+
+	id := ks.UUID
+	if parsed, err := uuid.Parse(id); err != nil || ids[parsed.String()] {
+		id = uuid.New().String()
+	} else {
+		id = parsed.String()
+	}
+
+	baseName := ks.Description
+	if baseName == "" {
+		baseName = fmt.Sprintf("0x%s", pubkey)
+	}
+
+	return &keystoreAccount{
+		UUID:      id,
+		Name:      uniqueName(baseName, names),
+		Pubkey:    pubkey,
+		Crypto:    ks.Crypto,
+		Encryptor: keystoreEncryptor,
+		Version:   keystoreVersion,
+	}, nil
+}
+
+// uniqueName returns the given name with a suffix if required to make it distinct from the supplied names.
+func uniqueName(baseName string, names map[string]bool) string {
+	name := baseName
+	for i := 2; names[name]; i++ {
+		name = fmt.Sprintf("%s-%d", baseName, i)
+	}
+	return name
+}
+
+// ExportKeystores writes each account in a wallet to dir as an EIP-2335 keystore named keystore-<account ID>.json,
+// returning the paths of the files written.  It fails if any account cannot be retrieved or exported.
+// If an encryptor is supplied each account's secret key is decrypted with accountPassphrase and re-encrypted with
+// passphrase; otherwise keystores remain encrypted with the account's existing passphrase.  In either case only accounts
+// encrypted as keystores can be exported.
+func (s *Store) ExportKeystores(walletID uuid.UUID, dir string, encryptor wtypes.Encryptor, accountPassphrase []byte, passphrase []byte) (_ []string, err error) {
+	defer func() { s.audit("export keystores", walletID.String(), "", "", err) }()
+
+	if encryptor != nil && encryptor.Name() != keystoreEncryptor {
+		return nil, errors.Errorf("cannot export keystores with %s encryptor", encryptor.Name())
+	}
+
+	if err := s.authorizeRead(); err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0)
+	s.eachAccount(walletID, func(data []byte, accountErr error) bool {
+		if accountErr != nil {
+			err = errors.Wrap(accountErr, "failed to retrieve account")
+			return false
+		}
+		var file string
+		if file, err = exportKeystore(data, dir, encryptor, accountPassphrase, passphrase); err != nil {
+			return false
+		}
+		files = append(files, file)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// exportKeystore writes an account to dir as an EIP-2335 keystore, returning the path of the file written.
+func exportKeystore(data []byte, dir string, encryptor wtypes.Encryptor, accountPassphrase []byte, passphrase []byte) (string, error) {
+	ks, err := accountToKeystore(data)
+	if err != nil {
+		return "", err
+	}
+	// The keystore's UUID names the file, so only accept it in canonical form to keep the file within dir.
+	id, err := uuid.Parse(ks.UUID)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid account ID %q", ks.UUID)
+	}
+	ks.UUID = id.String()
+	if encryptor != nil {
+		secret, err := encryptor.Decrypt(ks.Crypto, accountPassphrase)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to decrypt account %s", ks.UUID)
+		}
+		if ks.Crypto, err = encryptor.Encrypt(secret, passphrase); err != nil {
+			return "", errors.Wrapf(err, "failed to encrypt account %s", ks.UUID)
+		}
+	}
+
+	keystoreData, err := json.Marshal(ks)
+	if err != nil {
+		return "", err
+	}
+	file := filepath.Join(dir, fmt.Sprintf("keystore-%s.json", ks.UUID))
+	if err := ioutil.WriteFile(file, keystoreData, 0600); err != nil {
+		return "", errors.Wrapf(err, "failed to write keystore for account %s", ks.UUID)
+	}
+
+	return file, nil
+}
+
+// accountToKeystore converts an account to an EIP-2335 keystore.
+func accountToKeystore(data []byte) (*keystore, error) {
+	account := &keystoreAccount{}
+	if err := json.Unmarshal(data, account); err != nil {
+		return nil, errors.Wrap(err, "invalid account")
+	}
+	if account.Encryptor != keystoreEncryptor {
+		return nil, errors.Errorf("account %s is not encrypted as a keystore", account.UUID)
+	}
+
+	return &keystore{
+		Crypto:      account.Crypto,
+		Description: account.Name,
+		Pubkey:      account.Pubkey,
+		Path:        account.Path,
+		UUID:        account.UUID,
+		Version:     keystoreVersion,
+	}, nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeystoreToAccount(t *testing.T) {
+	ks := []byte(`{"crypto":{"kdf":{"function":"pbkdf2"}},"description":"validator","pubkey":"a1b2","path":"m/12381/3600/0/0/0","uuid":"c9958061-63d4-4a80-bcf3-25f3dda22340","version":4}`)
+
+	account, err := keystoreToAccount(ks, map[string]bool{}, map[string]bool{})
+	require.Nil(t, err)
+	assert.Equal(t, "c9958061-63d4-4a80-bcf3-25f3dda22340", account.UUID)
+	assert.Equal(t, "validator", account.Name)
+	assert.Equal(t, "a1b2", account.Pubkey)
+	assert.Equal(t, keystoreEncryptor, account.Encryptor)
+	assert.Equal(t, "pbkdf2", account.Crypto["kdf"].(map[string]interface{})["function"])
+
+	// Name and ID clashes.
+	account, err = keystoreToAccount(ks, map[string]bool{"validator": true, "validator-2": true}, map[string]bool{"c9958061-63d4-4a80-bcf3-25f3dda22340": true})
+	require.Nil(t, err)
+	assert.Equal(t, "validator-3", account.Name)
+	assert.NotEqual(t, "c9958061-63d4-4a80-bcf3-25f3dda22340", account.UUID)
+
+	// No description.
+	account, err = keystoreToAccount([]byte(`{"crypto":{},"pubkey":"0xa1b2","version":4}`), map[string]bool{}, map[string]bool{})
+	require.Nil(t, err)
+	assert.Equal(t, "0xa1b2", account.Name)
+	assert.Equal(t, "a1b2", account.Pubkey)
+
+	_, err = keystoreToAccount([]byte(`{"crypto":{},"pubkey":"a1b2","version":3}`), map[string]bool{}, map[string]bool{})
+	assert.NotNil(t, err)
+	_, err = keystoreToAccount([]byte(`{"crypto":{},"version":4}`), map[string]bool{}, map[string]bool{})
+	assert.NotNil(t, err)
+}
+
+func TestAccountToKeystore(t *testing.T) {
+	account := []byte(`{"uuid":"c9958061-63d4-4a80-bcf3-25f3dda22340","name":"validator","pubkey":"a1b2","crypto":{"kdf":{"function":"pbkdf2"}},"encryptor":"keystore","version":4}`)
+
+	ks, err := accountToKeystore(account)
+	require.Nil(t, err)
+	assert.Equal(t, "c9958061-63d4-4a80-bcf3-25f3dda22340", ks.UUID)
+	assert.Equal(t, "validator", ks.Description)
+	assert.Equal(t, "a1b2", ks.Pubkey)
+	assert.Equal(t, keystoreVersion, ks.Version)
+
+	// Round trip.
+	data, err := json.Marshal(ks)
+	require.Nil(t, err)
+	imported, err := keystoreToAccount(data, map[string]bool{}, map[string]bool{})
+	require.Nil(t, err)
+	assert.Equal(t, "validator", imported.Name)
+	assert.Equal(t, "c9958061-63d4-4a80-bcf3-25f3dda22340", imported.UUID)
+
+	_, err = accountToKeystore([]byte(`{"uuid":"c9958061-63d4-4a80-bcf3-25f3dda22340","encryptor":"other"}`))
+	assert.NotNil(t, err)
+}
+
+func TestExportKeystore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keystore")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file, err := exportKeystore([]byte(`{"uuid":"C9958061-63D4-4A80-BCF3-25F3DDA22340","name":"validator","pubkey":"a1b2","crypto":{},"encryptor":"keystore","version":4}`), dir, nil, nil, nil)
+	require.Nil(t, err)
+	assert.Equal(t, filepath.Join(dir, "keystore-c9958061-63d4-4a80-bcf3-25f3dda22340.json"), file)
+	data, err := ioutil.ReadFile(file)
+	require.Nil(t, err)
+	assert.Contains(t, string(data), `"uuid":"c9958061-63d4-4a80-bcf3-25f3dda22340"`)
+
+	// An ID that is not a UUID must not be used to name a file.
+	_, err = exportKeystore([]byte(`{"uuid":"../../escaped","name":"validator","pubkey":"a1b2","crypto":{},"encryptor":"keystore","version":4}`), dir, nil, nil, nil)
+	assert.NotNil(t, err)
+	_, err = os.Stat(filepath.Join(dir, "..", "escaped.json"))
+	assert.True(t, os.IsNotExist(err))
+}
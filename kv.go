@@ -0,0 +1,319 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// kvPath returns the Vault API path for the given key.
+// Version 2 of the KV secrets engine places data and metadata under separate prefixes.
+func (s *Store) kvPath(prefix string, key string) string {
+	if s.kvVersion == 2 {
+		return fmt.Sprintf("/%s/%s/%s", s.kvMount, prefix, key)
+	}
+	return fmt.Sprintf("/%s/%s", s.kvMount, key)
+}
+
+// kvRead reads the data held at the given key.  It returns nil if there is no data at the key.
+func (s *Store) kvRead(key string) (map[string]interface{}, error) {
+	data, version, err := s.kvReadVersion(key)
+	if err != nil {
+		return nil, err
+	}
+	s.recordVersion(key, version)
+
+	return data, nil
+}
+
+// kvReadVersion reads the data held at the given key along with its version.  It returns nil if there is no data at
+// the key.  The version is always 0 for version 1 of the KV secrets engine.
+func (s *Store) kvReadVersion(key string) (map[string]interface{}, int, error) {
+	var secret *api.Secret
+	replica, err := s.readVault("read", key, func(client *api.Client) error {
+		var err error
+		secret, err = client.Logical().Read(s.kvPath("data", key))
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	data, version := s.kvSecretData(secret)
+	if replica {
+		// The replica may lag the primary, so its version cannot be used for check-and-set.
+		version = 0
+	}
+	return data, version, nil
+}
+
+// kvReadPrimaryVersion reads the data held at the given key along with its version as per kvReadVersion, but from the
+// primary only, so that the version is current and can be used for check-and-set.
+func (s *Store) kvReadPrimaryVersion(key string) (map[string]interface{}, int, error) {
+	var secret *api.Secret
+	err := s.callVault("read", key, func() error {
+		var err error
+		secret, err = s.client.Logical().Read(s.kvPath("data", key))
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	data, version := s.kvSecretData(secret)
+	return data, version, nil
+}
+
+// kvSecretData extracts the data and version from a secret read from the KV secrets engine.
+func (s *Store) kvSecretData(secret *api.Secret) (map[string]interface{}, int) {
+	if secret == nil || secret.Data == nil {
+		return nil, 0
+	}
+
+	if s.kvVersion != 2 {
+		return secret.Data, 0
+	}
+
+	version := 0
+	if metadata, ok := secret.Data["metadata"].(map[string]interface{}); ok {
+		version = kvVersionOf(metadata["version"])
+	}
+	data, ok := secret.Data["data"].(map[string]interface{})
+	if !ok {
+		// Deleted or destroyed.
+		return nil, version
+	}
+
+	return data, version
+}
+
+// kvReadWrapped reads the data held at the given key as kvRead, but has Vault return the data response-wrapped, so that
+// it passes through Vault's audit devices only as a single-use wrapping token, and unwraps it locally.
+func (s *Store) kvReadWrapped(key string) (map[string]interface{}, error) {
+	client, err := s.client.Clone()
+	if err != nil {
+		return nil, err
+	}
+	client.SetToken(s.client.Token())
+	client.SetHeaders(s.client.Headers())
+	wrapTTL := fmt.Sprintf("%ds", int(s.responseWrapTTL.Seconds()))
+	client.SetWrappingLookupFunc(func(operation string, path string) string {
+		return wrapTTL
+	})
+
+	var wrapped *api.Secret
+	err = s.callVault("read wrapped", key, func() error {
+		var err error
+		wrapped, err = client.Logical().Read(s.kvPath("data", key))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if wrapped == nil {
+		return nil, nil
+	}
+	if wrapped.WrapInfo == nil {
+		return nil, errors.New("response was not wrapped")
+	}
+
+	var secret *api.Secret
+	err = s.callVault("unwrap", key, func() error {
+		var err error
+		secret, err = s.client.Logical().Unwrap(wrapped.WrapInfo.Token)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to unwrap response")
+	}
+
+	data, version := s.kvSecretData(secret)
+	s.recordVersion(key, version)
+
+	return data, nil
+}
+
+// kvWrite writes the JSON object data to the given key.
+// If check-and-set is enabled the write will fail with a ConflictError if the key has been written since this store
+// last read or wrote it.
+func (s *Store) kvWrite(key string, data []byte) error {
+	if s.kvVersion != 2 {
+		return s.callVault("write", key, func() error {
+			_, err := s.client.Logical().WriteBytes(s.kvPath("data", key), data)
+			return err
+		})
+	}
+
+	cas := -1
+	if s.checkAndSet {
+		version, known := s.knownVersion(key)
+		if !known {
+			// No record of this key, so check-and-set against whatever is there now.
+			var err error
+			version, err = s.kvCurrentVersion(key)
+			if err != nil {
+				return err
+			}
+		}
+		cas = version
+	}
+
+	version, err := s.kvWriteCAS(key, data, cas)
+	if err != nil {
+		return err
+	}
+	s.recordVersion(key, version)
+
+	return nil
+}
+
+// kvWriteCAS writes the JSON object data to the given key in version 2 of the KV secrets engine, returning the new
+// version of the key.  If cas is not negative the write will fail with a ConflictError unless the current version of
+// the key matches it; a cas of 0 only allows the write if the key does not exist.
+func (s *Store) kvWriteCAS(key string, data []byte, cas int) (int, error) {
+	request := struct {
+		Options map[string]interface{} `json:"options,omitempty"`
+		Data    json.RawMessage        `json:"data"`
+	}{
+		Data: data,
+	}
+	if cas >= 0 {
+		request.Options = map[string]interface{}{
+			"cas": cas,
+		}
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return 0, err
+	}
+
+	var secret *api.Secret
+	err = s.callVault("write", key, func() error {
+		var err error
+		secret, err = s.client.Logical().WriteBytes(s.kvPath("data", key), body)
+		return err
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "check-and-set parameter did not match") {
+			return 0, &ConflictError{Key: key}
+		}
+		return 0, err
+	}
+	if secret == nil || secret.Data == nil {
+		return 0, nil
+	}
+
+	return kvVersionOf(secret.Data["version"]), nil
+}
+
+// kvList lists the keys held under the given key.
+func (s *Store) kvList(key string) ([]string, error) {
+	var secret *api.Secret
+	_, err := s.readVault("list", key, func(client *api.Client) error {
+		var err error
+		secret, err = client.Logical().List(s.kvPath("metadata", key))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, nil
+	}
+
+	rawKeys, ok := secret.Data["keys"].([]interface{})
+	if !ok {
+		return nil, errors.New("unexpected list response")
+	}
+
+	keys := make([]string, 0, len(rawKeys))
+	for _, rawKey := range rawKeys {
+		if key, ok := rawKey.(string); ok {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+// kvCurrentVersion obtains the current version of the given key, or 0 if it does not exist.
+func (s *Store) kvCurrentVersion(key string) (int, error) {
+	var secret *api.Secret
+	err := s.callVault("read metadata", key, func() error {
+		var err error
+		secret, err = s.client.Logical().Read(s.kvPath("metadata", key))
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	if secret == nil || secret.Data == nil {
+		return 0, nil
+	}
+
+	return kvVersionOf(secret.Data["current_version"]), nil
+}
+
+// recordVersion records the version of a key as last seen by this store.
+func (s *Store) recordVersion(key string, version int) {
+	if !s.checkAndSet || version == 0 {
+		return
+	}
+	s.versionsMu.Lock()
+	s.versions[key] = version
+	s.versionsMu.Unlock()
+}
+
+// forgetVersion discards the recorded version of a key that no longer exists.
+func (s *Store) forgetVersion(key string) {
+	s.versionsMu.Lock()
+	delete(s.versions, key)
+	s.versionsMu.Unlock()
+}
+
+// knownVersion returns the version of a key as last seen by this store.
+func (s *Store) knownVersion(key string) (int, bool) {
+	s.versionsMu.Lock()
+	defer s.versionsMu.Unlock()
+	version, exists := s.versions[key]
+	return version, exists
+}
+
+// kvVersionOf converts a version as returned by Vault to an integer.
+func kvVersionOf(value interface{}) int {
+	switch v := value.(type) {
+	case json.Number:
+		version, err := v.Int64()
+		if err != nil {
+			return 0
+		}
+		return int(version)
+	case float64:
+		return int(v)
+	case string:
+		version, err := strconv.Atoi(v)
+		if err != nil {
+			return 0
+		}
+		return version
+	default:
+		return 0
+	}
+}
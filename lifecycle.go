@@ -0,0 +1,96 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// defaultChannelBuffer is the default number of items buffered by channels that supply wallets, accounts and events.
+const defaultChannelBuffer = 1024
+
+// errStoreClosed is returned by operations abandoned because the store has been closed.
+var errStoreClosed = errors.New("store closed")
+
+// Close stops the store's background work, such as goroutines supplying wallets and accounts to channels returned by
+// RetrieveWallets and RetrieveAccounts, and waits for it to finish.  Channels that are still being supplied are closed
+// early.  Audit sinks that implement io.Closer are then closed, and the first failure to close one is returned.  The
+// store should not be used after it has been closed.
+func (s *Store) Close() (err error) {
+	s.closeOnce.Do(func() {
+		if s.cancel != nil {
+			s.cancel()
+		}
+		// Background work may still audit, so sinks are only closed once it has finished.
+		s.workers.Wait()
+		for _, sink := range s.auditSinks {
+			if closer, ok := sink.(io.Closer); ok {
+				if closeErr := closer.Close(); closeErr != nil && err == nil {
+					err = errors.Wrap(closeErr, "failed to close audit sink")
+				}
+			}
+		}
+	})
+	s.workers.Wait()
+
+	return err
+}
+
+// done returns a channel that is closed when the store is closed.
+func (s *Store) done() <-chan struct{} {
+	if s.ctx == nil {
+		// Never closed.
+		return nil
+	}
+	return s.ctx.Done()
+}
+
+// spawn runs fn in a goroutine that Close waits for.  fn should return promptly once done() is closed.
+func (s *Store) spawn(fn func()) {
+	s.workers.Add(1)
+	go func() {
+		defer s.workers.Done()
+		fn()
+	}()
+}
+
+// send sends data to ch, returning false without sending if ctx is done or the store is closed first.
+func (s *Store) send(ctx context.Context, ch chan<- []byte, data []byte) bool {
+	select {
+	case ch <- data:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-s.done():
+		return false
+	}
+}
+
+// sleep waits for the given duration, returning early with an error if ctx is done or the store is closed first.
+func (s *Store) sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.done():
+		return errStoreClosed
+	}
+}
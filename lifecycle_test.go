@@ -0,0 +1,59 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloseStopsProducers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	store := &Store{
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	// A producer with no consumer blocks until the store is closed.
+	ch := make(chan []byte)
+	sent := 0
+	store.spawn(func() {
+		defer close(ch)
+		for store.send(context.Background(), ch, []byte("data")) {
+			sent++
+		}
+	})
+
+	require.Nil(t, store.Close())
+	assert.Equal(t, 0, sent)
+	_, open := <-ch
+	assert.False(t, open)
+
+	// Closing again is harmless.
+	assert.Nil(t, store.Close())
+}
+
+func TestSendCancelled(t *testing.T) {
+	store := &Store{}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	ch := make(chan []byte, 1)
+	assert.True(t, store.send(ctx, ch, []byte("data")))
+	cancel()
+	// Channel is full, so the send can only complete through cancellation.
+	assert.False(t, store.send(ctx, ch, []byte("data")))
+}
@@ -0,0 +1,192 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// WalletSummary is the ID and name of a wallet.
+type WalletSummary struct {
+	ID   uuid.UUID
+	Name string
+}
+
+// AccountSummary is the ID and, where known, the name of an account.
+type AccountSummary struct {
+	ID   uuid.UUID
+	Name string
+}
+
+// ListAccountIDs lists the accounts in a wallet without retrieving or decrypting them.  Accounts are listed from Vault,
+// and named from the wallet's accounts index; accounts absent from the index have no name.
+func (s *Store) ListAccountIDs(walletID uuid.UUID) (_ []*AccountSummary, err error) {
+	defer func() { s.audit("list accounts", walletID.String(), "", "", err) }()
+
+	if err := s.authorizeRead(); err != nil {
+		return nil, err
+	}
+
+	keys, err := s.kvList(s.walletPath(walletID.String()))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list accounts")
+	}
+	writes, err := s.committedWrites()
+	if err != nil {
+		return nil, err
+	}
+	keys = committedAccountKeys(keys, walletID.String(), writes)
+
+	index, err := s.accountsIndex(walletID)
+	if err != nil {
+		// The index only supplies names, so carry on without it.
+		s.log.Warn("Failed to read accounts index", "wallet", walletID, "error", err)
+		index = nil
+	}
+
+	return accountSummaries(walletID.String(), keys, index), nil
+}
+
+// ListWalletNames lists the IDs and names of the wallets in the store without retrieving them in full.  If object tags
+// are enabled names are obtained from the wallets' metadata in Vault, otherwise from the wallets themselves.  Wallets
+// whose names cannot be obtained are skipped.
+func (s *Store) ListWalletNames() (_ []*WalletSummary, err error) {
+	defer func() { s.audit("list wallets", "", "", "", err) }()
+
+	if err := s.authorizeRead(); err != nil {
+		return nil, err
+	}
+
+	wallets, err := s.kvList(s.walletsPath())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list wallets")
+	}
+
+	writes, err := s.committedWrites()
+	if err != nil {
+		return nil, err
+	}
+	wallets = committedWalletKeys(wallets, writes)
+
+	walletIDs := make([]string, 0, len(wallets))
+	for _, wallet := range wallets {
+		walletID := strings.TrimSuffix(wallet, "/")
+		if _, err := uuid.Parse(walletID); err == nil {
+			walletIDs = append(walletIDs, walletID)
+		}
+	}
+
+	// Names are fetched concurrently, each into its own slot to retain the order in which Vault lists the wallets.
+	slots := make(map[string]int, len(walletIDs))
+	names := make([]*string, len(walletIDs))
+	for i, walletID := range walletIDs {
+		slots[walletID] = i
+	}
+	fetchConcurrently(walletIDs, s.concurrency, func(walletID string) ([]byte, error) {
+		name, err := s.walletName(walletID, writes)
+		if err != nil {
+			s.log.Warn("Skipping wallet; failed to obtain name", "wallet", walletID, "error", err)
+			return nil, nil
+		}
+		names[slots[walletID]] = name
+		return nil, nil
+	}, func([]byte, error) bool { return true })
+
+	summaries := make([]*WalletSummary, 0, len(walletIDs))
+	for i, walletID := range walletIDs {
+		if names[i] == nil {
+			continue
+		}
+		summaries = append(summaries, &WalletSummary{
+			ID:   uuid.MustParse(walletID),
+			Name: *names[i],
+		})
+	}
+
+	return summaries, nil
+}
+
+// walletName obtains the name of a wallet, from its tags if available, taking account of the given committed writes.  It
+// returns nil if the wallet does not exist.
+func (s *Store) walletName(walletID string, writes map[string]*committedWrite) (*string, error) {
+	key := s.walletHeaderPath(walletID)
+
+	// The tags of a wallet with a committed write are not updated until the write is applied.
+	if _, pending := writes[key]; s.tags != nil && !pending {
+		tags, err := s.objectTags(key)
+		if err != nil {
+			return nil, err
+		}
+		if name := tags[tagWalletName]; name != "" {
+			return &name, nil
+		}
+	}
+
+	walletData, err := s.readWalletHeader(walletID, writes)
+	if err != nil {
+		return nil, err
+	}
+	if walletData == nil {
+		return nil, nil
+	}
+	if name, ok := walletData["name"].(string); ok {
+		// Stored as-is, so no need to decode the wallet.
+		return &name, nil
+	}
+
+	byteData, err := json.Marshal(walletData)
+	if err != nil {
+		return nil, err
+	}
+	id, err := uuid.Parse(walletID)
+	if err != nil {
+		return nil, err
+	}
+	byteData, err = s.decryptIfRequired(id, byteData)
+	if err != nil {
+		return nil, err
+	}
+	name := nameOf(byteData)
+
+	return &name, nil
+}
+
+// accountSummaries returns summaries of the accounts with the given keys in a wallet, named from the index.
+func accountSummaries(walletID string, keys []string, index []*indexEntry) []*AccountSummary {
+	names := make(map[string]string, len(index))
+	for _, entry := range index {
+		names[entry.UUID] = entry.Name
+	}
+
+	summaries := make([]*AccountSummary, 0, len(keys))
+	for _, key := range keys {
+		if !isAccountKey(walletID, key) {
+			continue
+		}
+		id, err := uuid.Parse(key)
+		if err != nil {
+			continue
+		}
+		summaries = append(summaries, &AccountSummary{
+			ID:   id,
+			Name: names[key],
+		})
+	}
+
+	return summaries
+}
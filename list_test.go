@@ -0,0 +1,40 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccountSummaries(t *testing.T) {
+	walletID := uuid.New().String()
+	indexed := uuid.New()
+	unindexed := uuid.New()
+
+	keys := []string{indexed.String(), "index", "lock", walletID, unindexed.String(), "not-a-uuid"}
+	index := []*indexEntry{
+		{UUID: indexed.String(), Name: "Indexed"},
+		{UUID: uuid.New().String(), Name: "Missing"},
+	}
+
+	assert.Equal(t, []*AccountSummary{
+		{ID: indexed, Name: "Indexed"},
+		{ID: unindexed},
+	}, accountSummaries(walletID, keys, index))
+
+	assert.Equal(t, []*AccountSummary{}, accountSummaries(walletID, nil, nil))
+}
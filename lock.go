@@ -0,0 +1,114 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// lockRetryInterval is the time to wait between attempts to obtain a wallet lock.
+var lockRetryInterval = 100 * time.Millisecond
+
+// walletLock is the data held in Vault for a wallet's write lock.
+// An empty owner or an expiry in the past means that the lock is free.
+type walletLock struct {
+	Owner   string `json:"owner"`
+	Expires int64  `json:"expires"`
+}
+
+// lockWallet obtains the write lock for a wallet, if wallet locking is enabled.
+// It returns a function that must be called to release the lock.
+func (s *Store) lockWallet(walletID uuid.UUID) (func(), error) {
+	if s.lockTTL == 0 {
+		return func() {}, nil
+	}
+
+	key := s.walletLockPath(walletID.String())
+	owner := uuid.New().String()
+	deadline := time.Now().Add(s.lockTimeout)
+	for {
+		version, err := s.tryLock(key, owner)
+		if err == nil {
+			return func() { s.unlock(key, version) }, nil
+		}
+		if _, isConflict := err.(*ConflictError); !isConflict {
+			return nil, errors.Wrap(err, "failed to obtain wallet lock")
+		}
+		s.log.Debug("Waiting for wallet lock", "wallet", walletID)
+		if time.Now().After(deadline) {
+			return nil, errors.New("timed out waiting for wallet lock")
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// tryLock makes a single attempt to obtain a lock, returning the version of the lock key that it wrote.
+// It returns a ConflictError if the lock is held by another owner.  The lock is read from the primary only, as a lock
+// cannot be obtained whilst the primary is unavailable and a replica's version would always conflict.
+func (s *Store) tryLock(key string, owner string) (int, error) {
+	data, version, err := s.kvReadPrimaryVersion(key)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	if data != nil {
+		current, err := parseWalletLock(data)
+		if err != nil {
+			return 0, err
+		}
+		if current.Owner != "" && current.Expires > now.Unix() {
+			return 0, &ConflictError{Key: key}
+		}
+	}
+
+	lock, err := json.Marshal(&walletLock{
+		Owner:   owner,
+		Expires: now.Add(s.lockTTL).Unix(),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Check-and-set against the version read above, so if two owners race for the lock only one will obtain it.
+	return s.kvWriteCAS(key, lock, version)
+}
+
+// unlock releases a lock.  It is a no-op if the lock has since expired and been taken by another owner.
+func (s *Store) unlock(key string, version int) {
+	lock, err := json.Marshal(&walletLock{})
+	if err != nil {
+		return
+	}
+	// Failure to release is not fatal, as the lock will expire.
+	if _, err := s.kvWriteCAS(key, lock, version); err != nil {
+		s.log.Warn("Failed to release wallet lock", "lock", key, "error", err)
+	}
+}
+
+func parseWalletLock(data map[string]interface{}) (*walletLock, error) {
+	byteData, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	lock := &walletLock{}
+	if err := json.Unmarshal(byteData, lock); err != nil {
+		return nil, errors.Wrap(err, "invalid wallet lock")
+	}
+
+	return lock, nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	vault "github.com/stakedllc/go-eth2-wallet-store-vault"
+	"github.com/stakedllc/go-eth2-wallet-store-vault/vaulttest"
+	"github.com/stretchr/testify/require"
+)
+
+// devServer is the Vault server used by integration tests, or nil if none is available.
+var devServer *vaulttest.DevServer
+
+func TestMain(m *testing.M) {
+	server, err := vaulttest.StartDevServer()
+	switch {
+	case err == vaulttest.ErrNoVault:
+		// Integration tests are skipped.
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "Failed to start Vault server: %v\n", err)
+		os.Exit(1)
+	default:
+		devServer = server
+	}
+
+	code := m.Run()
+	if devServer != nil {
+		devServer.Stop()
+	}
+	os.Exit(code)
+}
+
+// newStore creates a store in the Vault server for an integration test, skipping the test if there is no server.
+func newStore(t *testing.T, opts ...vault.Option) *vault.Store {
+	if devServer == nil {
+		t.Skip("no Vault server available")
+	}
+	store, err := devServer.NewStore(opts...)
+	require.Nil(t, err)
+
+	return store
+}
@@ -0,0 +1,102 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"path"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// RetrieveAccountsMatching retrieves the accounts in a wallet whose names match a glob pattern, in the syntax used by
+// path.Match, for example "validator-00*".  Accounts are selected by name from the wallet's accounts index, so only
+// matching accounts are retrieved from Vault; if the wallet has no index every account is retrieved and its name
+// matched.  Accounts are returned ordered by name.
+func (s *Store) RetrieveAccountsMatching(walletID uuid.UUID, pattern string) (_ [][]byte, err error) {
+	defer func() { s.audit("retrieve accounts matching", walletID.String(), "", "", err) }()
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, errors.Wrap(err, "invalid pattern")
+	}
+
+	if err := s.authorizeRead(); err != nil {
+		return nil, err
+	}
+
+	accounts := make([][]byte, 0)
+	index, err := s.accountsIndex(walletID)
+	if err != nil || len(index) == 0 {
+		if err != nil {
+			s.log.Warn("Failed to read accounts index; matching all accounts", "wallet", walletID, "error", err)
+		}
+		err = nil
+		s.eachAccount(walletID, func(data []byte, accountErr error) bool {
+			if accountErr != nil {
+				err = accountErr
+				return false
+			}
+			if matched, _ := path.Match(pattern, nameOf(data)); matched {
+				accounts = append(accounts, data)
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		sortAccountsByName(accounts)
+		return accounts, nil
+	}
+
+	writes, err := s.committedWrites()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range matchingEntries(index, pattern) {
+		data, err := s.fetchAccount(walletID, entry.UUID, writes)
+		if err != nil {
+			return nil, err
+		}
+		if data == nil {
+			// In the index but no longer in Vault.
+			continue
+		}
+		if matched, _ := path.Match(pattern, nameOf(data)); !matched {
+			// Renamed since the index was written.
+			continue
+		}
+		accounts = append(accounts, data)
+	}
+
+	return accounts, nil
+}
+
+// matchingEntries returns the entries in an accounts index whose names match a pattern, ordered by name and then ID.
+func matchingEntries(index []*indexEntry, pattern string) []*indexEntry {
+	entries := make([]*indexEntry, 0)
+	for _, entry := range index {
+		if matched, _ := path.Match(pattern, entry.Name); matched {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Name != entries[j].Name {
+			return entries[i].Name < entries[j].Name
+		}
+		return entries[i].UUID < entries[j].UUID
+	})
+
+	return entries
+}
@@ -0,0 +1,74 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchingEntries(t *testing.T) {
+	index := []*indexEntry{
+		{UUID: "c", Name: "validator-010"},
+		{UUID: "b", Name: "validator-002"},
+		{UUID: "a", Name: "validator-001"},
+		{UUID: "d", Name: "withdrawal-001"},
+		{UUID: "e", Name: "validator-001"},
+	}
+
+	tests := []struct {
+		name     string
+		pattern  string
+		expected []string
+	}{
+		{
+			name:     "Prefix",
+			pattern:  "validator-00*",
+			expected: []string{"a", "e", "b"},
+		},
+		{
+			name:     "Single",
+			pattern:  "validator-01?",
+			expected: []string{"c"},
+		},
+		{
+			name:     "Class",
+			pattern:  "*-00[1]",
+			expected: []string{"a", "e", "d"},
+		},
+		{
+			name:     "None",
+			pattern:  "deposit-*",
+			expected: []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ids := make([]string, 0)
+			for _, entry := range matchingEntries(index, test.pattern) {
+				ids = append(ids, entry.UUID)
+			}
+			assert.Equal(t, test.expected, ids)
+		})
+	}
+}
+
+func TestRetrieveAccountsMatchingInvalidPattern(t *testing.T) {
+	store := &Store{log: nopLogger{}}
+	_, err := store.RetrieveAccountsMatching(uuid.New(), "validator-[")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,128 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// memCache is an in-memory cache bounded by number of entries and age.
+// A nil cache is valid, and caches nothing.
+type memCache struct {
+	mutex   sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type memCacheEntry struct {
+	key     string
+	data    []byte
+	expires time.Time
+}
+
+// newMemCache creates a new in-memory cache.
+// A size of 0 places no limit on the number of entries, and a ttl of 0 places no limit on their age.
+func newMemCache(size int, ttl time.Duration) *memCache {
+	return &memCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func walletCacheKey(walletID uuid.UUID) string {
+	return fmt.Sprintf("wallet/%s", walletID.String())
+}
+
+func accountCacheKey(walletID uuid.UUID, accountID uuid.UUID) string {
+	return fmt.Sprintf("account/%s/%s", walletID.String(), accountID.String())
+}
+
+// get obtains data from the cache, if present and not expired.
+func (c *memCache) get(key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, exists := c.entries[key]
+	if !exists {
+		return nil, false
+	}
+	entry := element.Value.(*memCacheEntry)
+	if c.ttl > 0 && time.Now().After(entry.expires) {
+		c.lru.Remove(element)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.lru.MoveToFront(element)
+
+	return copyBytes(entry.data), true
+}
+
+// set places data in the cache, evicting the least recently used entry if the cache is full.
+func (c *memCache) set(key string, data []byte) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry := &memCacheEntry{
+		key:     key,
+		data:    copyBytes(data),
+		expires: time.Now().Add(c.ttl),
+	}
+	if element, exists := c.entries[key]; exists {
+		element.Value = entry
+		c.lru.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+
+	if c.size > 0 && c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*memCacheEntry).key)
+	}
+}
+
+// remove removes data from the cache.
+func (c *memCache) remove(key string) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, exists := c.entries[key]; exists {
+		c.lru.Remove(element)
+		delete(c.entries, key)
+	}
+}
+
+func copyBytes(data []byte) []byte {
+	res := make([]byte, len(data))
+	copy(res, data)
+	return res
+}
@@ -0,0 +1,66 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemCache(t *testing.T) {
+	cache := newMemCache(2, 0)
+
+	cache.set("a", []byte("1"))
+	cache.set("b", []byte("2"))
+	data, exists := cache.get("a")
+	require.True(t, exists)
+	assert.Equal(t, []byte("1"), data)
+
+	// "b" is now least recently used, so is evicted.
+	cache.set("c", []byte("3"))
+	_, exists = cache.get("b")
+	assert.False(t, exists)
+	_, exists = cache.get("a")
+	assert.True(t, exists)
+	_, exists = cache.get("c")
+	assert.True(t, exists)
+
+	cache.remove("a")
+	_, exists = cache.get("a")
+	assert.False(t, exists)
+}
+
+func TestMemCacheTTL(t *testing.T) {
+	cache := newMemCache(0, 10*time.Millisecond)
+
+	cache.set("a", []byte("1"))
+	_, exists := cache.get("a")
+	require.True(t, exists)
+
+	time.Sleep(20 * time.Millisecond)
+	_, exists = cache.get("a")
+	assert.False(t, exists)
+}
+
+func TestMemCacheNil(t *testing.T) {
+	var cache *memCache
+
+	cache.set("a", []byte("1"))
+	_, exists := cache.get("a")
+	assert.False(t, exists)
+	cache.remove("a")
+}
@@ -0,0 +1,171 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"encoding/json"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// StoreWalletMetadata stores key/value metadata for a wallet, replacing any existing metadata.
+// Metadata is held separately from the wallet, and is not encrypted.
+func (s *Store) StoreWalletMetadata(walletID uuid.UUID, metadata map[string]string) (err error) {
+	defer func() { s.audit("store wallet metadata", walletID.String(), "", "", err) }()
+
+	if err := s.Authorize(); err != nil {
+		return err
+	}
+
+	unlock, err := s.lockWallet(walletID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, err := s.retrieveWalletByID(walletID); err != nil {
+		return errors.New("unknown wallet")
+	}
+
+	return s.storeMetadata(s.walletMetadataPath(walletID.String()), metadata)
+}
+
+// RetrieveWalletMetadata retrieves the key/value metadata for a wallet.  It returns empty metadata if none has been stored.
+func (s *Store) RetrieveWalletMetadata(walletID uuid.UUID) (_ map[string]string, err error) {
+	defer func() { s.audit("retrieve wallet metadata", walletID.String(), "", "", err) }()
+
+	if err := s.Authorize(); err != nil {
+		return nil, err
+	}
+
+	return s.retrieveMetadata(s.walletMetadataPath(walletID.String()))
+}
+
+// StoreAccountMetadata stores key/value metadata for an account, replacing any existing metadata.
+// Metadata is held separately from the account, and is not encrypted.
+func (s *Store) StoreAccountMetadata(walletID uuid.UUID, accountID uuid.UUID, metadata map[string]string) (err error) {
+	defer func() { s.audit("store account metadata", walletID.String(), "", accountID.String(), err) }()
+
+	if err := s.Authorize(); err != nil {
+		return err
+	}
+
+	unlock, err := s.lockWallet(walletID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, err := s.retrieveAccount(walletID, accountID); err != nil {
+		return errors.New("unknown account")
+	}
+
+	return s.storeMetadata(s.accountMetadataPath(walletID.String(), accountID.String()), metadata)
+}
+
+// RetrieveAccountMetadata retrieves the key/value metadata for an account.  It returns empty metadata if none has been
+// stored.
+func (s *Store) RetrieveAccountMetadata(walletID uuid.UUID, accountID uuid.UUID) (_ map[string]string, err error) {
+	defer func() { s.audit("retrieve account metadata", walletID.String(), "", accountID.String(), err) }()
+
+	if err := s.Authorize(); err != nil {
+		return nil, err
+	}
+
+	return s.retrieveMetadata(s.accountMetadataPath(walletID.String(), accountID.String()))
+}
+
+// RetrieveAccountsMetadata retrieves the key/value metadata for all accounts in a wallet that have metadata, keyed by
+// account ID.  Accounts themselves are not read, so this is considerably cheaper than retrieving the accounts.
+func (s *Store) RetrieveAccountsMetadata(walletID uuid.UUID) (_ map[uuid.UUID]map[string]string, err error) {
+	defer func() { s.audit("retrieve accounts metadata", walletID.String(), "", "", err) }()
+
+	if err := s.Authorize(); err != nil {
+		return nil, err
+	}
+
+	keys, err := s.kvList(s.walletMetadataDirPath(walletID.String()))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list metadata")
+	}
+
+	metadata := make(map[uuid.UUID]map[string]string, len(keys))
+	for _, key := range keys {
+		accountID, err := uuid.Parse(key)
+		if err != nil || accountID == walletID {
+			continue
+		}
+		accountMetadata, err := s.retrieveMetadata(s.accountMetadataPath(walletID.String(), key))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to retrieve metadata for account %s", key)
+		}
+		if len(accountMetadata) > 0 {
+			metadata[accountID] = accountMetadata
+		}
+	}
+
+	return metadata, nil
+}
+
+func (s *Store) storeMetadata(key string, metadata map[string]string) error {
+	if metadata == nil {
+		metadata = make(map[string]string)
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	if err := s.kvWrite(key, data); err != nil {
+		if _, isConflict := err.(*ConflictError); isConflict {
+			return err
+		}
+		return errors.Wrap(err, "failed to store metadata")
+	}
+
+	return nil
+}
+
+// deleteMetadata deletes the metadata held at a key, if any.  As with the object it describes, with version 2 of the KV
+// secrets engine the deletion can be undone until the metadata is purged.
+func (s *Store) deleteMetadata(key string) error {
+	if data, err := s.kvRead(key); err != nil {
+		return err
+	} else if data == nil {
+		return nil
+	}
+	if err := s.kvDelete(key); err != nil {
+		return errors.Wrap(err, "failed to delete metadata")
+	}
+
+	return nil
+}
+
+func (s *Store) retrieveMetadata(key string) (map[string]string, error) {
+	data, err := s.kvRead(key)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := make(map[string]string, len(data))
+	for k, v := range data {
+		value, ok := v.(string)
+		if !ok {
+			return nil, errors.Errorf("invalid metadata value for %s", k)
+		}
+		metadata[k] = value
+	}
+
+	return metadata, nil
+}
@@ -0,0 +1,238 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	wtypes "github.com/wealdtech/go-eth2-wallet-types/v2"
+)
+
+// Migrate copies every wallet, account and accounts index from another store into this store, preserving their IDs.
+// Data is retrieved decrypted from the source store and encrypted according to this store's configuration as it is
+// written.  Objects already present in this store with the same data are skipped, so a failed migration can be resumed
+// by calling Migrate again.  Note that wallets and accounts that the source store cannot retrieve are not reported by it,
+// and so are not migrated.
+// If supplied, progress is called after each object is processed.  Migration stops at the first failure.
+func (s *Store) Migrate(from wtypes.Store, progress func(*MaintenanceProgress)) (err error) {
+	defer func() { s.audit("migrate", "", "", "", err) }()
+
+	if err := s.Authorize(); err != nil {
+		return err
+	}
+
+	// Cancelling ctx releases the source store's goroutines if migration stops early.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	status := &MaintenanceProgress{}
+	wallets := sourceWallets(ctx, from)
+	defer drain(wallets)
+	for walletData := range wallets {
+		walletID, err := uuid.Parse(idOf(walletData))
+		if err != nil {
+			return errors.Wrap(err, "invalid wallet ID in source store")
+		}
+		if err := copyWallet(s, walletID, walletData, false, status, progress); err != nil {
+			return err
+		}
+
+		if err := migrateAccounts(ctx, s, from, walletID, status, progress); err != nil {
+			return err
+		}
+
+		if index, err := from.RetrieveAccountsIndex(walletID); err == nil {
+			if err := copyIndex(s, walletID, index, false, status, progress); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// migrateAccounts copies every account in a wallet from another store.
+func migrateAccounts(ctx context.Context, to wtypes.Store, from wtypes.Store, walletID uuid.UUID, status *MaintenanceProgress, progress func(*MaintenanceProgress)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	accounts := sourceAccounts(ctx, from, walletID)
+	defer drain(accounts)
+	for accountData := range accounts {
+		if err := copyAccount(to, walletID, accountData, false, status, progress); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// contextStore is a store whose retrievals can be stopped with a context, as provided by this store.
+type contextStore interface {
+	RetrieveWalletsContext(ctx context.Context) <-chan []byte
+	RetrieveAccountsContext(ctx context.Context, walletID uuid.UUID) <-chan []byte
+}
+
+// sourceWallets retrieves the wallets of a store, stopping when ctx is done if the store supports it.
+func sourceWallets(ctx context.Context, from wtypes.Store) <-chan []byte {
+	if store, isContextStore := from.(contextStore); isContextStore {
+		return store.RetrieveWalletsContext(ctx)
+	}
+	return from.RetrieveWallets()
+}
+
+// sourceAccounts retrieves the accounts of a wallet in a store, stopping when ctx is done if the store supports it.
+func sourceAccounts(ctx context.Context, from wtypes.Store, walletID uuid.UUID) <-chan []byte {
+	if store, isContextStore := from.(contextStore); isContextStore {
+		return store.RetrieveAccountsContext(ctx, walletID)
+	}
+	return from.RetrieveAccounts(walletID)
+}
+
+// drain consumes what remains of a channel in the background, so that a store without context support that is still
+// supplying it is not left blocked.
+func drain(ch <-chan []byte) {
+	go func() {
+		for range ch {
+		}
+	}()
+}
+
+// CopyTo copies every wallet, account and accounts index in this store to another store, preserving their IDs.  Each
+// object written is read back from the destination store and compared with the original.  Objects already present in
+// the destination store with the same data are skipped, so a failed copy can be resumed by calling CopyTo again, and
+// repeated calls keep a standby store in sync.
+// If supplied, progress is called after each object is processed.  Copying stops at the first failure, including any
+// wallet or account in this store that cannot be retrieved.
+func (s *Store) CopyTo(to wtypes.Store, progress func(*MaintenanceProgress)) (err error) {
+	defer func() { s.audit("copy", "", "", "", err) }()
+
+	if err := s.Authorize(); err != nil {
+		return err
+	}
+
+	status := &MaintenanceProgress{}
+	s.eachWallet(func(walletData []byte, walletErr error) bool {
+		if walletErr != nil {
+			err = walletErr
+			return false
+		}
+		walletID, parseErr := uuid.Parse(idOf(walletData))
+		if parseErr != nil {
+			err = errors.Wrap(parseErr, "invalid wallet ID")
+			return false
+		}
+		if err = copyWallet(to, walletID, walletData, true, status, progress); err != nil {
+			return false
+		}
+
+		s.eachAccount(walletID, func(accountData []byte, accountErr error) bool {
+			if accountErr != nil {
+				err = accountErr
+				return false
+			}
+			err = copyAccount(to, walletID, accountData, true, status, progress)
+			return err == nil
+		})
+		if err != nil {
+			return false
+		}
+
+		if index, indexErr := s.RetrieveAccountsIndex(walletID); indexErr == nil {
+			err = copyIndex(to, walletID, index, true, status, progress)
+		}
+		return err == nil
+	})
+
+	return err
+}
+
+func copyWallet(to wtypes.Store, walletID uuid.UUID, data []byte, verify bool, status *MaintenanceProgress, progress func(*MaintenanceProgress)) error {
+	copied, err := copyObject(data,
+		func() ([]byte, error) { return to.RetrieveWalletByID(walletID) },
+		func() error { return to.StoreWallet(walletID, nameOf(data), data) },
+		verify)
+	if err != nil {
+		return errors.Wrapf(err, "failed to copy wallet %s", walletID)
+	}
+	status.report(walletID.String(), copied, progress)
+
+	return nil
+}
+
+func copyAccount(to wtypes.Store, walletID uuid.UUID, data []byte, verify bool, status *MaintenanceProgress, progress func(*MaintenanceProgress)) error {
+	accountID, err := uuid.Parse(idOf(data))
+	if err != nil {
+		return errors.Wrapf(err, "invalid account ID in wallet %s", walletID)
+	}
+	copied, err := copyObject(data,
+		func() ([]byte, error) { return to.RetrieveAccount(walletID, accountID) },
+		func() error { return to.StoreAccount(walletID, accountID, data) },
+		verify)
+	if err != nil {
+		return errors.Wrapf(err, "failed to copy account %s/%s", walletID, accountID)
+	}
+	status.report(walletID.String()+"/"+accountID.String(), copied, progress)
+
+	return nil
+}
+
+func copyIndex(to wtypes.Store, walletID uuid.UUID, data []byte, verify bool, status *MaintenanceProgress, progress func(*MaintenanceProgress)) error {
+	copied, err := copyObject(data,
+		func() ([]byte, error) { return to.RetrieveAccountsIndex(walletID) },
+		func() error { return to.StoreAccountsIndex(walletID, data) },
+		verify)
+	if err != nil {
+		return errors.Wrapf(err, "failed to copy accounts index for wallet %s", walletID)
+	}
+	status.report(walletID.String()+"/index", copied, progress)
+
+	return nil
+}
+
+// copyObject writes data with store unless retrieve already returns the same data, returning true if it was written.
+// If verify is true the data is retrieved again after it is written, and must match.
+func copyObject(data []byte, retrieve func() ([]byte, error), store func() error, verify bool) (bool, error) {
+	if existing, err := retrieve(); err == nil && sameData(existing, data) {
+		return false, nil
+	}
+	if err := store(); err != nil {
+		return false, err
+	}
+	if verify {
+		written, err := retrieve()
+		if err != nil {
+			return false, errors.Wrap(err, "failed to read back")
+		}
+		if !sameData(written, data) {
+			return false, errors.New("data read back does not match")
+		}
+	}
+
+	return true, nil
+}
+
+// sameData returns true if two objects hold the same data.  JSON is compared in a canonical form, as it is for
+// checksums, because stores such as Vault do not preserve the formatting of stored JSON.
+func sameData(a []byte, b []byte) bool {
+	sumA, errA := checksum(a, nil)
+	sumB, errB := checksum(b, nil)
+	if errA != nil || errB != nil {
+		return bytes.Equal(a, b)
+	}
+	return sumA == sumB
+}
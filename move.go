@@ -0,0 +1,191 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"encoding/json"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// CopyAccount copies an account from one wallet to a non-deterministic wallet, returning the ID of the copy.  If the
+// destination wallet already has an account with the same ID the copy is given a new ID, and if it already has an
+// account with the same name the copy's name is given a suffix to make it unique.  Any metadata held for the account is
+// copied with it, and the destination wallet's accounts index is updated to include the copy.
+func (s *Store) CopyAccount(srcWalletID uuid.UUID, accountID uuid.UUID, dstWalletID uuid.UUID) (_ uuid.UUID, err error) {
+	defer func() { s.audit("copy account", dstWalletID.String(), "", accountID.String(), err) }()
+
+	return s.copyAccount(srcWalletID, accountID, dstWalletID)
+}
+
+// MoveAccount moves an account from one wallet to a non-deterministic wallet, returning the ID of the account in its
+// new wallet.  The account is copied as per CopyAccount, and then it and its metadata are removed from the source
+// wallet and its accounts index.  With version 2 of the KV secrets engine the removal can be undone with RestoreAccount.
+func (s *Store) MoveAccount(srcWalletID uuid.UUID, accountID uuid.UUID, dstWalletID uuid.UUID) (_ uuid.UUID, err error) {
+	defer func() { s.audit("move account", dstWalletID.String(), "", accountID.String(), err) }()
+
+	if srcWalletID == dstWalletID {
+		return uuid.Nil, errors.New("source and destination wallets are the same")
+	}
+
+	newID, err := s.copyAccount(srcWalletID, accountID, dstWalletID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	if err := s.removeAccount(srcWalletID, accountID); err != nil {
+		return uuid.Nil, errors.Wrap(err, "account copied but not removed from source wallet")
+	}
+
+	return newID, nil
+}
+
+func (s *Store) copyAccount(srcWalletID uuid.UUID, accountID uuid.UUID, dstWalletID uuid.UUID) (uuid.UUID, error) {
+	if err := s.Authorize(); err != nil {
+		return uuid.Nil, err
+	}
+
+	dstWalletData, err := s.retrieveWalletByID(dstWalletID)
+	if err != nil {
+		return uuid.Nil, errors.New("unknown destination wallet")
+	}
+	if walletType := typeOf(dstWalletData); walletType != nonDeterministicWallet {
+		return uuid.Nil, errors.Errorf("cannot copy accounts into %s wallet", walletType)
+	}
+
+	data, err := s.retrieveAccount(srcWalletID, accountID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	// Hold the destination wallet's lock from reading its index to writing it back, so the account is placed against
+	// the current index and concurrent copies cannot lose each other's entries.
+	unlock, err := s.lockWallet(dstWalletID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer unlock()
+
+	index, err := s.accountsIndex(dstWalletID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	entry, err := placeAccount(idOf(data), nameOf(data), index)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if data, err = setField(data, "uuid", entry.UUID); err != nil {
+		return uuid.Nil, err
+	}
+	if data, err = renameObject(data, entry.Name); err != nil {
+		return uuid.Nil, err
+	}
+
+	newID := uuid.MustParse(entry.UUID)
+	if err := s.storeAccount(dstWalletID, newID, data); err != nil {
+		return uuid.Nil, errors.Wrap(err, "failed to store account")
+	}
+
+	metadata, err := s.retrieveMetadata(s.accountMetadataPath(srcWalletID.String(), accountID.String()))
+	if err != nil {
+		return uuid.Nil, errors.Wrap(err, "failed to retrieve account metadata")
+	}
+	if len(metadata) > 0 {
+		if err := s.storeMetadata(s.accountMetadataPath(dstWalletID.String(), entry.UUID), metadata); err != nil {
+			return uuid.Nil, err
+		}
+	}
+
+	indexData, err := json.Marshal(append(index, entry))
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if err := s.storeAccountsIndex(dstWalletID, indexData); err != nil {
+		return uuid.Nil, errors.Wrap(err, "failed to update accounts index")
+	}
+
+	return newID, nil
+}
+
+// removeAccount removes an account and its metadata from a wallet and its accounts index.
+func (s *Store) removeAccount(walletID uuid.UUID, accountID uuid.UUID) error {
+	unlock, err := s.lockWallet(walletID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	key := s.accountPath(walletID.String(), accountID.String())
+	err = s.kvDelete(key)
+	s.invalidate(walletID, key)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete account")
+	}
+	if err := s.deleteMetadata(s.accountMetadataPath(walletID.String(), accountID.String())); err != nil {
+		return err
+	}
+
+	return s.removeFromIndex(walletID, map[string]bool{accountID.String(): true})
+}
+
+// removeFromIndex removes the accounts with the given IDs from a wallet's accounts index.  The caller must hold the
+// wallet's lock.
+func (s *Store) removeFromIndex(walletID uuid.UUID, accountIDs map[string]bool) error {
+	index, err := s.accountsIndex(walletID)
+	if err != nil {
+		return err
+	}
+	remaining := make([]*indexEntry, 0, len(index))
+	for _, entry := range index {
+		if !accountIDs[entry.UUID] {
+			remaining = append(remaining, entry)
+		}
+	}
+	if len(remaining) == len(index) {
+		return nil
+	}
+	indexData, err := json.Marshal(remaining)
+	if err != nil {
+		return err
+	}
+	if err := s.storeAccountsIndex(walletID, indexData); err != nil {
+		return errors.Wrap(err, "failed to update accounts index")
+	}
+
+	return nil
+}
+
+// placeAccount returns the index entry for an account with the given ID and name added to a wallet with the given
+// accounts index, changing its ID and name if required to avoid those already in the wallet.
+func placeAccount(id string, name string, index []*indexEntry) (*indexEntry, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, errors.Wrap(err, "invalid account ID")
+	}
+	names := make(map[string]bool, len(index))
+	ids := make(map[string]bool, len(index))
+	for _, entry := range index {
+		names[entry.Name] = true
+		ids[entry.UUID] = true
+	}
+
+	if ids[id] {
+		id = uuid.New().String()
+	}
+
+	return &indexEntry{
+		UUID: id,
+		Name: uniqueName(name, names),
+	}, nil
+}
@@ -0,0 +1,53 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlaceAccount(t *testing.T) {
+	existingID := uuid.New().String()
+	newID := uuid.New().String()
+	index := []*indexEntry{
+		{UUID: existingID, Name: "Validator"},
+		{UUID: uuid.New().String(), Name: "Validator-2"},
+	}
+
+	_, err := placeAccount("bad", "Account", index)
+	assert.NotNil(t, err)
+
+	entry, err := placeAccount(newID, "Account", index)
+	require.Nil(t, err)
+	assert.Equal(t, &indexEntry{UUID: newID, Name: "Account"}, entry)
+
+	entry, err = placeAccount(newID, "Validator", index)
+	require.Nil(t, err)
+	assert.Equal(t, &indexEntry{UUID: newID, Name: "Validator-3"}, entry)
+
+	entry, err = placeAccount(existingID, "Account", index)
+	require.Nil(t, err)
+	assert.NotEqual(t, existingID, entry.UUID)
+	assert.Equal(t, "Account", entry.Name)
+	_, err = uuid.Parse(entry.UUID)
+	assert.Nil(t, err)
+
+	entry, err = placeAccount(newID, "Account", nil)
+	require.Nil(t, err)
+	assert.Equal(t, &indexEntry{UUID: newID, Name: "Account"}, entry)
+}
@@ -0,0 +1,89 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"sort"
+)
+
+// AccountOrder defines the order in which accounts are retrieved.
+type AccountOrder int
+
+const (
+	// AccountOrderNone retrieves accounts in the order in which Vault lists them.
+	AccountOrderNone AccountOrder = iota
+	// AccountOrderID retrieves accounts ordered by account ID.
+	AccountOrderID
+	// AccountOrderName retrieves accounts ordered by account name.
+	AccountOrderName
+)
+
+// orderKeysByIndex orders account keys by the names given to them in a wallet's accounts index.  Keys that are not in
+// the index follow those that are, ordered by ID.
+func orderKeysByIndex(keys []string, index []*indexEntry) []string {
+	names := make(map[string]string, len(index))
+	for _, entry := range index {
+		names[entry.UUID] = entry.Name
+	}
+
+	ordered := make([]string, len(keys))
+	copy(ordered, keys)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		nameI, indexedI := names[ordered[i]]
+		nameJ, indexedJ := names[ordered[j]]
+		switch {
+		case indexedI && indexedJ && nameI != nameJ:
+			return nameI < nameJ
+		case indexedI != indexedJ:
+			return indexedI
+		default:
+			return ordered[i] < ordered[j]
+		}
+	})
+
+	return ordered
+}
+
+// sortAccountsByName sorts account data by account name, and then by ID.
+func sortAccountsByName(accounts [][]byte) {
+	names := make([]string, len(accounts))
+	ids := make([]string, len(accounts))
+	for i := range accounts {
+		names[i] = nameOf(accounts[i])
+		ids[i] = idOf(accounts[i])
+	}
+	sort.Sort(&accountsByName{accounts: accounts, names: names, ids: ids})
+}
+
+// accountsByName sorts account data along with its pre-parsed names and IDs.
+type accountsByName struct {
+	accounts [][]byte
+	names    []string
+	ids      []string
+}
+
+func (a *accountsByName) Len() int { return len(a.accounts) }
+
+func (a *accountsByName) Less(i, j int) bool {
+	if a.names[i] != a.names[j] {
+		return a.names[i] < a.names[j]
+	}
+	return a.ids[i] < a.ids[j]
+}
+
+func (a *accountsByName) Swap(i, j int) {
+	a.accounts[i], a.accounts[j] = a.accounts[j], a.accounts[i]
+	a.names[i], a.names[j] = a.names[j], a.names[i]
+	a.ids[i], a.ids[j] = a.ids[j], a.ids[i]
+}
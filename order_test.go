@@ -0,0 +1,48 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrderKeysByIndex(t *testing.T) {
+	keys := []string{"d", "a", "b", "c"}
+	index := []*indexEntry{
+		{UUID: "a", Name: "zebra"},
+		{UUID: "b", Name: "aardvark"},
+		{UUID: "c", Name: "mole"},
+	}
+
+	assert.Equal(t, []string{"b", "c", "a", "d"}, orderKeysByIndex(keys, index))
+	// Input is untouched.
+	assert.Equal(t, []string{"d", "a", "b", "c"}, keys)
+}
+
+func TestSortAccountsByName(t *testing.T) {
+	accounts := [][]byte{
+		[]byte(`{"uuid":"3","name":"zebra"}`),
+		[]byte(`{"uuid":"2","name":"aardvark"}`),
+		[]byte(`{"uuid":"1","name":"aardvark"}`),
+	}
+
+	sortAccountsByName(accounts)
+	assert.Equal(t, [][]byte{
+		[]byte(`{"uuid":"1","name":"aardvark"}`),
+		[]byte(`{"uuid":"2","name":"aardvark"}`),
+		[]byte(`{"uuid":"3","name":"zebra"}`),
+	}, accounts)
+}
@@ -0,0 +1,124 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"encoding/base64"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// AccountsPage is a page of accounts retrieved by RetrieveAccountsPage.
+type AccountsPage struct {
+	// Accounts is the data for the accounts in the page.
+	Accounts [][]byte
+	// Cursor retrieves the next page when passed to RetrieveAccountsPage.  It is empty if there are no more accounts.
+	Cursor string
+}
+
+// RetrieveAccountsPage retrieves a page of up to pageSize accounts from a wallet, ordered by account ID.  The first page
+// is retrieved with an empty cursor, and each following page with the cursor returned with the previous page.
+// Cursors remain valid as accounts are added and removed: each page continues from the last account in the previous
+// page, so no account present throughout is skipped or repeated.  A page can hold fewer than pageSize accounts if
+// accounts are removed while it is retrieved.
+func (s *Store) RetrieveAccountsPage(walletID uuid.UUID, pageSize int, cursor string) (_ *AccountsPage, err error) {
+	defer func() { s.audit("retrieve accounts page", walletID.String(), "", "", err) }()
+
+	if pageSize < 1 {
+		return nil, errors.New("page size must be at least 1")
+	}
+	after, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.authorizeRead(); err != nil {
+		return nil, err
+	}
+
+	keys, err := s.kvList(s.walletPath(walletID.String()))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list accounts")
+	}
+	writes, err := s.committedWrites()
+	if err != nil {
+		return nil, err
+	}
+	accountKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if isAccountKey(walletID.String(), key) {
+			accountKeys = append(accountKeys, key)
+		}
+	}
+	accountKeys = committedAccountKeys(accountKeys, walletID.String(), writes)
+
+	pageKeys, more := pageOf(accountKeys, after, pageSize)
+	page := &AccountsPage{
+		Accounts: make([][]byte, 0, len(pageKeys)),
+	}
+	for _, key := range pageKeys {
+		data, err := s.fetchAccount(walletID, key, writes)
+		if err != nil {
+			return nil, err
+		}
+		if data != nil {
+			page.Accounts = append(page.Accounts, data)
+		}
+	}
+	if more {
+		page.Cursor = encodeCursor(pageKeys[len(pageKeys)-1])
+	}
+
+	return page, nil
+}
+
+// pageOf returns up to size keys following after in key order, and true if further keys follow them.
+func pageOf(keys []string, after string, size int) ([]string, bool) {
+	sorted := make([]string, len(keys))
+	copy(sorted, keys)
+	sort.Strings(sorted)
+
+	start := sort.SearchStrings(sorted, after)
+	if start < len(sorted) && sorted[start] == after {
+		start++
+	}
+	end := start + size
+	if end >= len(sorted) {
+		return sorted[start:], false
+	}
+	return sorted[start:end], true
+}
+
+// encodeCursor encodes the key of the last account in a page as a cursor.
+func encodeCursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// decodeCursor decodes a cursor to the key of the last account in the previous page.  An empty cursor decodes to an
+// empty key, which precedes all accounts.
+func decodeCursor(cursor string) (string, error) {
+	if cursor == "" {
+		return "", nil
+	}
+	key, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", errors.New("invalid cursor")
+	}
+	if _, err := uuid.Parse(string(key)); err != nil {
+		return "", errors.New("invalid cursor")
+	}
+	return string(key), nil
+}
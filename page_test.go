@@ -0,0 +1,96 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPageOf(t *testing.T) {
+	keys := []string{"d", "b", "a", "e", "c"}
+
+	tests := []struct {
+		name     string
+		after    string
+		size     int
+		expected []string
+		more     bool
+	}{
+		{
+			name:     "First",
+			size:     2,
+			expected: []string{"a", "b"},
+			more:     true,
+		},
+		{
+			name:     "Middle",
+			after:    "b",
+			size:     2,
+			expected: []string{"c", "d"},
+			more:     true,
+		},
+		{
+			name:     "Last",
+			after:    "d",
+			size:     2,
+			expected: []string{"e"},
+		},
+		{
+			name:     "Exact",
+			after:    "c",
+			size:     2,
+			expected: []string{"d", "e"},
+		},
+		{
+			name:     "AfterRemoved",
+			after:    "bb",
+			size:     2,
+			expected: []string{"c", "d"},
+			more:     true,
+		},
+		{
+			name:     "Beyond",
+			after:    "f",
+			size:     2,
+			expected: []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			page, more := pageOf(keys, test.after, test.size)
+			assert.Equal(t, test.expected, page)
+			assert.Equal(t, test.more, more)
+		})
+	}
+}
+
+func TestCursor(t *testing.T) {
+	key := "c9958061-63d4-4a80-bcf3-25f3dda22340"
+	decoded, err := decodeCursor(encodeCursor(key))
+	require.Nil(t, err)
+	assert.Equal(t, key, decoded)
+
+	decoded, err = decodeCursor("")
+	require.Nil(t, err)
+	assert.Equal(t, "", decoded)
+
+	_, err = decodeCursor("!!")
+	assert.NotNil(t, err)
+	_, err = decodeCursor(encodeCursor("index"))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,162 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"bytes"
+	"path"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// stagedObject is an object re-encrypted and written to a staging key, ready to replace the original.
+type stagedObject struct {
+	key        string
+	stagingKey string
+	data       []byte
+}
+
+// ChangeWalletPassphrase re-encrypts a wallet and its accounts, changing the passphrase with which they are encrypted
+// from oldPassphrase to newPassphrase.  This is intended for stores with a key provider, which must supply
+// newPassphrase for the wallet once this returns.  Objects encrypted by Vault's Transit secrets engine are left as-is.
+// Every object is decrypted, re-encrypted and written to a staging area, where it is read back and checked, before any
+// object is replaced, so a failure to decrypt any object leaves the wallet untouched.  If replacing the objects fails
+// part way through ChangeWalletPassphrase can be called again with the same passphrases; objects that are already
+// encrypted with newPassphrase are skipped.
+func (s *Store) ChangeWalletPassphrase(walletID uuid.UUID, oldPassphrase []byte, newPassphrase []byte) (err error) {
+	defer func() { s.audit("change wallet passphrase", walletID.String(), "", "", err) }()
+
+	if len(newPassphrase) == 0 {
+		return errors.New("no new passphrase supplied")
+	}
+
+	if err := s.Authorize(); err != nil {
+		return err
+	}
+
+	unlock, err := s.lockWallet(walletID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	headerKey := s.walletHeaderPath(walletID.String())
+	if data, err := s.readObject(headerKey); err != nil {
+		return err
+	} else if data == nil {
+		return errors.New("wallet not found")
+	}
+
+	keys := []string{headerKey}
+	accounts, err := s.kvList(s.walletPath(walletID.String()))
+	if err != nil {
+		return errors.Wrap(err, "failed to list accounts")
+	}
+	for _, account := range accounts {
+		if isAccountKey(walletID.String(), account) {
+			keys = append(keys, s.accountPath(walletID.String(), account))
+		}
+	}
+
+	staged := make([]*stagedObject, 0, len(keys))
+	defer func() {
+		for _, object := range staged {
+			if err := s.kvRemove(object.stagingKey); err != nil {
+				s.log.Warn("Failed to remove staged object", "key", object.stagingKey, "error", err)
+			}
+		}
+	}()
+	for _, key := range keys {
+		object, err := s.stagePassphraseChange(walletID, key, oldPassphrase, newPassphrase)
+		if object != nil {
+			staged = append(staged, object)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to re-encrypt %s", key)
+		}
+	}
+
+	for _, object := range staged {
+		if err := s.kvWrite(object.key, object.data); err != nil {
+			return errors.Wrapf(err, "failed to replace %s", object.key)
+		}
+		s.invalidate(walletID, object.key)
+	}
+
+	return nil
+}
+
+// stagePassphraseChange re-encrypts a single object with a new passphrase and writes it to a staging key, returning nil
+// if the object does not need re-encrypting.  If the staged data fails its check the staged object is returned along with
+// the error, so that it can be removed.
+func (s *Store) stagePassphraseChange(walletID uuid.UUID, key string, oldPassphrase []byte, newPassphrase []byte) (*stagedObject, error) {
+	data, err := s.readObject(key)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, nil
+	}
+	if env := openEnvelope(data); env != nil {
+		if env.Encryption == encryptionTransit {
+			return nil, nil
+		}
+		if env.Encryption == encryptionPassphrase {
+			if _, err := s.unseal(data, newPassphrase); err == nil {
+				// Already changed.
+				return nil, nil
+			}
+		}
+	}
+
+	plaintext, err := s.unseal(data, oldPassphrase)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := s.seal(plaintext, newPassphrase, "")
+	if err != nil {
+		return nil, err
+	}
+
+	object := &stagedObject{
+		key:        key,
+		stagingKey: s.stagingPath(walletID.String(), path.Base(key)),
+		data:       ciphertext,
+	}
+	if err := s.kvWrite(object.stagingKey, ciphertext); err != nil {
+		return nil, errors.Wrap(err, "failed to stage")
+	}
+	stored, err := s.readObject(object.stagingKey)
+	if err != nil {
+		return object, errors.Wrap(err, "failed to read back staged data")
+	}
+	check, err := s.unseal(stored, newPassphrase)
+	if err != nil {
+		return object, errors.Wrap(err, "failed to decrypt staged data")
+	}
+	if !bytes.Equal(check, plaintext) {
+		return object, errors.New("staged data does not match original")
+	}
+
+	return object, nil
+}
+
+// kvRemove removes a key entirely, including all of its versions with version 2 of the KV secrets engine.
+func (s *Store) kvRemove(key string) error {
+	if s.kvVersion == 2 {
+		return s.kvDestroy(key)
+	}
+	return s.kvDelete(key)
+}
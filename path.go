@@ -14,24 +14,90 @@ package vault
 
 import (
 	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
 )
 
 func (s *Store) walletsPath() string {
-	return fmt.Sprintf("/secret/%s", s.Location())
+	return s.Location()
 }
 
 func (s *Store) walletPath(walletID string) string {
-	return fmt.Sprintf("/secret/%s/%s", s.Location(), walletID)
+	return fmt.Sprintf("%s/%s", s.Location(), walletID)
 }
 
 func (s *Store) walletHeaderPath(walletID string) string {
-	return fmt.Sprintf("/secret/%s/%s/%s", s.Location(), walletID, walletID)
+	return fmt.Sprintf("%s/%s/%s", s.Location(), walletID, walletID)
 }
 
 func (s *Store) accountPath(walletID string, accountID string) string {
-	return fmt.Sprintf("/secret/%s/%s/%s", s.Location(), walletID, accountID)
+	return fmt.Sprintf("%s/%s/%s", s.Location(), walletID, accountID)
 }
 
 func (s *Store) walletIndexPath(walletID string) string {
-	return fmt.Sprintf("/secret/%s/%s/index", s.Location(), walletID)
+	return fmt.Sprintf("%s/%s/index", s.Location(), walletID)
+}
+
+func (s *Store) walletLockPath(walletID string) string {
+	return fmt.Sprintf("%s/%s/lock", s.Location(), walletID)
+}
+
+func (s *Store) auditEventPath(timestamp time.Time, eventID string) string {
+	return fmt.Sprintf("%s-audit/%s/%s", s.Location(), timestamp.Format("2006-01-02"), eventID)
+}
+
+func (s *Store) walletMetadataPath(walletID string) string {
+	return fmt.Sprintf("%s-metadata/%s/%s", s.Location(), walletID, walletID)
+}
+
+func (s *Store) accountMetadataPath(walletID string, accountID string) string {
+	return fmt.Sprintf("%s-metadata/%s/%s", s.Location(), walletID, accountID)
+}
+
+func (s *Store) walletMetadataDirPath(walletID string) string {
+	return fmt.Sprintf("%s-metadata/%s", s.Location(), walletID)
+}
+
+func (s *Store) stagingPath(walletID string, name string) string {
+	return fmt.Sprintf("%s-staging/%s/%s", s.Location(), walletID, name)
+}
+
+func (s *Store) transactionsPath() string {
+	return fmt.Sprintf("%s-transactions", s.Location())
+}
+
+func (s *Store) transactionPath(transactionID string) string {
+	return fmt.Sprintf("%s-transactions/%s/record", s.Location(), transactionID)
+}
+
+func (s *Store) transactionStagingPath(transactionID string, op int) string {
+	return fmt.Sprintf("%s-transactions/%s/%d", s.Location(), transactionID, op)
+}
+
+// idsOf returns the IDs of the wallet and account to which a key relates, if any.  The wallet ID is uuid.Nil if the key
+// is not that of a wallet, account or their metadata, and the account ID is uuid.Nil if it is not that of an account.
+func (s *Store) idsOf(key string) (uuid.UUID, uuid.UUID) {
+	var rest string
+	for _, prefix := range []string{s.Location() + "/", s.Location() + "-metadata/", s.Location() + "-staging/"} {
+		if strings.HasPrefix(key, prefix) {
+			rest = strings.TrimPrefix(key, prefix)
+			break
+		}
+	}
+	parts := strings.Split(rest, "/")
+	walletID, err := uuid.Parse(parts[0])
+	if err != nil {
+		return uuid.Nil, uuid.Nil
+	}
+	if len(parts) < 2 {
+		return walletID, uuid.Nil
+	}
+	accountID, err := uuid.Parse(parts[1])
+	if err != nil || accountID == walletID {
+		// Not an account (e.g. the wallet itself, or its index).
+		return walletID, uuid.Nil
+	}
+	return walletID, accountID
 }
@@ -0,0 +1,44 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+
+	"github.com/google/uuid"
+)
+
+// RetrieveWalletReader retrieves wallet-level data as a reader.
+// Vault returns each entry in a single response, and decryption requires the entire ciphertext, so the data is held in
+// memory once retrieved; the reader allows callers to process it as a stream alongside other stores.
+func (s *Store) RetrieveWalletReader(walletID uuid.UUID) (io.ReadCloser, error) {
+	data, err := s.RetrieveWalletByID(walletID)
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+// RetrieveAccountReader retrieves account-level data as a reader.  See RetrieveWalletReader for details.
+func (s *Store) RetrieveAccountReader(walletID uuid.UUID, accountID uuid.UUID) (io.ReadCloser, error) {
+	data, err := s.RetrieveAccount(walletID, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
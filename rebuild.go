@@ -0,0 +1,125 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// RebuildIndexes rebuilds a wallet's accounts index from the accounts held in Vault, for use after restoring from a
+// backup or editing Vault directly.  The index is only rewritten if it does not match the accounts.  Every account must
+// be readable, so that no account is dropped from the index because it could not be decrypted.
+func (s *Store) RebuildIndexes(walletID uuid.UUID) (err error) {
+	defer func() { s.audit("rebuild indexes", walletID.String(), "", "", err) }()
+
+	if err := s.Authorize(); err != nil {
+		return err
+	}
+
+	if _, err := s.retrieveWalletByID(walletID); err != nil {
+		return errors.New("unknown wallet")
+	}
+
+	_, err = s.rebuildWalletIndexes(walletID, nil)
+	return err
+}
+
+// RebuildAllIndexes rebuilds the accounts indexes of every wallet in the store, as with RebuildIndexes.
+// If supplied, progress is called after each wallet is processed; wallets whose indexes already matched their accounts
+// are reported as skipped.  Rebuilding stops at the first failure, and can be resumed by calling RebuildAllIndexes
+// again.
+func (s *Store) RebuildAllIndexes(progress func(*MaintenanceProgress)) (err error) {
+	defer func() { s.audit("rebuild indexes", "", "", "", err) }()
+
+	if err := s.Authorize(); err != nil {
+		return err
+	}
+
+	wallets, err := s.kvList(s.walletsPath())
+	if err != nil {
+		return errors.Wrap(err, "failed to list wallets")
+	}
+
+	writes, err := s.committedWrites()
+	if err != nil {
+		return err
+	}
+
+	status := &MaintenanceProgress{}
+	for _, wallet := range wallets {
+		walletID, err := uuid.Parse(strings.TrimSuffix(wallet, "/"))
+		if err != nil {
+			// Not a wallet.
+			continue
+		}
+		header, err := s.readWalletHeader(walletID.String(), writes)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read wallet %s", walletID)
+		}
+		if header == nil {
+			// Orphaned accounts are left for Check to report.
+			continue
+		}
+		rebuilt, err := s.rebuildWalletIndexes(walletID, writes)
+		if err != nil {
+			return err
+		}
+		status.report(walletID.String(), rebuilt, progress)
+	}
+
+	return nil
+}
+
+// rebuildWalletIndexes rebuilds a wallet's accounts index if it does not match its accounts, returning true if it was
+// rewritten.  Accounts are read taking account of the given committed writes.
+func (s *Store) rebuildWalletIndexes(walletID uuid.UUID, writes map[string]*committedWrite) (bool, error) {
+	keys, err := s.kvList(s.walletPath(walletID.String()))
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to list accounts for wallet %s", walletID)
+	}
+	keys = committedAccountKeys(keys, walletID.String(), writes)
+
+	accounts := make([]*indexEntry, 0, len(keys))
+	for _, key := range keys {
+		if !isAccountKey(walletID.String(), key) {
+			continue
+		}
+		data, err := s.fetchAccount(walletID, key, writes)
+		if err != nil {
+			return false, err
+		}
+		if data == nil {
+			continue
+		}
+		accounts = append(accounts, &indexEntry{UUID: key, Name: nameOf(data)})
+	}
+
+	index, err := s.accountsIndex(walletID)
+	if err != nil {
+		// An unreadable index is replaced.
+		s.log.Warn("Failed to read accounts index", "wallet", walletID, "error", err)
+		index = nil
+	}
+	if index != nil && len(indexIssues(walletID, "", accounts, index)) == 0 {
+		return false, nil
+	}
+
+	if err := s.rebuildIndex(walletID, accounts); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,138 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"encoding/json"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	wtypes "github.com/wealdtech/go-eth2-wallet-types/v2"
+)
+
+// RenameWallet changes the name of a wallet, leaving its ID and accounts unchanged.  It fails if another wallet already
+// has the new name.  If object tags are enabled the wallet name tags of the wallet and its accounts are also updated.
+func (s *Store) RenameWallet(walletID uuid.UUID, newName string) (err error) {
+	defer func() { s.audit("rename wallet", walletID.String(), newName, "", err) }()
+
+	if newName == "" {
+		return errors.New("no name supplied")
+	}
+
+	if err := s.Authorize(); err != nil {
+		return err
+	}
+
+	unlock, err := s.lockWallet(walletID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Ensure the latest version of the wallet is renamed.
+	s.walletCache.remove(walletCacheKey(walletID))
+	data, err := s.retrieveWalletByID(walletID)
+	if err != nil {
+		return err
+	}
+	if nameOf(data) == newName {
+		return nil
+	}
+
+	wallets, err := s.ListWalletNames()
+	if err != nil {
+		return err
+	}
+	for _, wallet := range wallets {
+		if wallet.Name == newName && wallet.ID != walletID {
+			return errors.New("wallet already exists")
+		}
+	}
+
+	renamed, err := renameObject(data, newName)
+	if err != nil {
+		return err
+	}
+	encryptedData, err := s.encryptIfRequired(walletID, renamed)
+	if err != nil {
+		return err
+	}
+	path := s.walletHeaderPath(walletID.String())
+	err = s.kvWrite(path, encryptedData)
+	s.walletCache.remove(walletCacheKey(walletID))
+	if err != nil {
+		if _, isConflict := err.(*ConflictError); isConflict {
+			return err
+		}
+		return errors.Wrap(err, "failed to store wallet")
+	}
+
+	if err := s.retagWallet(walletID, newName); err != nil {
+		return err
+	}
+
+	return s.mirror(func(secondary wtypes.Store) error {
+		return secondary.StoreWallet(walletID, newName, renamed)
+	})
+}
+
+// retagWallet updates the wallet name tags of a wallet and its accounts, if tagging is enabled.
+func (s *Store) retagWallet(walletID uuid.UUID, name string) error {
+	if s.tags == nil {
+		return nil
+	}
+
+	tags := map[string]string{tagWalletName: name}
+	if err := s.retagObject(s.walletHeaderPath(walletID.String()), tags); err != nil {
+		return err
+	}
+	accounts, err := s.kvList(s.walletPath(walletID.String()))
+	if err != nil {
+		return errors.Wrap(err, "failed to list accounts")
+	}
+	for _, account := range accounts {
+		if !isAccountKey(walletID.String(), account) {
+			continue
+		}
+		if err := s.retagObject(s.accountPath(walletID.String(), account), tags); err != nil {
+			return errors.Wrapf(err, "failed to tag account %s", account)
+		}
+	}
+
+	return nil
+}
+
+// renameObject returns wallet or account data with its name replaced, leaving all other fields as they are.
+func renameObject(data []byte, name string) ([]byte, error) {
+	return setField(data, "name", name)
+}
+
+// setField returns wallet or account data with the value of an existing field replaced, leaving all other fields as
+// they are.
+func setField(data []byte, field string, value interface{}) ([]byte, error) {
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, errors.Wrap(err, "invalid data")
+	}
+	if _, exists := fields[field]; !exists {
+		return nil, errors.Errorf("data has no %s", field)
+	}
+	encodedValue, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	fields[field] = encodedValue
+
+	return json.Marshal(fields)
+}
@@ -0,0 +1,77 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenameObject(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		newName  string
+		expected string
+		err      string
+	}{
+		{
+			name:    "Invalid",
+			data:    []byte(`[]`),
+			newName: "New",
+			err:     "invalid data",
+		},
+		{
+			name:    "NoName",
+			data:    []byte(`{"uuid":"7603bbd4-7d0f-4b13-9d26-c2d3d58bdbf6"}`),
+			newName: "New",
+			err:     "data has no name",
+		},
+		{
+			name:     "Good",
+			data:     []byte(`{"uuid":"7603bbd4-7d0f-4b13-9d26-c2d3d58bdbf6","name":"Old","type":"hierarchical deterministic","crypto":{"kdf":{"function":"pbkdf2"}},"nextaccount":3,"version":1}`),
+			newName:  `New "quoted"`,
+			expected: `{"uuid":"7603bbd4-7d0f-4b13-9d26-c2d3d58bdbf6","name":"New \"quoted\"","type":"hierarchical deterministic","crypto":{"kdf":{"function":"pbkdf2"}},"nextaccount":3,"version":1}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			renamed, err := renameObject(test.data, test.newName)
+			if test.err != "" {
+				require.NotNil(t, err)
+				assert.True(t, strings.HasPrefix(err.Error(), test.err), err.Error())
+			} else {
+				require.NoError(t, err)
+				assert.JSONEq(t, test.expected, string(renamed))
+				assert.Equal(t, test.newName, nameOf(renamed))
+			}
+		})
+	}
+}
+
+func TestSetField(t *testing.T) {
+	data := []byte(`{"uuid":"7603bbd4-7d0f-4b13-9d26-c2d3d58bdbf6","name":"Old","nextaccount":3}`)
+
+	updated, err := setField(data, "uuid", "0f4a4c67-0b3a-4fb6-8d86-d3e0c1c1bf2a")
+	require.Nil(t, err)
+	assert.JSONEq(t, `{"uuid":"0f4a4c67-0b3a-4fb6-8d86-d3e0c1c1bf2a","name":"Old","nextaccount":3}`, string(updated))
+
+	_, err = setField(data, "version", 1)
+	require.NotNil(t, err)
+	assert.Equal(t, "data has no version", err.Error())
+}
@@ -0,0 +1,145 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// replicaClient is a client for a read replica of Vault, such as a performance secondary cluster, with its own token.
+type replicaClient struct {
+	client       *api.Client
+	mutex        sync.Mutex
+	authorized   bool
+	tokenExpires time.Time
+}
+
+// newReplicaClient creates a client for the replica at the given address.
+func newReplicaClient(address string, timeout time.Duration, headers http.Header, requestIDs bool) (*replicaClient, error) {
+	config := &api.Config{
+		Address: address,
+	}
+	// Fail requests that the replica has rate limited; its requests to back off do not apply to the primary.
+	config.HttpClient = api.DefaultConfig().HttpClient
+	config.HttpClient.Transport = &retryAfterTransport{
+		base:       config.HttpClient.Transport,
+		retryAfter: &retryAfter{},
+	}
+	if requestIDs {
+		config.HttpClient.Transport = &requestIDTransport{
+			base: config.HttpClient.Transport,
+		}
+	}
+	client, err := api.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+	if timeout > 0 {
+		client.SetClientTimeout(timeout)
+	}
+	client.SetHeaders(headers)
+
+	return &replicaClient{
+		client: client,
+	}, nil
+}
+
+// authorizeReplica logs in to the replica in the same way as Authorize logs in to the primary, if the replica's token
+// is missing or has expired.
+func (s *Store) authorizeReplica() error {
+	r := s.replica
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.authorized && (r.tokenExpires.IsZero() || time.Now().Before(r.tokenExpires)) {
+		return nil
+	}
+
+	if s.token != "" {
+		r.client.SetToken(s.token)
+		r.authorized = true
+		return nil
+	}
+
+	resp, err := r.client.Logical().Write("auth/kubernetes/login", map[string]interface{}{
+		"role": s.role,
+		"jwt":  s.jwt,
+	})
+	if err != nil {
+		return err
+	}
+	if resp == nil || resp.Auth == nil {
+		return errors.New("no token returned by replica")
+	}
+
+	r.client.SetToken(resp.Auth.ClientToken)
+	r.authorized = true
+	r.tokenExpires = time.Time{}
+	if resp.Auth.LeaseDuration > 0 {
+		lease := time.Duration(resp.Auth.LeaseDuration) * time.Second
+		r.tokenExpires = time.Now().Add(lease * 9 / 10)
+	}
+
+	return nil
+}
+
+// authorizeRead logs in to Vault as Authorize before a read.  If the primary is unavailable and a replica is configured
+// the read is allowed to proceed, so that it can be served by the replica.
+func (s *Store) authorizeRead() error {
+	err := s.Authorize()
+	if err != nil && s.replica != nil && replicaFallback(err) {
+		s.log.Warn("Failed to log in to primary; reading from replica", "error", err)
+		return nil
+	}
+	return err
+}
+
+// readVault carries out a read with the primary's client and, if the primary is unavailable and a replica is
+// configured, repeats it with the replica's client.  It returns true if the read was served by the replica.
+// Only reads may be passed to readVault; writes always go to the primary.
+func (s *Store) readVault(operation string, key string, read func(client *api.Client) error) (bool, error) {
+	err := s.callVault(operation, key, func() error {
+		return read(s.client)
+	})
+	if err == nil || s.replica == nil || !replicaFallback(err) {
+		return false, err
+	}
+
+	if authErr := s.authorizeReplica(); authErr != nil {
+		s.log.Warn("Failed to log in to replica", "error", authErr)
+		return false, err
+	}
+	if replicaErr := read(s.replica.client); replicaErr != nil {
+		s.log.Warn("Replica read failed", "operation", operation, "path", key, "error", replicaErr)
+		return false, err
+	}
+	s.log.Warn("Primary unavailable; read from replica", "operation", operation, "path", key, "error", err)
+
+	return true, nil
+}
+
+// replicaFallback returns true if a failed read from the primary should be repeated against the replica: the primary
+// is unavailable, sealed or overloaded, rather than having rejected the request.
+func replicaFallback(err error) bool {
+	switch errors.Cause(err).(type) {
+	case *UnavailableError, *SealedError:
+		return true
+	}
+	return DefaultRetryable(err)
+}
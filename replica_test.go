@@ -0,0 +1,121 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplicaFallback(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		fallback bool
+	}{
+		{
+			name:     "Unavailable",
+			err:      &UnavailableError{RetryAt: time.Now()},
+			fallback: true,
+		},
+		{
+			name:     "Sealed",
+			err:      &SealedError{},
+			fallback: true,
+		},
+		{
+			name:     "ServerError",
+			err:      pkgerrors.Wrap(&api.ResponseError{StatusCode: 503}, "vault read"),
+			fallback: true,
+		},
+		{
+			name: "PermissionDenied",
+			err:  pkgerrors.Wrap(&api.ResponseError{StatusCode: 403}, "vault read"),
+		},
+		{
+			name: "Other",
+			err:  errors.New("bad request"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.fallback, replicaFallback(test.err))
+		})
+	}
+}
+
+func TestLockReadsPrimary(t *testing.T) {
+	primaryAvailable := true
+	var cas interface{}
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !primaryAvailable {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Method == http.MethodGet {
+			_, _ = w.Write([]byte(`{"data":{"data":{"owner":"","expires":0},"metadata":{"version":3}}}`))
+			return
+		}
+		request := struct {
+			Options map[string]interface{} `json:"options"`
+		}{}
+		require.Nil(t, json.NewDecoder(r.Body).Decode(&request))
+		cas = request.Options["cas"]
+		_, _ = w.Write([]byte(`{"data":{"version":4}}`))
+	}))
+	defer primary.Close()
+	replicaReads := 0
+	replica := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		replicaReads++
+		_, _ = w.Write([]byte(`{"data":{"data":{"owner":"","expires":0},"metadata":{"version":3}}}`))
+	}))
+	defer replica.Close()
+
+	primaryAPI, err := api.NewClient(&api.Config{Address: primary.URL})
+	require.Nil(t, err)
+	replicaAPI, err := api.NewClient(&api.Config{Address: replica.URL})
+	require.Nil(t, err)
+	store := &Store{
+		log:          nopLogger{},
+		client:       primaryAPI,
+		replica:      &replicaClient{client: replicaAPI, authorized: true},
+		kvMount:      "secret",
+		kvVersion:    2,
+		vaultSubPath: "eth",
+		lockTTL:      time.Minute,
+	}
+	key := store.walletLockPath("wallet")
+
+	// The lock is written against the version read from the primary.
+	version, err := store.tryLock(key, "owner")
+	require.Nil(t, err)
+	assert.Equal(t, 4, version)
+	assert.Equal(t, float64(3), cas)
+
+	// The replica is not consulted when the primary is unavailable.
+	primaryAvailable = false
+	_, err = store.tryLock(key, "owner")
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, replicaReads)
+}
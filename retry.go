@@ -0,0 +1,196 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// RetryPolicy defines how failed Vault operations are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts made for each operation, including the first.
+	MaxAttempts int
+	// InitialBackoff is the time to wait before the first retry.  It doubles with each subsequent retry.
+	InitialBackoff time.Duration
+	// MaxBackoff is the maximum time to wait before any retry.
+	MaxBackoff time.Duration
+	// Retryable decides if an error should be retried.  If nil, DefaultRetryable is used.
+	Retryable func(error) bool
+}
+
+// DefaultRetryPolicy is a retry policy suitable for most deployments.
+var DefaultRetryPolicy = &RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+}
+
+// DefaultRetryable returns true if the error is likely to be transient: network errors, and Vault responses with a
+// status of 429 (too many requests) or 5xx.
+func DefaultRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var rateLimitedErr *RateLimitedError
+	if errors.As(err, &rateLimitedErr) {
+		return true
+	}
+
+	var responseErr *api.ResponseError
+	if errors.As(err, &responseErr) {
+		return responseErr.StatusCode == http.StatusTooManyRequests || responseErr.StatusCode >= 500
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// permissionDenied returns true if the error is Vault rejecting the store's token.
+func permissionDenied(err error) bool {
+	var responseErr *api.ResponseError
+	return errors.As(err, &responseErr) && responseErr.StatusCode == http.StatusForbidden
+}
+
+// authFailure returns the reason for which an attempt to log in to Vault failed.
+func authFailure(err error) AuthFailure {
+	var sealedErr *SealedError
+	if errors.As(err, &sealedErr) {
+		return AuthFailureSealed
+	}
+	var responseErr *api.ResponseError
+	if errors.As(err, &responseErr) {
+		for _, message := range responseErr.Errors {
+			if strings.Contains(message, "Vault is sealed") {
+				return AuthFailureSealed
+			}
+		}
+	}
+	if permissionDenied(err) {
+		return AuthFailurePermissionDenied
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, errStoreClosed) {
+		return AuthFailureCancelled
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return AuthFailureNetwork
+	}
+	return AuthFailureOther
+}
+
+// backoff returns the time to wait before the given retry, with full jitter.
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 1; i < retry && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	if backoff <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(backoff)))
+}
+
+// retryBackoff returns the time to wait before the given retry under a policy.  If Vault has asked for a longer wait the
+// longer wait is used, but never more than the policy's maximum backoff, so that a single overlong Retry-After does not
+// stall every retry in the process.
+func (s *Store) retryBackoff(policy *RetryPolicy, retry int) time.Duration {
+	backoff := policy.backoff(retry)
+	if wait := s.retryAfter.wait(); wait > backoff {
+		backoff = wait
+		if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+
+	return backoff
+}
+
+// storeError wraps an error returned by Vault for an operation on a path.
+func (s *Store) storeError(operation string, path string, err error, transient bool) *StoreError {
+	walletID, accountID := s.idsOf(path)
+	return &StoreError{
+		Op:        operation,
+		Backend:   s.Name(),
+		Store:     s.URL(),
+		Path:      path,
+		WalletID:  walletID,
+		AccountID: accountID,
+		Err:       err,
+		transient: transient,
+	}
+}
+
+// callVault carries out a Vault operation, subject to the store's circuit breaker and retry policy.
+func (s *Store) callVault(operation string, path string, op func() error) error {
+	policy := s.retryPolicy
+	if policy == nil {
+		policy = &RetryPolicy{MaxAttempts: 1}
+	}
+	return s.callVaultWithPolicy(context.Background(), operation, path, policy, op)
+}
+
+// callVaultWithPolicy carries out a Vault operation, subject to the store's circuit breaker and the given retry policy.
+// Retries are abandoned if ctx is done or the store is closed whilst waiting to make them.
+func (s *Store) callVaultWithPolicy(ctx context.Context, operation string, path string, policy *RetryPolicy, op func() error) error {
+	retryable := policy.Retryable
+	if retryable == nil {
+		retryable = DefaultRetryable
+	}
+
+	reauthorized := false
+	for attempt := 1; ; attempt++ {
+		if err := s.breaker.allow(); err != nil {
+			s.log.Warn("Circuit breaker open; not calling Vault", "operation", operation, "path", path)
+			return err
+		}
+		started := time.Now()
+		err := op()
+		transient := retryable(err)
+		s.breaker.record(transient)
+		s.log.Debug("Vault call", "operation", operation, "path", path, "attempt", attempt, "duration", time.Since(started), "error", err)
+		if !reauthorized && operation != "login" && s.jwt != "" && permissionDenied(err) {
+			// The token may have been revoked or expired early; log in again and repeat the attempt.
+			reauthorized = true
+			s.log.Debug("Permission denied; logging in again", "operation", operation, "path", path)
+			if authErr := s.reauthorize(); authErr == nil {
+				attempt--
+				continue
+			}
+		}
+		if err == nil {
+			return nil
+		}
+		if attempt >= policy.MaxAttempts || !transient {
+			return s.storeError(operation, path, err, transient)
+		}
+		backoff := s.retryBackoff(policy, attempt)
+		s.log.Warn("Retrying Vault call", "operation", operation, "path", path, "attempt", attempt, "backoff", backoff, "error", err)
+		if sleepErr := s.sleep(ctx, backoff); sleepErr != nil {
+			return s.storeError(operation, path, errors.Wrapf(sleepErr, "retry abandoned after %v", err), false)
+		}
+	}
+}
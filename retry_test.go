@@ -0,0 +1,463 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultRetryable(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{
+			name:      "Nil",
+			err:       nil,
+			retryable: false,
+		},
+		{
+			name:      "Plain",
+			err:       errors.New("bad"),
+			retryable: false,
+		},
+		{
+			name:      "Conflict",
+			err:       &ConflictError{Key: "eth/test"},
+			retryable: false,
+		},
+		{
+			name:      "TooManyRequests",
+			err:       &api.ResponseError{StatusCode: 429},
+			retryable: true,
+		},
+		{
+			name:      "Unavailable",
+			err:       errors.Wrap(&api.ResponseError{StatusCode: 503}, "failed"),
+			retryable: true,
+		},
+		{
+			name:      "Forbidden",
+			err:       &api.ResponseError{StatusCode: 403},
+			retryable: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.retryable, DefaultRetryable(test.err))
+		})
+	}
+}
+
+func TestWithRetries(t *testing.T) {
+	store := &Store{
+		log: nopLogger{},
+		retryPolicy: &RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     2 * time.Millisecond,
+		},
+	}
+
+	// Retryable errors are retried up to the maximum number of attempts.
+	attempts := 0
+	err := store.callVault("read", "eth/test", func() error {
+		attempts++
+		return &api.ResponseError{StatusCode: 503}
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 3, attempts)
+
+	// Non-retryable errors are returned immediately.
+	attempts = 0
+	err = store.callVault("read", "eth/test", func() error {
+		attempts++
+		return errors.New("bad")
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, attempts)
+
+	// Success after a transient failure.
+	attempts = 0
+	err = store.callVault("read", "eth/test", func() error {
+		attempts++
+		if attempts == 1 {
+			return &api.ResponseError{StatusCode: 500}
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, attempts)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	wait, ok := parseRetryAfter("3", now)
+	assert.True(t, ok)
+	assert.Equal(t, 3*time.Second, wait)
+
+	wait, ok = parseRetryAfter("Fri, 01 May 2020 12:00:10 GMT", now)
+	assert.True(t, ok)
+	assert.Equal(t, 10*time.Second, wait)
+
+	_, ok = parseRetryAfter("", now)
+	assert.False(t, ok)
+	_, ok = parseRetryAfter("soon", now)
+	assert.False(t, ok)
+	_, ok = parseRetryAfter("-1", now)
+	assert.False(t, ok)
+}
+
+func TestRetryAfterHonoured(t *testing.T) {
+	tracker := &retryAfter{}
+	store := &Store{
+		log: nopLogger{},
+		retryPolicy: &RetryPolicy{
+			MaxAttempts:    2,
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     time.Second,
+		},
+		retryAfter: tracker,
+	}
+
+	started := time.Now()
+	attempts := 0
+	err := store.callVault("read", "eth/test", func() error {
+		attempts++
+		if attempts == 1 {
+			tracker.set(time.Now().Add(50 * time.Millisecond))
+			return &api.ResponseError{StatusCode: 429}
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, attempts)
+	assert.True(t, time.Since(started) >= 50*time.Millisecond)
+
+	// An earlier time does not replace a later one.
+	tracker.set(time.Now().Add(time.Hour))
+	tracker.set(time.Now())
+	assert.True(t, tracker.wait() > time.Minute)
+
+	// Waits are no longer than the policy's maximum backoff.
+	assert.Equal(t, time.Second, store.retryBackoff(store.retryPolicy, 1))
+}
+
+func TestPermissionDenied(t *testing.T) {
+	assert.True(t, permissionDenied(&api.ResponseError{StatusCode: 403}))
+	assert.True(t, permissionDenied(errors.Wrap(&api.ResponseError{StatusCode: 403}, "failed")))
+	assert.False(t, permissionDenied(&api.ResponseError{StatusCode: 404}))
+	assert.False(t, permissionDenied(errors.New("bad")))
+	assert.False(t, permissionDenied(nil))
+}
+
+func TestTokenValid(t *testing.T) {
+	store := &Store{}
+	assert.False(t, store.tokenValid())
+
+	store.authorized = true
+	assert.True(t, store.tokenValid())
+
+	store.tokenExpires = time.Now().Add(time.Minute)
+	assert.True(t, store.tokenValid())
+
+	store.tokenExpires = time.Now().Add(-time.Minute)
+	assert.False(t, store.tokenValid())
+}
+
+func TestStoreError(t *testing.T) {
+	walletID := uuid.New()
+	accountID := uuid.New()
+	store := &Store{
+		vaultAddress: "https://vault.example.com:8200",
+		kvMount:      "secret",
+		vaultSubPath: "eth",
+		log:          nopLogger{},
+	}
+
+	tests := []struct {
+		name      string
+		path      string
+		opErr     error
+		walletID  uuid.UUID
+		accountID uuid.UUID
+		transient bool
+	}{
+		{
+			name:      "Account",
+			path:      store.accountPath(walletID.String(), accountID.String()),
+			opErr:     &api.ResponseError{StatusCode: 503},
+			walletID:  walletID,
+			accountID: accountID,
+			transient: true,
+		},
+		{
+			name:     "Wallet",
+			path:     store.walletHeaderPath(walletID.String()),
+			opErr:    &api.ResponseError{StatusCode: 400},
+			walletID: walletID,
+		},
+		{
+			name:     "Index",
+			path:     store.walletIndexPath(walletID.String()),
+			opErr:    errors.New("bad"),
+			walletID: walletID,
+		},
+		{
+			name:      "AccountMetadata",
+			path:      store.accountMetadataPath(walletID.String(), accountID.String()),
+			opErr:     &api.ResponseError{StatusCode: 429},
+			walletID:  walletID,
+			accountID: accountID,
+			transient: true,
+		},
+		{
+			name:  "Wallets",
+			path:  store.walletsPath(),
+			opErr: errors.New("bad"),
+		},
+		{
+			name:  "Transaction",
+			path:  store.transactionPath(uuid.New().String()),
+			opErr: errors.New("bad"),
+		},
+		{
+			name:  "System",
+			path:  "sys/mounts",
+			opErr: errors.New("bad"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := store.callVault("read", test.path, func() error {
+				return test.opErr
+			})
+			var storeErr *StoreError
+			require.True(t, errors.As(errors.Wrap(err, "failed"), &storeErr))
+			assert.Equal(t, "read", storeErr.Op)
+			assert.Equal(t, "vault", storeErr.Backend)
+			assert.Equal(t, "https://vault.example.com:8200/secret/eth", storeErr.Store)
+			assert.Equal(t, test.path, storeErr.Path)
+			assert.Equal(t, test.walletID, storeErr.WalletID)
+			assert.Equal(t, test.accountID, storeErr.AccountID)
+			assert.Equal(t, test.opErr, errors.Cause(err))
+			assert.Equal(t, test.transient, storeErr.IsTransient())
+			assert.Equal(t, test.transient, IsTransient(err))
+		})
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	assert.False(t, IsTransient(nil))
+	assert.False(t, IsTransient(errors.New("bad")))
+	assert.False(t, IsTransient(&ConflictError{Key: "eth/test"}))
+	assert.True(t, IsTransient(&UnavailableError{RetryAt: time.Now()}))
+	assert.True(t, IsTransient(errors.Wrap(&SealedError{}, "failed")))
+	assert.True(t, IsTransient(&StoreError{Err: errors.New("timeout"), transient: true}))
+}
+
+func TestAuthFailure(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		reason    AuthFailure
+		transient bool
+	}{
+		{
+			name:      "Sealed",
+			err:       errors.Wrap(&SealedError{}, "failed"),
+			reason:    AuthFailureSealed,
+			transient: true,
+		},
+		{
+			name:      "SealedResponse",
+			err:       &api.ResponseError{StatusCode: 503, Errors: []string{"Vault is sealed"}},
+			reason:    AuthFailureSealed,
+			transient: true,
+		},
+		{
+			name:   "PermissionDenied",
+			err:    &api.ResponseError{StatusCode: 403, Errors: []string{"permission denied"}},
+			reason: AuthFailurePermissionDenied,
+		},
+		{
+			name:   "Cancelled",
+			err:    errors.Wrap(context.Canceled, "failed"),
+			reason: AuthFailureCancelled,
+		},
+		{
+			name:   "DeadlineExceeded",
+			err:    context.DeadlineExceeded,
+			reason: AuthFailureCancelled,
+		},
+		{
+			name:      "Network",
+			err:       &net.OpError{Op: "dial", Err: errors.New("connection refused")},
+			reason:    AuthFailureNetwork,
+			transient: true,
+		},
+		{
+			name:      "ServerError",
+			err:       &StoreError{Err: &api.ResponseError{StatusCode: 500}, transient: true},
+			reason:    AuthFailureOther,
+			transient: true,
+		},
+		{
+			name:   "BadRequest",
+			err:    &StoreError{Err: &api.ResponseError{StatusCode: 400}},
+			reason: AuthFailureOther,
+		},
+		{
+			name:      "Unavailable",
+			err:       &UnavailableError{RetryAt: time.Now()},
+			reason:    AuthFailureOther,
+			transient: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.reason, authFailure(test.err))
+			authErr := &AuthError{Reason: authFailure(test.err), Attempts: 1, Err: test.err}
+			assert.Equal(t, test.transient, authErr.IsTransient())
+			assert.Equal(t, test.transient, IsTransient(errors.Wrap(authErr, "failed")))
+		})
+	}
+}
+
+func TestAuthErrorReplicaFallback(t *testing.T) {
+	err := &AuthError{
+		Reason:   AuthFailureOther,
+		Attempts: 5,
+		Err:      &StoreError{Err: &api.ResponseError{StatusCode: 503}, transient: true},
+	}
+	assert.True(t, replicaFallback(err))
+
+	err = &AuthError{
+		Reason:   AuthFailurePermissionDenied,
+		Attempts: 1,
+		Err:      &StoreError{Err: &api.ResponseError{StatusCode: 403}},
+	}
+	assert.False(t, replicaFallback(err))
+}
+
+func TestRateLimited(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"errors":["request path \"secret/data/eth\": rate limit quota exceeded"]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"data":{"name":"test"}}}`))
+	}))
+	defer server.Close()
+
+	tracker := &retryAfter{}
+	client := &http.Client{
+		Transport: &retryAfterTransport{
+			retryAfter: tracker,
+		},
+	}
+
+	// A rate-limited request fails with a transient error rather than returning the error body.
+	_, err := client.Get(server.URL + "/v1/secret/data/eth")
+	require.NotNil(t, err)
+	var rateLimitedErr *RateLimitedError
+	require.True(t, errors.As(err, &rateLimitedErr))
+	assert.Equal(t, 2*time.Second, rateLimitedErr.RetryAfter)
+	assert.True(t, DefaultRetryable(err))
+	assert.True(t, IsTransient(err))
+	assert.True(t, tracker.wait() > time.Second)
+
+	resp, err := client.Get(server.URL + "/v1/secret/data/eth")
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// A store retries the rate-limited operation.
+	store := &Store{
+		log: nopLogger{},
+		retryPolicy: &RetryPolicy{
+			MaxAttempts:    2,
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     time.Millisecond,
+		},
+	}
+	attempts := 0
+	err = store.callVault("read", "eth/test", func() error {
+		attempts++
+		if attempts == 1 {
+			return &url.Error{Op: "Get", URL: server.URL, Err: &RateLimitedError{}}
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, attempts)
+}
+
+func TestRetriesAbandoned(t *testing.T) {
+	storeCtx, storeCancel := context.WithCancel(context.Background())
+	store := &Store{
+		log: nopLogger{},
+		retryPolicy: &RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: time.Hour,
+			MaxBackoff:     time.Hour,
+		},
+		ctx:    storeCtx,
+		cancel: storeCancel,
+	}
+	attempts := 0
+	op := func() error {
+		attempts++
+		return &api.ResponseError{StatusCode: 503}
+	}
+
+	// Retries stop when the context is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := store.callVaultWithPolicy(ctx, "read", "eth/test", store.retryPolicy, op)
+	require.NotNil(t, err)
+	assert.Equal(t, 1, attempts)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.False(t, IsTransient(err))
+
+	// Retries stop when the store is closed.
+	attempts = 0
+	require.Nil(t, store.Close())
+	err = store.callVault("read", "eth/test", op)
+	require.NotNil(t, err)
+	assert.Equal(t, 1, attempts)
+	assert.True(t, errors.Is(err, errStoreClosed))
+	assert.Equal(t, AuthFailureCancelled, authFailure(err))
+}
@@ -0,0 +1,113 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// retryAfter tracks the earliest time at which Vault has asked to be sent further requests, as given by the Retry-After
+// header of its 429 and 503 responses.
+type retryAfter struct {
+	notBefore int64
+}
+
+// set records that no requests should be sent before the given time, unless a later time is already recorded.
+func (r *retryAfter) set(notBefore time.Time) {
+	for {
+		current := atomic.LoadInt64(&r.notBefore)
+		if notBefore.UnixNano() <= current {
+			return
+		}
+		if atomic.CompareAndSwapInt64(&r.notBefore, current, notBefore.UnixNano()) {
+			return
+		}
+	}
+}
+
+// wait returns the time remaining until requests may be sent.  It is nil-safe.
+func (r *retryAfter) wait() time.Duration {
+	if r == nil {
+		return 0
+	}
+	notBefore := atomic.LoadInt64(&r.notBefore)
+	if notBefore == 0 {
+		return 0
+	}
+	return time.Until(time.Unix(0, notBefore))
+}
+
+// retryAfterTransport records the Retry-After header of Vault's 429 and 503 responses.  It also fails 429 responses with a
+// RateLimitedError, as the Vault client does not treat them as errors: a rate-limited read would otherwise appear to
+// find nothing, and a rate-limited write to succeed.
+type retryAfterTransport struct {
+	base       http.RoundTripper
+	retryAfter *retryAfter
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *retryAfterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return resp, nil
+	}
+
+	now := time.Now()
+	wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), now)
+	if ok {
+		t.retryAfter.set(now.Add(wait))
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		// Discard the body so that the connection can be reused.
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		return nil, &RateLimitedError{RetryAfter: wait}
+	}
+
+	return resp, nil
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which is either a number of seconds or an HTTP date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if date.Before(now) {
+			return 0, true
+		}
+		return date.Sub(now), true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,91 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// RotateEncryptionKey re-encrypts every wallet and account in the store, changing the passphrase with which they are
+// encrypted from oldKey to newKey.  Unencrypted objects are encrypted with newKey.
+// If supplied, progress is called after each object is processed; objects that were already encrypted with newKey or are
+// encrypted by Vault's Transit secrets engine are reported as skipped.
+// Rotation stops at the first failure, and can be resumed by calling RotateEncryptionKey again with the same keys; objects
+// that are already encrypted with newKey are skipped.
+// Once rotation is complete the store should be recreated with newKey as its passphrase.
+func (s *Store) RotateEncryptionKey(oldKey []byte, newKey []byte, progress func(*MaintenanceProgress)) error {
+	if len(newKey) == 0 {
+		return errors.New("no new key supplied")
+	}
+
+	if err := s.Authorize(); err != nil {
+		return err
+	}
+
+	status := &MaintenanceProgress{}
+	return s.walkObjects(func(kind string, walletID uuid.UUID, key string) error {
+		rotated, err := s.rotateObject(walletID, key, oldKey, newKey)
+		if err != nil {
+			return errors.Wrapf(err, "failed to rotate %s %s", kind, key)
+		}
+		status.report(key, rotated, progress)
+		return nil
+	})
+}
+
+// rotateObject re-encrypts a single object with a new passphrase, returning true if it was rewritten.
+func (s *Store) rotateObject(walletID uuid.UUID, key string, oldKey []byte, newKey []byte) (bool, error) {
+	unlock, err := s.lockWallet(walletID)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	data, err := s.readObject(key)
+	if err != nil {
+		return false, err
+	}
+	if data == nil {
+		// Removed since it was listed.
+		return false, nil
+	}
+
+	if env := openEnvelope(data); env != nil {
+		if env.Encryption == encryptionTransit {
+			return false, nil
+		}
+		if env.Encryption == encryptionPassphrase {
+			if _, err := s.unseal(data, newKey); err == nil {
+				// Already rotated.
+				return false, nil
+			}
+		}
+	}
+
+	plaintext, err := s.unseal(data, oldKey)
+	if err != nil {
+		return false, err
+	}
+	ciphertext, err := s.seal(plaintext, newKey, "")
+	if err != nil {
+		return false, err
+	}
+	if err := s.kvWrite(key, ciphertext); err != nil {
+		return false, err
+	}
+	s.invalidate(walletID, key)
+
+	return true, nil
+}
@@ -0,0 +1,48 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"github.com/pkg/errors"
+	wtypes "github.com/wealdtech/go-eth2-wallet-types/v2"
+)
+
+// SecondaryFailurePolicy defines how the store handles failures writing to its secondary store.
+type SecondaryFailurePolicy int
+
+const (
+	// SecondaryFailureIgnore ignores failures writing to the secondary store.
+	SecondaryFailureIgnore SecondaryFailurePolicy = iota
+	// SecondaryFailureReturn returns failures writing to the secondary store to the caller.
+	// Note that the write to the primary store will already have taken place.
+	SecondaryFailureReturn
+)
+
+// mirror carries out a write against the secondary store, if one is configured.
+func (s *Store) mirror(write func(wtypes.Store) error) error {
+	if s.secondary == nil {
+		return nil
+	}
+
+	err := write(s.secondary)
+
+	if err != nil {
+		if s.secondaryFailurePolicy == SecondaryFailureReturn {
+			return errors.Wrap(err, "failed to write to secondary store")
+		}
+		s.log.Warn("Failed to write to secondary store", "error", err)
+	}
+
+	return nil
+}
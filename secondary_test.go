@@ -0,0 +1,189 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	vault "github.com/stakedllc/go-eth2-wallet-store-vault"
+	"github.com/stakedllc/go-eth2-wallet-store-vault/vaulttest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// kvServer is a stand-in for version 1 of the KV secrets engine, holding its data in memory.
+type kvServer struct {
+	mutex sync.Mutex
+	data  map[string]json.RawMessage
+}
+
+func (k *kvServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	key := strings.TrimPrefix(r.URL.Path, "/v1/secret/")
+	switch {
+	case r.Method == "LIST" || r.URL.Query().Get("list") == "true":
+		children := make(map[string]bool)
+		for stored := range k.data {
+			if child := strings.TrimPrefix(stored, key+"/"); child != stored {
+				if i := strings.Index(child, "/"); i >= 0 {
+					child = child[:i+1]
+				}
+				children[child] = true
+			}
+		}
+		if len(children) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		keys := make([]string, 0, len(children))
+		for child := range children {
+			keys = append(keys, child)
+		}
+		sort.Strings(keys)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"keys": keys}})
+	case r.Method == http.MethodGet:
+		data, exists := k.data[key]
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"data":%s}`, data)
+	case r.Method == http.MethodPut || r.Method == http.MethodPost:
+		data := json.RawMessage{}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		k.data[key] = data
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete:
+		delete(k.data, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// newKVStore creates a store backed by an in-memory KV secrets engine, along with a function to close both.
+func newKVStore(t *testing.T, opts ...vault.Option) (*vault.Store, func()) {
+	server := httptest.NewServer(&kvServer{data: make(map[string]json.RawMessage)})
+	store, err := vault.New(append([]vault.Option{
+		vault.WithVaultAddress(server.URL),
+		vault.WithToken("test"),
+	}, opts...)...)
+	if err != nil {
+		server.Close()
+	}
+	require.Nil(t, err)
+
+	return store.(*vault.Store), func() {
+		store.(*vault.Store).Close()
+		server.Close()
+	}
+}
+
+// failingStore is an in-memory store whose writes of wallets and accounts indexes fail.
+type failingStore struct {
+	*vaulttest.Store
+}
+
+func (s *failingStore) StoreWallet(walletID uuid.UUID, walletName string, data []byte) error {
+	return errors.New("secondary unavailable")
+}
+
+func (s *failingStore) StoreAccountsIndex(walletID uuid.UUID, data []byte) error {
+	return errors.New("secondary unavailable")
+}
+
+func TestSecondaryMirror(t *testing.T) {
+	secondary := vaulttest.New()
+	store, closeStore := newKVStore(t, vault.WithSecondaryStore(secondary))
+	defer closeStore()
+
+	walletID := uuid.New()
+	require.Nil(t, store.StoreWallet(walletID, "Test wallet", walletData(walletID, "Test wallet")))
+	accountID := uuid.New()
+	require.Nil(t, store.StoreAccount(walletID, accountID, accountData(accountID, "Test account")))
+	index := []byte(fmt.Sprintf(`[{"uuid":%q,"name":"Test account"}]`, accountID))
+	require.Nil(t, store.StoreAccountsIndex(walletID, index))
+
+	data, err := secondary.RetrieveWalletByID(walletID)
+	require.Nil(t, err)
+	assert.JSONEq(t, string(walletData(walletID, "Test wallet")), string(data))
+	data, err = secondary.RetrieveAccount(walletID, accountID)
+	require.Nil(t, err)
+	assert.JSONEq(t, string(accountData(accountID, "Test account")), string(data))
+	data, err = secondary.RetrieveAccountsIndex(walletID)
+	require.Nil(t, err)
+	assert.JSONEq(t, string(index), string(data))
+}
+
+func TestSecondaryFailurePolicy(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []vault.Option
+		err  bool
+	}{
+		{
+			name: "Default",
+		},
+		{
+			name: "Ignore",
+			opts: []vault.Option{vault.WithSecondaryFailurePolicy(vault.SecondaryFailureIgnore)},
+		},
+		{
+			name: "Return",
+			opts: []vault.Option{vault.WithSecondaryFailurePolicy(vault.SecondaryFailureReturn)},
+			err:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := append([]vault.Option{vault.WithSecondaryStore(&failingStore{vaulttest.New()})}, test.opts...)
+			store, closeStore := newKVStore(t, opts...)
+			defer closeStore()
+
+			walletID := uuid.New()
+			err := store.StoreWallet(walletID, "Test wallet", walletData(walletID, "Test wallet"))
+			if test.err {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+			}
+			err = store.StoreAccountsIndex(walletID, []byte(`[]`))
+			if test.err {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+			}
+
+			// The write to Vault is made whatever the policy.
+			data, err := store.RetrieveWalletByID(walletID)
+			require.Nil(t, err)
+			assert.JSONEq(t, string(walletData(walletID, "Test wallet")), string(data))
+		})
+	}
+}
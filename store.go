@@ -14,18 +14,69 @@
 package vault
 
 import (
+	"context"
+	"fmt"
 	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
 	wtypes "github.com/wealdtech/go-eth2-wallet-types/v2"
 )
 
+// userAgent is the User-Agent header sent with requests to Vault.
+const userAgent = "go-eth2-wallet-store-vault"
+
 // options are the options for the S3 store
 type options struct {
-	passphrase   []byte
-	role         string
-	vaultAddress string
-	vaultSubPath string
+	passphrase             []byte
+	role                   string
+	token                  string
+	vaultAddress           string
+	replicaAddress         string
+	requestIDs             bool
+	cipher                 Cipher
+	kdf                    kdfParams
+	vaultSubPath           string
+	secondary              wtypes.Store
+	secondaryFailurePolicy SecondaryFailurePolicy
+	cacheDir               string
+	cache                  bool
+	cacheSize              int
+	cacheTTL               time.Duration
+	kvVersion              int
+	checkAndSet            bool
+	lockTTL                time.Duration
+	lockTimeout            time.Duration
+	retryPolicy            *RetryPolicy
+	breakerThreshold       int
+	breakerCooldown        time.Duration
+	operationTimeout       time.Duration
+	logger                 Logger
+	auditSinks             []AuditSink
+	vaultAudit             bool
+	transitMount           string
+	transitKey             string
+	checksums              bool
+	compression            bool
+	tags                   map[string]string
+	accountOrder           AccountOrder
+	kvMount                string
+	vaultWait              time.Duration
+	responseWrapTTL        time.Duration
+	walletCacheTTL         time.Duration
+	concurrency            int
+	watchInterval          time.Duration
+	channelBuffer          int
+	application            string
+	headers                http.Header
+	validatePayloads       bool
+	keyProvider            KeyProvider
 }
 
 // Option gives options to New
@@ -46,6 +97,14 @@ func WithVaultAddress(vaultAddress string) Option {
 	})
 }
 
+// WithReplicaAddress sets the address of a read replica of Vault, such as a performance secondary cluster.  Reads that
+// fail because the primary is unavailable are repeated against the replica; writes are only made to the primary.
+func WithReplicaAddress(address string) Option {
+	return optionFunc(func(o *options) {
+		o.replicaAddress = address
+	})
+}
+
 // WithPassphrase sets the passphrase for the store.
 func WithPassphrase(passphrase []byte) Option {
 	return optionFunc(func(o *options) {
@@ -53,6 +112,45 @@ func WithPassphrase(passphrase []byte) Option {
 	})
 }
 
+// KeyProvider supplies the passphrase with which the wallet with the given ID is encrypted.
+type KeyProvider func(walletID uuid.UUID) ([]byte, error)
+
+// WithCipher sets the cipher with which data is encrypted with the store's passphrase.  Defaults to CipherAES256GCM.
+// Data is decrypted with the cipher with which it was encrypted, so the cipher can be changed at any time; existing data
+// can be re-encrypted with the new cipher with UpgradeFormat.
+func WithCipher(cipher Cipher) Option {
+	return optionFunc(func(o *options) {
+		o.cipher = cipher
+	})
+}
+
+// WithArgon2id derives the key with which data is encrypted with the store's passphrase using Argon2id with the given
+// number of passes, memory in KiB and degree of parallelism.  This is the default, with 3 passes, 64MiB of memory and 4
+// threads.  The key derivation is recorded with each object, so it can be hardened at any time; existing data can be
+// re-encrypted with the new key derivation with UpgradeFormat.
+func WithArgon2id(time uint32, memory uint32, threads uint8) Option {
+	return optionFunc(func(o *options) {
+		o.kdf = kdfParams{Name: KDFArgon2id, Time: time, Memory: memory, Threads: threads}
+	})
+}
+
+// WithScrypt derives the key with which data is encrypted with the store's passphrase using scrypt with the given CPU and
+// memory cost n, block size r and parallelism p, in place of Argon2id.
+func WithScrypt(n int, r int, p int) Option {
+	return optionFunc(func(o *options) {
+		o.kdf = kdfParams{Name: KDFScrypt, N: n, R: r, P: p}
+	})
+}
+
+// WithKeyProvider encrypts each wallet and its accounts with a passphrase obtained from the provider, rather than with
+// the store's passphrase, so that the exposure of one passphrase does not expose every wallet.  If the provider returns
+// an empty passphrase for a wallet the store's passphrase is used.  A transit key, if set, takes precedence.
+func WithKeyProvider(provider KeyProvider) Option {
+	return optionFunc(func(o *options) {
+		o.keyProvider = provider
+	})
+}
+
 // WithRole sets the role for the store.
 func WithRole(role string) Option {
 	return optionFunc(func(o *options) {
@@ -60,6 +158,14 @@ func WithRole(role string) Option {
 	})
 }
 
+// WithToken sets a Vault token with which the store accesses Vault, in place of logging in with the Kubernetes service
+// account token.  The token is not renewed, so this is intended for development and testing.
+func WithToken(token string) Option {
+	return optionFunc(func(o *options) {
+		o.token = token
+	})
+}
+
 // WithVaultSubPath sets thewallet name for the Store
 func WithVaultSubPath(vaultSubPath string) Option {
 	return optionFunc(func(o *options) {
@@ -67,71 +173,620 @@ func WithVaultSubPath(vaultSubPath string) Option {
 	})
 }
 
+// WithSecondaryStore sets a secondary store to which all writes are mirrored.
+// Reads are always served by the primary store.
+func WithSecondaryStore(secondary wtypes.Store) Option {
+	return optionFunc(func(o *options) {
+		o.secondary = secondary
+	})
+}
+
+// WithSecondaryFailurePolicy sets how failures writing to the secondary store are handled.
+func WithSecondaryFailurePolicy(policy SecondaryFailurePolicy) Option {
+	return optionFunc(func(o *options) {
+		o.secondaryFailurePolicy = policy
+	})
+}
+
+// WithCacheDir sets a local directory in which copies of accounts are cached.
+// Accounts are read from the cache in preference to Vault, and written through to it.
+func WithCacheDir(cacheDir string) Option {
+	return optionFunc(func(o *options) {
+		o.cacheDir = cacheDir
+	})
+}
+
+// WithCache enables an in-memory cache of wallets and accounts.
+// The cache holds at most size entries (0 for no limit), each for at most ttl (0 for no limit).
+func WithCache(size int, ttl time.Duration) Option {
+	return optionFunc(func(o *options) {
+		o.cache = true
+		o.cacheSize = size
+		o.cacheTTL = ttl
+	})
+}
+
+// WithWalletCache enables an in-memory cache of wallets, each held for at most ttl, without caching accounts.  Storing
+// accounts requires their wallet, so this avoids reading the wallet from Vault for every account stored.  This takes
+// precedence over WithCache for wallets.
+func WithWalletCache(ttl time.Duration) Option {
+	return optionFunc(func(o *options) {
+		o.walletCacheTTL = ttl
+	})
+}
+
+// WithKVMount sets the path at which the KV secrets engine is mounted in Vault.  Defaults to "secret".
+// Together with WithVaultSubPath this allows multiple stores to coexist in a single Vault.
+func WithKVMount(mount string) Option {
+	return optionFunc(func(o *options) {
+		o.kvMount = mount
+	})
+}
+
+// WithKVVersion sets the version of the KV secrets engine mounted in Vault; either 1 or 2.
+func WithKVVersion(kvVersion int) Option {
+	return optionFunc(func(o *options) {
+		o.kvVersion = kvVersion
+	})
+}
+
+// WithCheckAndSet enables check-and-set on writes, so that a write fails with a ConflictError if the data has
+// been changed by another writer since this store last read it.  This requires version 2 of the KV secrets engine.
+func WithCheckAndSet(checkAndSet bool) Option {
+	return optionFunc(func(o *options) {
+		o.checkAndSet = checkAndSet
+	})
+}
+
+// WithWalletLocking serializes writes to each wallet across all stores sharing the same Vault, using a lock held in
+// Vault.  A lock held for longer than ttl is considered abandoned, and writers wait up to timeout to obtain it.
+// This requires version 2 of the KV secrets engine.
+func WithWalletLocking(ttl time.Duration, timeout time.Duration) Option {
+	return optionFunc(func(o *options) {
+		o.lockTTL = ttl
+		o.lockTimeout = timeout
+	})
+}
+
+// WithRetryPolicy sets the policy for retrying failed Vault operations.  By default operations are not retried.
+// If Vault responds with a Retry-After header, retries wait for at least the time it requests.
+func WithRetryPolicy(retryPolicy *RetryPolicy) Option {
+	return optionFunc(func(o *options) {
+		o.retryPolicy = retryPolicy
+	})
+}
+
+// WithCircuitBreaker enables a circuit breaker that trips after threshold consecutive transient failures of Vault
+// operations.  Whilst tripped operations fail immediately with an UnavailableError; after cooldown a single operation is
+// allowed through to probe Vault, and if it succeeds the breaker is reset.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return optionFunc(func(o *options) {
+		o.breakerThreshold = threshold
+		o.breakerCooldown = cooldown
+	})
+}
+
+// WithWaitForVault makes New wait for up to timeout for Vault to be reachable and unsealed, so that a store created
+// whilst Vault is starting does not fail.  If Vault is still sealed at the deadline New returns a SealedError.
+func WithWaitForVault(timeout time.Duration) Option {
+	return optionFunc(func(o *options) {
+		o.vaultWait = timeout
+	})
+}
+
+// WithResponseWrapping has Vault response-wrap wallets as they are retrieved, with the given TTL, so that wallets pass
+// through Vault's audit devices only as single-use wrapping tokens.  The store unwraps them immediately.
+func WithResponseWrapping(ttl time.Duration) Option {
+	return optionFunc(func(o *options) {
+		o.responseWrapTTL = ttl
+	})
+}
+
+// WithApplication identifies the application using the store to Vault, by appending the given identifier to the
+// User-Agent header sent with each request.
+func WithApplication(application string) Option {
+	return optionFunc(func(o *options) {
+		o.application = application
+	})
+}
+
+// WithRequestHeader adds a header to each request sent to Vault, for example to annotate requests in Vault's audit
+// logs.  This can be supplied multiple times to add multiple headers.
+func WithRequestHeader(name string, value string) Option {
+	return optionFunc(func(o *options) {
+		if o.headers == nil {
+			o.headers = make(http.Header)
+		}
+		o.headers.Add(name, value)
+	})
+}
+
+// WithOperationTimeout sets the maximum time allowed for each request to Vault.
+// Note that if a retry policy is set an operation can make multiple requests.
+func WithOperationTimeout(timeout time.Duration) Option {
+	return optionFunc(func(o *options) {
+		o.operationTimeout = timeout
+	})
+}
+
+// WithLogger sets the logger for the store.  By default nothing is logged.
+func WithLogger(logger Logger) Option {
+	return optionFunc(func(o *options) {
+		o.logger = logger
+	})
+}
+
+// WithAuditSink adds a sink to which audit events are sent for each operation on wallets and accounts.
+// This can be supplied multiple times to send events to multiple sinks.
+func WithAuditSink(sink AuditSink) Option {
+	return optionFunc(func(o *options) {
+		o.auditSinks = append(o.auditSinks, sink)
+	})
+}
+
+// WithVaultAuditLog writes audit events for each operation on wallets and accounts to Vault, alongside the store.
+func WithVaultAuditLog(vaultAudit bool) Option {
+	return optionFunc(func(o *options) {
+		o.vaultAudit = vaultAudit
+	})
+}
+
+// WithTransitKey encrypts wallets and accounts with the named key in Vault's Transit secrets engine, rather than
+// with the passphrase.  Data is decrypted with the version of the key that encrypted it, so keys can be rotated in Vault.
+func WithTransitKey(key string) Option {
+	return optionFunc(func(o *options) {
+		o.transitKey = key
+	})
+}
+
+// WithTransitMount sets the path at which Vault's Transit secrets engine is mounted.  Defaults to "transit".
+func WithTransitMount(mount string) Option {
+	return optionFunc(func(o *options) {
+		o.transitMount = mount
+	})
+}
+
+// WithChecksums stores a checksum alongside each wallet and account, which is verified when the data is retrieved.
+// The checksum is an HMAC keyed with the passphrase if one is set, and a SHA-256 hash otherwise.  Data encrypted with
+// a transit key is not checksummed, as Transit authenticates it.
+func WithChecksums(checksums bool) Option {
+	return optionFunc(func(o *options) {
+		o.checksums = checksums
+	})
+}
+
+// WithCompression compresses wallets and accounts with gzip before they are stored.
+// Data stored without compression can still be read when this is set, and vice versa.
+func WithCompression(compression bool) Option {
+	return optionFunc(func(o *options) {
+		o.compression = compression
+	})
+}
+
+// WithPayloadValidation checks that wallets and accounts are JSON objects with a name and a UUID matching the ID with
+// which they are stored before they are written, returning a ValidationError if not.
+func WithPayloadValidation(validate bool) Option {
+	return optionFunc(func(o *options) {
+		o.validatePayloads = validate
+	})
+}
+
+// WithObjectTags tags each stored wallet and account with its wallet ID, wallet name and (for accounts) account name,
+// along with the supplied tags, so that Vault policies and tooling can operate on wallet boundaries.  Tags are held in
+// Vault as custom metadata, which requires version 2 of the KV secrets engine.
+func WithObjectTags(tags map[string]string) Option {
+	return optionFunc(func(o *options) {
+		o.tags = make(map[string]string, len(tags))
+		for k, v := range tags {
+			o.tags[k] = v
+		}
+	})
+}
+
+// WithConcurrency sets the maximum number of wallets fetched from Vault at once by RetrieveWallets and related
+// functions.  Defaults to 8; 1 fetches wallets one at a time, in the order in which Vault lists them.
+func WithConcurrency(concurrency int) Option {
+	return optionFunc(func(o *options) {
+		o.concurrency = concurrency
+	})
+}
+
+// WithWatchInterval sets the interval at which Watch polls Vault for changes to accounts.  Defaults to 30 seconds.
+func WithWatchInterval(interval time.Duration) Option {
+	return optionFunc(func(o *options) {
+		o.watchInterval = interval
+	})
+}
+
+// WithChannelBuffer sets the number of items buffered by channels returned by RetrieveWallets, RetrieveAccounts and
+// Watch.  Defaults to 1024.  A size of 0 makes the channels unbuffered, so that data is only fetched from Vault as
+// quickly as it is consumed.
+func WithChannelBuffer(size int) Option {
+	return optionFunc(func(o *options) {
+		o.channelBuffer = size
+	})
+}
+
+// WithAccountOrder sets the order in which accounts are retrieved by RetrieveAccounts and related functions.
+// By default accounts are retrieved in the order in which Vault lists them.
+func WithAccountOrder(order AccountOrder) Option {
+	return optionFunc(func(o *options) {
+		o.accountOrder = order
+	})
+}
+
 // Store is the store for the wallet held encrypted on Amazon S3.
 type Store struct {
-	client       *api.Client
-	jwt          string
-	passphrase   []byte
-	role         string
-	vaultSubPath string
+	client                 *api.Client
+	jwt                    string
+	token                  string
+	replica                *replicaClient
+	auditedHeaders         []string
+	cipher                 Cipher
+	kdf                    kdfParams
+	keys                   *keyCache
+	passphrase             []byte
+	role                   string
+	vaultAddress           string
+	vaultSubPath           string
+	secondary              wtypes.Store
+	secondaryFailurePolicy SecondaryFailurePolicy
+	cacheDir               string
+	cache                  *memCache
+	walletCache            *memCache
+	kvVersion              int
+	checkAndSet            bool
+	versions               map[string]int
+	versionsMu             sync.Mutex
+	lockTTL                time.Duration
+	lockTimeout            time.Duration
+	retryPolicy            *RetryPolicy
+	breaker                *circuitBreaker
+	log                    Logger
+	auditSinks             []AuditSink
+	authPrincipal          atomic.Value
+	authMu                 sync.Mutex
+	authorized             bool
+	tokenExpires           time.Time
+	transitMount           string
+	transitKey             string
+	checksums              bool
+	compression            bool
+	tags                   map[string]string
+	accountOrder           AccountOrder
+	kvMount                string
+	retryAfter             *retryAfter
+	responseWrapTTL        time.Duration
+	concurrency            int
+	watchInterval          time.Duration
+	channelBuffer          int
+	validatePayloads       bool
+	keyProvider            KeyProvider
+	ctx                    context.Context
+	cancel                 context.CancelFunc
+	workers                sync.WaitGroup
+	closeOnce              sync.Once
 }
 
 // New creates a new Vault backed store.
 // This takes the following options:
-//  - region: a string specifying the Amazon S3 region, defaults to "us-east-1", set with WithRegion()
-//  - id: a byte array specifying an identifying key for the store, defaults to nil, set with WithID()
+//   - region: a string specifying the Amazon S3 region, defaults to "us-east-1", set with WithRegion()
+//   - id: a byte array specifying an identifying key for the store, defaults to nil, set with WithID()
+//
 // This expects the access credentials to be in a standard place, e.g. ~/.aws/credentials
 func New(opts ...Option) (wtypes.Store, error) {
 	options := options{
-		vaultAddress: "http://vault.vault:8200",
-		role:         "eth",
-		vaultSubPath: "eth",
+		vaultAddress:  "http://vault.vault:8200",
+		role:          "eth",
+		vaultSubPath:  "eth",
+		kvVersion:     1,
+		logger:        nopLogger{},
+		transitMount:  "transit",
+		kvMount:       "secret",
+		concurrency:   defaultConcurrency,
+		watchInterval: defaultWatchInterval,
+		channelBuffer: defaultChannelBuffer,
+		cipher:        CipherAES256GCM,
+		kdf:           defaultKDF,
 	}
 	for _, o := range opts {
 		o.apply(&options)
 	}
 
-	client, err := api.NewClient(&api.Config{
+	if options.logger == nil {
+		options.logger = nopLogger{}
+	}
+
+	if strings.Trim(options.kvMount, "/") == "" {
+		return nil, errors.New("KV mount must be supplied")
+	}
+	if options.kvVersion != 1 && options.kvVersion != 2 {
+		return nil, errors.New("KV version must be 1 or 2")
+	}
+	if options.checkAndSet && options.kvVersion != 2 {
+		return nil, errors.New("check-and-set requires version 2 of the KV secrets engine")
+	}
+	if options.lockTTL != 0 && options.kvVersion != 2 {
+		return nil, errors.New("wallet locking requires version 2 of the KV secrets engine")
+	}
+	if options.responseWrapTTL < 0 || (options.responseWrapTTL > 0 && options.responseWrapTTL < time.Second) {
+		return nil, errors.New("response wrapping TTL must be at least one second")
+	}
+	if options.concurrency < 1 {
+		return nil, errors.New("concurrency must be at least 1")
+	}
+	if options.watchInterval <= 0 {
+		return nil, errors.New("watch interval must be positive")
+	}
+	if options.channelBuffer < 0 {
+		return nil, errors.New("channel buffer size must not be negative")
+	}
+	if !validCipher(options.cipher) {
+		return nil, errors.Errorf("unsupported cipher %q", options.cipher)
+	}
+	if err := options.kdf.validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid key derivation")
+	}
+	if options.tags != nil && options.kvVersion != 2 {
+		return nil, errors.New("object tags require version 2 of the KV secrets engine")
+	}
+
+	config := &api.Config{
 		Address: options.vaultAddress,
-	})
+	}
+	// Track Vault's requests to back off, so that retries honour them, and fail requests that Vault has rate limited.
+	tracker := &retryAfter{}
+	config.HttpClient = api.DefaultConfig().HttpClient
+	config.HttpClient.Transport = &retryAfterTransport{
+		base:       config.HttpClient.Transport,
+		retryAfter: tracker,
+	}
+	if options.requestIDs {
+		config.HttpClient.Transport = &requestIDTransport{
+			base: config.HttpClient.Transport,
+		}
+	}
+	client, err := api.NewClient(config)
 
 	if err != nil {
 		return nil, err
 	}
 
-	jwt, err := ioutil.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/token")
+	if options.operationTimeout > 0 {
+		client.SetClientTimeout(options.operationTimeout)
+	}
+	client.SetHeaders(requestHeaders(options.application, options.headers))
 
-	if err != nil {
-		return nil, err
+	var replica *replicaClient
+	if options.replicaAddress != "" {
+		replica, err = newReplicaClient(options.replicaAddress, options.operationTimeout, client.Headers(), options.requestIDs)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create replica client")
+		}
 	}
 
-	return &Store{
-		client:       client,
-		jwt:          string(jwt),
-		passphrase:   options.passphrase,
-		role:         options.role,
-		vaultSubPath: options.vaultSubPath,
-	}, nil
+	var jwt []byte
+	if options.token == "" {
+		jwt, err = ioutil.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/token")
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var cache *memCache
+	if options.cache {
+		cache = newMemCache(options.cacheSize, options.cacheTTL)
+	}
+
+	walletCache := cache
+	if options.walletCacheTTL > 0 {
+		walletCache = newMemCache(0, options.walletCacheTTL)
+	}
+
+	var breaker *circuitBreaker
+	if options.breakerThreshold > 0 {
+		breaker = newCircuitBreaker(options.breakerThreshold, options.breakerCooldown)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Store{
+		client:                 client,
+		jwt:                    string(jwt),
+		token:                  options.token,
+		replica:                replica,
+		auditedHeaders:         auditedHeaders(options.headers, options.requestIDs),
+		cipher:                 options.cipher,
+		kdf:                    options.kdf,
+		keys:                   newKeyCache(),
+		passphrase:             options.passphrase,
+		role:                   options.role,
+		vaultAddress:           options.vaultAddress,
+		vaultSubPath:           options.vaultSubPath,
+		secondary:              options.secondary,
+		secondaryFailurePolicy: options.secondaryFailurePolicy,
+		cacheDir:               options.cacheDir,
+		cache:                  cache,
+		walletCache:            walletCache,
+		kvVersion:              options.kvVersion,
+		checkAndSet:            options.checkAndSet,
+		versions:               make(map[string]int),
+		lockTTL:                options.lockTTL,
+		lockTimeout:            options.lockTimeout,
+		retryPolicy:            options.retryPolicy,
+		breaker:                breaker,
+		log:                    options.logger,
+		auditSinks:             options.auditSinks,
+		transitMount:           options.transitMount,
+		transitKey:             options.transitKey,
+		checksums:              options.checksums,
+		compression:            options.compression,
+		tags:                   options.tags,
+		accountOrder:           options.accountOrder,
+		kvMount:                strings.Trim(options.kvMount, "/"),
+		retryAfter:             tracker,
+		responseWrapTTL:        options.responseWrapTTL,
+		concurrency:            options.concurrency,
+		watchInterval:          options.watchInterval,
+		channelBuffer:          options.channelBuffer,
+		validatePayloads:       options.validatePayloads,
+		keyProvider:            options.keyProvider,
+		ctx:                    ctx,
+		cancel:                 cancel,
+	}
+	if options.vaultAudit {
+		s.auditSinks = append(s.auditSinks, &vaultAuditSink{store: s})
+	}
+
+	if options.vaultWait > 0 {
+		if err := s.waitForVault(options.vaultWait); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+
+	return s, nil
 }
 
+// requestHeaders returns the headers to send with each request to Vault.
+func requestHeaders(application string, extra http.Header) http.Header {
+	headers := make(http.Header)
+	for name, values := range extra {
+		for _, value := range values {
+			headers.Add(name, value)
+		}
+	}
+	agent := userAgent
+	if application != "" {
+		agent = fmt.Sprintf("%s %s", userAgent, application)
+	}
+	headers.Set("User-Agent", agent)
+
+	return headers
+}
+
+// Authorize logs in to Vault as AuthorizeContext, without a deadline.
 func (s *Store) Authorize() error {
-	client := s.client
+	return s.AuthorizeContext(context.Background())
+}
+
+// AuthorizeContext logs in to Vault with the Kubernetes service account token, if the store does not already hold a
+// token that is valid.  If the store was given a token with WithToken it is used as-is.  Tokens are replaced once 90% of
+// their lease has passed, or when Vault rejects them.
+// Transient failures to log in are retried with backoff according to the store's retry policy, or DefaultRetryPolicy if
+// it has none, until ctx is done or the store is closed.  Failures are returned as an *AuthError, and the store's
+// operations fail with it rather than calling Vault without a valid token.
+func (s *Store) AuthorizeContext(ctx context.Context) error {
+	s.authMu.Lock()
+	defer s.authMu.Unlock()
+
+	if s.tokenValid() {
+		return nil
+	}
+
+	if s.token != "" {
+		s.client.SetToken(s.token)
+		s.authorized = true
+		return nil
+	}
+
+	policy := s.retryPolicy
+	if policy == nil {
+		policy = DefaultRetryPolicy
+	}
+
+	var resp *api.Secret
+	for attempt := 1; ; attempt++ {
+		var err error
+		resp, err = s.login(ctx, policy)
+		if err == nil {
+			break
+		}
+		authErr := &AuthError{Reason: authFailure(err), Attempts: attempt, Err: err}
+		if attempt >= policy.MaxAttempts || !authErr.IsTransient() {
+			return authErr
+		}
+		backoff := s.retryBackoff(policy, attempt)
+		s.log.Warn("Retrying login", "attempt", attempt, "backoff", backoff, "error", err)
+		if err := s.sleep(ctx, backoff); err != nil {
+			return &AuthError{Reason: AuthFailureCancelled, Attempts: attempt, Err: err}
+		}
+	}
+
+	s.client.SetToken(resp.Auth.ClientToken)
+	s.authorized = true
+	s.tokenExpires = time.Time{}
+	if resp.Auth.LeaseDuration > 0 {
+		lease := time.Duration(resp.Auth.LeaseDuration) * time.Second
+		s.tokenExpires = time.Now().Add(lease * 9 / 10)
+	}
+
+	// Kubernetes auth provides the service account as metadata; use it to identify the principal in audit events.
+	if resp.Auth.Metadata["service_account_name"] != "" {
+		s.authPrincipal.Store(fmt.Sprintf("%s/%s", resp.Auth.Metadata["service_account_namespace"], resp.Auth.Metadata["service_account_name"]))
+	}
+
+	return nil
+}
 
+// login makes a single attempt to log in to Vault with the Kubernetes service account token.  The attempt is abandoned
+// if ctx is done.
+func (s *Store) login(ctx context.Context, policy *RetryPolicy) (*api.Secret, error) {
 	config := map[string]interface{}{
 		"role": s.role,
 		// Have to convert this into a string to compact the jwt
 		"jwt": s.jwt,
 	}
 
-	resp, err := client.Logical().Write("auth/kubernetes/login", config)
-
-	if err != nil {
+	var resp *api.Secret
+	err := s.callVaultWithPolicy(ctx, "login", "auth/kubernetes/login", &RetryPolicy{MaxAttempts: 1, Retryable: policy.Retryable}, func() error {
+		request := s.client.NewRequest(http.MethodPut, "/v1/auth/kubernetes/login")
+		if err := request.SetJSONBody(config); err != nil {
+			return err
+		}
+		response, err := s.client.RawRequestWithContext(ctx, request)
+		if response != nil {
+			defer response.Body.Close()
+		}
+		if err != nil {
+			return err
+		}
+		resp, err = api.ParseSecret(response.Body)
 		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil || resp.Auth == nil {
+		return nil, errors.New("no authentication information returned")
 	}
 
-	client.SetToken(resp.Auth.ClientToken)
+	return resp, nil
+}
 
-	return nil
+// tokenValid returns true if the store holds a token that has not reached its expiry.  authMu must be held.
+func (s *Store) tokenValid() bool {
+	if !s.authorized {
+		return false
+	}
+	return s.tokenExpires.IsZero() || time.Now().Before(s.tokenExpires)
+}
+
+// reauthorize discards the store's token and logs in again.
+func (s *Store) reauthorize() error {
+	s.authMu.Lock()
+	s.authorized = false
+	s.authMu.Unlock()
+
+	return s.Authorize()
+}
+
+// principal returns the identity with which the store accesses Vault.
+func (s *Store) principal() string {
+	if principal, ok := s.authPrincipal.Load().(string); ok {
+		return principal
+	}
+	return s.role
 }
 
 // Name returns the name of this store.
@@ -143,3 +798,15 @@ func (s *Store) Name() string {
 func (s *Store) Location() string {
 	return s.vaultSubPath
 }
+
+// Address returns the address of the Vault server in which this store is held.
+func (s *Store) Address() string {
+	return s.vaultAddress
+}
+
+// URL returns the canonical location of this store: the address of the Vault server, the path at which the KV secrets
+// engine is mounted and the path within it under which wallets are stored, for example
+// "https://vault.example.com:8200/secret/eth".  It is included in errors returned from calls to Vault.
+func (s *Store) URL() string {
+	return fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(s.Address(), "/"), s.kvMount, s.vaultSubPath)
+}
@@ -0,0 +1,131 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestHeaders(t *testing.T) {
+	headers := requestHeaders("", nil)
+	assert.Equal(t, "go-eth2-wallet-store-vault", headers.Get("User-Agent"))
+
+	extra := make(http.Header)
+	extra.Add("X-Service", "signer")
+	extra.Add("X-Team", "a")
+	extra.Add("X-Team", "b")
+	extra.Set("User-Agent", "ignored")
+	headers = requestHeaders("signer/1.2.0", extra)
+	assert.Equal(t, "go-eth2-wallet-store-vault signer/1.2.0", headers.Get("User-Agent"))
+	assert.Equal(t, "signer", headers.Get("X-Service"))
+	assert.Equal(t, []string{"a", "b"}, headers["X-Team"])
+	assert.Equal(t, "ignored", extra.Get("User-Agent"))
+}
+
+func TestURL(t *testing.T) {
+	store := &Store{
+		vaultAddress: "https://vault.example.com:8200/",
+		kvMount:      "secret",
+		vaultSubPath: "eth",
+		log:          nopLogger{},
+	}
+	assert.Equal(t, "https://vault.example.com:8200/", store.Address())
+	assert.Equal(t, "https://vault.example.com:8200/secret/eth", store.URL())
+
+	err := store.callVault("read", "eth/test", func() error {
+		return &api.ResponseError{StatusCode: 404}
+	})
+	prefix := "vault read eth/test (store https://vault.example.com:8200/secret/eth): "
+	assert.Equal(t, prefix, err.Error()[:len(prefix)])
+	var responseErr *api.ResponseError
+	assert.True(t, errors.As(err, &responseErr))
+}
+
+func TestChannelBuffer(t *testing.T) {
+	_, err := New(WithToken("test"), WithChannelBuffer(-1))
+	assert.NotNil(t, err)
+
+	store, err := New(WithToken("test"))
+	require.Nil(t, err)
+	assert.Equal(t, defaultChannelBuffer, store.(*Store).channelBuffer)
+
+	store, err = New(WithToken("test"), WithChannelBuffer(0))
+	require.Nil(t, err)
+	assert.Equal(t, 0, store.(*Store).channelBuffer)
+}
+
+func TestCallerIdentity(t *testing.T) {
+	opts := options{}
+	WithCallerIdentity("signer", "signer-0").apply(&opts)
+	WithRequestHeader("x-team", "staking").apply(&opts)
+	assert.Equal(t, "signer", opts.headers.Get("X-Caller-Service"))
+	assert.Equal(t, "signer-0", opts.headers.Get("X-Caller-Instance"))
+	assert.True(t, opts.requestIDs)
+	assert.Equal(t, []string{"X-Caller-Instance", "X-Caller-Service", "X-Request-Id", "X-Team"}, auditedHeaders(opts.headers, opts.requestIDs))
+
+	// Instance defaults to the host name.
+	opts = options{}
+	WithCallerIdentity("signer", "").apply(&opts)
+	hostname, _ := os.Hostname()
+	assert.Equal(t, hostname, opts.headers.Get("X-Caller-Instance"))
+}
+
+func TestRequestIDTransport(t *testing.T) {
+	ids := make([]string, 0)
+	transport := &requestIDTransport{
+		base: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			ids = append(ids, req.Header.Get("X-Request-Id"))
+			return &http.Response{StatusCode: http.StatusOK}, nil
+		}),
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "http://vault:8200/v1/sys/health", nil)
+	require.Nil(t, err)
+	_, err = transport.RoundTrip(req)
+	require.Nil(t, err)
+	_, err = transport.RoundTrip(req)
+	require.Nil(t, err)
+
+	require.Len(t, ids, 2)
+	assert.NotEqual(t, "", ids[0])
+	assert.NotEqual(t, ids[0], ids[1])
+	// The original request is untouched.
+	assert.Equal(t, "", req.Header.Get("X-Request-Id"))
+}
+
+// roundTripperFunc is an http.RoundTripper implemented by a function.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestAuthorizeToken(t *testing.T) {
+	store, err := New(WithToken("test"))
+	require.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// A supplied token is used without logging in, so a done context does not matter.
+	require.Nil(t, store.(*Store).AuthorizeContext(ctx))
+	assert.Equal(t, "test", store.(*Store).client.Token())
+}
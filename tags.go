@@ -0,0 +1,134 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"encoding/json"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// Tags applied to stored objects when tagging is enabled.
+const (
+	tagWalletID    = "wallet_id"
+	tagWalletName  = "wallet_name"
+	tagAccountName = "account_name"
+)
+
+// tagObject sets the custom metadata of the object held at the given key to the store's tags along with the supplied
+// tags, if tagging is enabled.
+func (s *Store) tagObject(key string, tags map[string]string) error {
+	if s.tags == nil {
+		return nil
+	}
+
+	customMetadata := make(map[string]string, len(s.tags)+len(tags))
+	for k, v := range s.tags {
+		customMetadata[k] = v
+	}
+	for k, v := range tags {
+		customMetadata[k] = v
+	}
+
+	err := s.callVault("write metadata", key, func() error {
+		_, err := s.client.Logical().Write(s.kvPath("metadata", key), map[string]interface{}{
+			"custom_metadata": customMetadata,
+		})
+		return err
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to tag")
+	}
+
+	return nil
+}
+
+// retagObject updates the supplied tags of the object held at the given key, retaining its other tags, if tagging is
+// enabled.
+func (s *Store) retagObject(key string, tags map[string]string) error {
+	if s.tags == nil {
+		return nil
+	}
+
+	existing, err := s.objectTags(key)
+	if err != nil {
+		return err
+	}
+	for k, v := range tags {
+		existing[k] = v
+	}
+
+	return s.tagObject(key, existing)
+}
+
+// objectTags returns the custom metadata of the object held at the given key.
+func (s *Store) objectTags(key string) (map[string]string, error) {
+	var secret *api.Secret
+	err := s.callVault("read metadata", key, func() error {
+		var err error
+		secret, err = s.client.Logical().Read(s.kvPath("metadata", key))
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read tags")
+	}
+
+	tags := make(map[string]string)
+	if secret == nil || secret.Data == nil {
+		return tags, nil
+	}
+	if customMetadata, ok := secret.Data["custom_metadata"].(map[string]interface{}); ok {
+		for k, v := range customMetadata {
+			if value, ok := v.(string); ok {
+				tags[k] = value
+			}
+		}
+	}
+
+	return tags, nil
+}
+
+// nameOf returns the name held in wallet or account data, or an empty string if it has none.
+func nameOf(data []byte) string {
+	info := &struct {
+		Name string `json:"name"`
+	}{}
+	if err := json.Unmarshal(data, info); err != nil {
+		return ""
+	}
+	return info.Name
+}
+
+// idOf returns the UUID held in wallet or account data, or an empty string if it has none.
+func idOf(data []byte) string {
+	info := &struct {
+		UUID string `json:"uuid"`
+	}{}
+	if err := json.Unmarshal(data, info); err != nil {
+		return ""
+	}
+	return info.UUID
+}
+
+// typeOf returns the type held in wallet data, or an empty string if it has none.
+func typeOf(data []byte) string {
+	info := &struct {
+		Type string `json:"type"`
+	}{}
+	if err := json.Unmarshal(data, info); err != nil {
+		return ""
+	}
+	return info.Type
+}
@@ -0,0 +1,637 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"encoding/json"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	wtypes "github.com/wealdtech/go-eth2-wallet-types/v2"
+)
+
+// Operations within a transaction.
+const (
+	transactionStoreWallet   = "store wallet"
+	transactionStoreAccount  = "store account"
+	transactionDeleteAccount = "delete account"
+	transactionStoreIndex    = "store index"
+)
+
+// States of a transaction record.
+const (
+	transactionPending   = "pending"
+	transactionCommitted = "committed"
+)
+
+// Transaction batches writes to wallets and accounts so that either all of them are applied or none are.
+// A transaction is created with NewTransaction, and its writes are applied by Commit.  The accounts indexes of wallets
+// whose accounts are stored or deleted are updated as part of the transaction.  Once a transaction is committed readers
+// see all of its writes, even whilst they are being applied.
+type Transaction struct {
+	store     *Store
+	ops       []*transactionOp
+	committed bool
+}
+
+// transactionOp is a single operation within a transaction.  Data is held in the staging key until it is applied.
+type transactionOp struct {
+	Op       string            `json:"op"`
+	WalletID string            `json:"wallet_id"`
+	Key      string            `json:"key"`
+	Tags     map[string]string `json:"tags,omitempty"`
+	data     []byte
+	name     string
+}
+
+// transactionRecord is the record of a transaction held in Vault whilst it is being committed.  Once its state is
+// committed the transaction will be applied in full, if necessary by RecoverTransactions.
+type transactionRecord struct {
+	State   string           `json:"state"`
+	Created int64            `json:"created"`
+	Ops     []*transactionOp `json:"ops"`
+}
+
+// NewTransaction creates a transaction for the store.
+func (s *Store) NewTransaction() *Transaction {
+	return &Transaction{
+		store: s,
+		ops:   make([]*transactionOp, 0),
+	}
+}
+
+// StoreWallet adds the storing of wallet-level data to the transaction.
+func (t *Transaction) StoreWallet(id uuid.UUID, name string, data []byte) error {
+	if t.store.validatePayloads {
+		if err := validatePayload(data, id, name); err != nil {
+			return err
+		}
+	}
+	t.ops = append(t.ops, &transactionOp{
+		Op:       transactionStoreWallet,
+		WalletID: id.String(),
+		Key:      t.store.walletHeaderPath(id.String()),
+		data:     data,
+		name:     name,
+	})
+	return nil
+}
+
+// StoreAccount adds the storing of an account to the transaction.  The account's wallet must exist, or be stored by
+// the same transaction.
+func (t *Transaction) StoreAccount(walletID uuid.UUID, accountID uuid.UUID, data []byte) error {
+	if t.store.validatePayloads {
+		if err := validatePayload(data, accountID, ""); err != nil {
+			return err
+		}
+	}
+	t.ops = append(t.ops, &transactionOp{
+		Op:       transactionStoreAccount,
+		WalletID: walletID.String(),
+		Key:      t.store.accountPath(walletID.String(), accountID.String()),
+		data:     data,
+	})
+	return nil
+}
+
+// DeleteAccount adds the deletion of an account to the transaction.  With version 2 of the KV secrets engine the
+// deletion is recoverable with RestoreAccount.
+func (t *Transaction) DeleteAccount(walletID uuid.UUID, accountID uuid.UUID) error {
+	t.ops = append(t.ops, &transactionOp{
+		Op:       transactionDeleteAccount,
+		WalletID: walletID.String(),
+		Key:      t.store.accountPath(walletID.String(), accountID.String()),
+	})
+	return nil
+}
+
+// Commit applies the transaction.  The data for each write is first staged in Vault; once all of it has been staged
+// the transaction is marked as committed and applied.  From the point at which it is marked as committed readers are
+// served its staged data until it has been applied.  If commit fails before the transaction is marked as committed
+// nothing is applied, and Commit can be called again; if it fails afterwards the transaction is completed by
+// RecoverTransactions.
+func (t *Transaction) Commit() (err error) {
+	s := t.store
+	transactionID := uuid.New().String()
+	defer func() { s.audit("commit transaction", "", "", "", err) }()
+
+	if t.committed {
+		return errors.New("transaction already committed")
+	}
+	if len(t.ops) == 0 {
+		t.committed = true
+		return nil
+	}
+
+	if err := s.Authorize(); err != nil {
+		return err
+	}
+
+	unlock, err := s.lockTransactionWallets(t.ops)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := t.checkWallets(); err != nil {
+		return err
+	}
+	indexOps, err := t.indexOps()
+	if err != nil {
+		return err
+	}
+	ops := append(t.ops[:len(t.ops):len(t.ops)], indexOps...)
+
+	record := &transactionRecord{
+		State:   transactionPending,
+		Created: time.Now().Unix(),
+		Ops:     ops,
+	}
+	if err := s.writeTransactionRecord(transactionID, record); err != nil {
+		return err
+	}
+	// From here the transaction may be applied, so it cannot be committed again.
+	t.committed = true
+	for i, op := range ops {
+		if err := t.stage(transactionID, i, op); err != nil {
+			s.removeTransaction(transactionID, record)
+			return errors.Wrapf(err, "failed to stage %s", op.Key)
+		}
+	}
+	record.State = transactionCommitted
+	if err := s.writeTransactionRecord(transactionID, record); err != nil {
+		s.removeTransaction(transactionID, record)
+		return err
+	}
+	// Readers are served the staged data from here on, so any cached copies are stale.
+	for _, op := range ops {
+		if walletID, err := uuid.Parse(op.WalletID); err == nil {
+			s.invalidate(walletID, op.Key)
+		}
+	}
+
+	if err := s.applyTransaction(transactionID, record); err != nil {
+		return errors.Wrap(err, "transaction committed but not fully applied; complete with RecoverTransactions")
+	}
+
+	for _, op := range ops {
+		if err := t.mirror(op); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// lockTransactionWallets obtains the locks for all wallets written by a transaction's operations, in a fixed order to
+// avoid deadlock with other transactions.  It returns a function that releases them.
+func (s *Store) lockTransactionWallets(ops []*transactionOp) (func(), error) {
+	walletIDs := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, op := range ops {
+		if !seen[op.WalletID] {
+			seen[op.WalletID] = true
+			walletIDs = append(walletIDs, op.WalletID)
+		}
+	}
+	sort.Strings(walletIDs)
+
+	unlocks := make([]func(), 0, len(walletIDs))
+	unlockAll := func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+	for _, walletID := range walletIDs {
+		id, err := uuid.Parse(walletID)
+		if err != nil {
+			unlockAll()
+			return nil, errors.Wrap(err, "invalid wallet ID in transaction")
+		}
+		unlock, err := s.lockWallet(id)
+		if err != nil {
+			unlockAll()
+			return nil, err
+		}
+		unlocks = append(unlocks, unlock)
+	}
+
+	return unlockAll, nil
+}
+
+// checkWallets ensures that the wallets of all accounts stored by the transaction exist.
+func (t *Transaction) checkWallets() error {
+	stored := make(map[string]bool)
+	for _, op := range t.ops {
+		if op.Op == transactionStoreWallet {
+			stored[op.WalletID] = true
+		}
+	}
+	for _, op := range t.ops {
+		if op.Op != transactionStoreAccount || stored[op.WalletID] {
+			continue
+		}
+		data, err := t.store.readObject(t.store.walletHeaderPath(op.WalletID))
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			return errors.Errorf("unknown wallet %s", op.WalletID)
+		}
+		stored[op.WalletID] = true
+	}
+	return nil
+}
+
+// indexOps returns the operations that update the accounts index of each wallet whose accounts are stored or deleted
+// by the transaction.
+func (t *Transaction) indexOps() ([]*transactionOp, error) {
+	s := t.store
+	indexes := make(map[string][]*indexEntry)
+	walletIDs := make([]string, 0)
+	for _, op := range t.ops {
+		if op.Op != transactionStoreAccount && op.Op != transactionDeleteAccount {
+			continue
+		}
+		index, exists := indexes[op.WalletID]
+		if !exists {
+			var err error
+			if index, err = s.accountsIndex(uuid.MustParse(op.WalletID)); err != nil {
+				return nil, errors.Wrapf(err, "failed to read accounts index for wallet %s", op.WalletID)
+			}
+			walletIDs = append(walletIDs, op.WalletID)
+		}
+		indexes[op.WalletID] = updateIndex(index, op)
+	}
+
+	ops := make([]*transactionOp, 0, len(walletIDs))
+	for _, walletID := range walletIDs {
+		data, err := json.Marshal(indexes[walletID])
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, &transactionOp{
+			Op:       transactionStoreIndex,
+			WalletID: walletID,
+			Key:      s.walletIndexPath(walletID),
+			data:     data,
+		})
+	}
+	return ops, nil
+}
+
+// updateIndex returns an accounts index updated for an operation that stores or deletes an account.  A stored account
+// that is already in the index keeps its position.
+func updateIndex(index []*indexEntry, op *transactionOp) []*indexEntry {
+	accountID := path.Base(op.Key)
+	updated := make([]*indexEntry, 0, len(index)+1)
+	found := false
+	for _, entry := range index {
+		if entry.UUID != accountID {
+			updated = append(updated, entry)
+			continue
+		}
+		found = true
+		if op.Op == transactionStoreAccount {
+			updated = append(updated, &indexEntry{UUID: accountID, Name: nameOf(op.data)})
+		}
+	}
+	if !found && op.Op == transactionStoreAccount {
+		updated = append(updated, &indexEntry{UUID: accountID, Name: nameOf(op.data)})
+	}
+	return updated
+}
+
+// stage encrypts the data for an operation and writes it to the operation's staging key.
+func (t *Transaction) stage(transactionID string, i int, op *transactionOp) error {
+	s := t.store
+	switch op.Op {
+	case transactionDeleteAccount:
+		return nil
+	case transactionStoreIndex:
+		// Accounts indexes are held unencrypted, wrapped in an object as Vault will not store an array.
+		var entries []interface{}
+		if err := json.Unmarshal(op.data, &entries); err != nil {
+			return err
+		}
+		data, err := json.Marshal(map[string]interface{}{"data": entries})
+		if err != nil {
+			return err
+		}
+		return s.kvWrite(s.transactionStagingPath(transactionID, i), data)
+	}
+
+	walletID := uuid.MustParse(op.WalletID)
+	if s.tags != nil {
+		op.Tags = map[string]string{tagWalletID: op.WalletID}
+		if op.Op == transactionStoreWallet {
+			op.Tags[tagWalletName] = op.name
+		} else {
+			op.Tags[tagAccountName] = nameOf(op.data)
+			op.Tags[tagWalletName] = t.walletName(op.WalletID)
+		}
+	}
+
+	encryptedData, err := s.encryptIfRequired(walletID, op.data)
+	if err != nil {
+		return err
+	}
+	return s.kvWrite(s.transactionStagingPath(transactionID, i), encryptedData)
+}
+
+// walletName returns the name of a wallet written by this transaction, or if not the name held in Vault.
+func (t *Transaction) walletName(walletID string) string {
+	for _, op := range t.ops {
+		if op.Op == transactionStoreWallet && op.WalletID == walletID {
+			return op.name
+		}
+	}
+	if name, err := t.store.walletName(walletID, nil); err == nil && name != nil {
+		return *name
+	}
+	return ""
+}
+
+// mirror writes an operation to the secondary store, if there is one.
+func (t *Transaction) mirror(op *transactionOp) error {
+	walletID := uuid.MustParse(op.WalletID)
+	switch op.Op {
+	case transactionStoreWallet:
+		return t.store.mirror(func(secondary wtypes.Store) error {
+			return secondary.StoreWallet(walletID, op.name, op.data)
+		})
+	case transactionStoreAccount:
+		accountID, err := uuid.Parse(path.Base(op.Key))
+		if err != nil {
+			return err
+		}
+		return t.store.mirror(func(secondary wtypes.Store) error {
+			return secondary.StoreAccount(walletID, accountID, op.data)
+		})
+	case transactionStoreIndex:
+		return t.store.mirror(func(secondary wtypes.Store) error {
+			return secondary.StoreAccountsIndex(walletID, op.data)
+		})
+	}
+	return nil
+}
+
+// applyTransaction applies the operations of a committed transaction and removes its record.  Applying a
+// transaction more than once has the same effect as applying it once.
+func (s *Store) applyTransaction(transactionID string, record *transactionRecord) error {
+	for i, op := range record.Ops {
+		walletID, err := uuid.Parse(op.WalletID)
+		if err != nil {
+			return errors.Wrap(err, "invalid wallet ID in transaction")
+		}
+		switch op.Op {
+		case transactionStoreWallet, transactionStoreAccount, transactionStoreIndex:
+			data, err := s.readObject(s.transactionStagingPath(transactionID, i))
+			if err != nil {
+				return err
+			}
+			if data == nil {
+				// Already applied and removed.
+				continue
+			}
+			if err := s.kvWrite(op.Key, data); err != nil {
+				return errors.Wrapf(err, "failed to write %s", op.Key)
+			}
+			if op.Tags != nil {
+				if err := s.tagObject(op.Key, op.Tags); err != nil {
+					return err
+				}
+			}
+		case transactionDeleteAccount:
+			if err := s.kvDelete(op.Key); err != nil {
+				return errors.Wrapf(err, "failed to delete %s", op.Key)
+			}
+		default:
+			return errors.Errorf("unknown operation %q in transaction", op.Op)
+		}
+		s.invalidate(walletID, op.Key)
+	}
+
+	s.removeTransaction(transactionID, record)
+	return nil
+}
+
+// RecoverTransactions completes transactions that were committed but not fully applied, for example because the
+// process committing them stopped, and removes the staged data of transactions that were started more than
+// gracePeriod ago but never committed.  It should be called when a service starts, and periodically thereafter.
+func (s *Store) RecoverTransactions(gracePeriod time.Duration) (err error) {
+	defer func() { s.audit("recover transactions", "", "", "", err) }()
+
+	if err := s.Authorize(); err != nil {
+		return err
+	}
+
+	transactions, err := s.kvList(s.transactionsPath())
+	if err != nil {
+		return errors.Wrap(err, "failed to list transactions")
+	}
+	cutoff := time.Now().Add(-gracePeriod).Unix()
+	for _, transaction := range transactions {
+		transactionID := strings.TrimSuffix(transaction, "/")
+		record, err := s.readTransactionRecord(transactionID)
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			continue
+		}
+		switch {
+		case record.State == transactionCommitted:
+			unlock, err := s.lockTransactionWallets(record.Ops)
+			if err != nil {
+				return err
+			}
+			err = s.applyTransaction(transactionID, record)
+			unlock()
+			if err != nil {
+				return errors.Wrapf(err, "failed to complete transaction %s", transactionID)
+			}
+		case record.Created < cutoff:
+			s.removeTransaction(transactionID, record)
+		}
+	}
+
+	return nil
+}
+
+// committedWrite is a write by a transaction that has been committed but not yet fully applied.  Readers are served
+// its staged data in place of the data held at its key.
+type committedWrite struct {
+	transactionID string
+	index         int
+	op            *transactionOp
+}
+
+// committedWrites returns the writes of transactions that have been committed but not yet fully applied, keyed by the
+// key that each writes.  Where more than one such transaction writes a key the most recently created is used.
+func (s *Store) committedWrites() (map[string]*committedWrite, error) {
+	transactions, err := s.kvList(s.transactionsPath())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list transactions")
+	}
+
+	writes := make(map[string]*committedWrite)
+	created := make(map[string]int64)
+	for _, transaction := range transactions {
+		transactionID := strings.TrimSuffix(transaction, "/")
+		record, err := s.readTransactionRecord(transactionID)
+		if err != nil {
+			return nil, err
+		}
+		if record == nil || record.State != transactionCommitted {
+			continue
+		}
+		for i, op := range record.Ops {
+			if previous, exists := created[op.Key]; exists && previous > record.Created {
+				continue
+			}
+			created[op.Key] = record.Created
+			writes[op.Key] = &committedWrite{
+				transactionID: transactionID,
+				index:         i,
+				op:            op,
+			}
+		}
+	}
+
+	return writes, nil
+}
+
+// readCommitted reads the data held at a key with read, unless a committed transaction writes the key, in which case
+// the transaction's staged data is returned, or nil if the transaction deletes it.  If writes is nil the committed
+// writes are obtained from Vault.
+func (s *Store) readCommitted(key string, writes map[string]*committedWrite, read func(key string) (map[string]interface{}, error)) (map[string]interface{}, error) {
+	if writes == nil {
+		var err error
+		if writes, err = s.committedWrites(); err != nil {
+			return nil, err
+		}
+	}
+	write, exists := writes[key]
+	if !exists {
+		return read(key)
+	}
+	if write.op.Op == transactionDeleteAccount {
+		return nil, nil
+	}
+
+	data, err := s.kvRead(s.transactionStagingPath(write.transactionID, write.index))
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		// The transaction has been applied and removed since its record was read.
+		return read(key)
+	}
+	return data, nil
+}
+
+// committedAccountKeys returns keys with the IDs of accounts in a wallet stored by committed transactions added and
+// those deleted by committed transactions removed, so that listings reflect transactions not yet applied.
+func committedAccountKeys(keys []string, walletID string, writes map[string]*committedWrite) []string {
+	listed := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		listed[key] = true
+	}
+	deleted := make(map[string]bool)
+	added := make([]string, 0)
+	for key, write := range writes {
+		if write.op.WalletID != walletID {
+			continue
+		}
+		accountID := path.Base(key)
+		switch write.op.Op {
+		case transactionStoreAccount:
+			if !listed[accountID] {
+				added = append(added, accountID)
+			}
+		case transactionDeleteAccount:
+			deleted[accountID] = true
+		}
+	}
+	sort.Strings(added)
+
+	result := make([]string, 0, len(keys)+len(added))
+	for _, key := range keys {
+		if !deleted[key] {
+			result = append(result, key)
+		}
+	}
+	return append(result, added...)
+}
+
+// committedWalletKeys returns wallet keys, as listed, with the IDs of wallets stored by committed transactions added.
+func committedWalletKeys(keys []string, writes map[string]*committedWrite) []string {
+	listed := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		listed[strings.TrimSuffix(key, "/")] = true
+	}
+	added := make([]string, 0)
+	for _, write := range writes {
+		if write.op.Op == transactionStoreWallet && !listed[write.op.WalletID] {
+			listed[write.op.WalletID] = true
+			added = append(added, write.op.WalletID+"/")
+		}
+	}
+	sort.Strings(added)
+	return append(keys, added...)
+}
+
+func (s *Store) writeTransactionRecord(transactionID string, record *transactionRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := s.kvWrite(s.transactionPath(transactionID), data); err != nil {
+		return errors.Wrap(err, "failed to write transaction record")
+	}
+	return nil
+}
+
+func (s *Store) readTransactionRecord(transactionID string) (*transactionRecord, error) {
+	data, err := s.readObject(s.transactionPath(transactionID))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read transaction %s", transactionID)
+	}
+	if data == nil {
+		return nil, nil
+	}
+	record := &transactionRecord{}
+	if err := json.Unmarshal(data, record); err != nil {
+		return nil, errors.Wrapf(err, "invalid transaction %s", transactionID)
+	}
+	return record, nil
+}
+
+// removeTransaction removes the staged data and record of a transaction.  Failures are logged, as they leave nothing
+// that affects the store's wallets and accounts.
+func (s *Store) removeTransaction(transactionID string, record *transactionRecord) {
+	for i := range record.Ops {
+		if err := s.kvRemove(s.transactionStagingPath(transactionID, i)); err != nil {
+			s.log.Warn("Failed to remove staged transaction data", "transaction", transactionID, "error", err)
+		}
+	}
+	if err := s.kvRemove(s.transactionPath(transactionID)); err != nil {
+		s.log.Warn("Failed to remove transaction record", "transaction", transactionID, "error", err)
+	}
+}
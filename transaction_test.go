@@ -0,0 +1,167 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransactionRecord(t *testing.T) {
+	store := &Store{vaultSubPath: "eth"}
+	walletID := uuid.New()
+	accountID := uuid.New()
+
+	txn := store.NewTransaction()
+	require.Nil(t, txn.StoreWallet(walletID, "secret wallet name", []byte(`{"uuid":"`+walletID.String()+`","name":"secret wallet name"}`)))
+	require.Nil(t, txn.StoreAccount(walletID, accountID, []byte(`{"uuid":"`+accountID.String()+`","name":"secret account name"}`)))
+	require.Nil(t, txn.DeleteAccount(walletID, uuid.New()))
+
+	// The record held in Vault must not contain the data being written.
+	data, err := json.Marshal(&transactionRecord{
+		State: transactionPending,
+		Ops:   txn.ops,
+	})
+	require.Nil(t, err)
+	assert.False(t, strings.Contains(string(data), "secret"))
+
+	record := &transactionRecord{}
+	require.Nil(t, json.Unmarshal(data, record))
+	require.Len(t, record.Ops, 3)
+	assert.Equal(t, transactionStoreWallet, record.Ops[0].Op)
+	assert.Equal(t, store.walletHeaderPath(walletID.String()), record.Ops[0].Key)
+	assert.Equal(t, transactionStoreAccount, record.Ops[1].Op)
+	assert.Equal(t, store.accountPath(walletID.String(), accountID.String()), record.Ops[1].Key)
+	assert.Equal(t, transactionDeleteAccount, record.Ops[2].Op)
+}
+
+func TestTransactionValidation(t *testing.T) {
+	store := &Store{validatePayloads: true}
+	walletID := uuid.New()
+
+	txn := store.NewTransaction()
+	assert.NotNil(t, txn.StoreWallet(walletID, "wallet", []byte(`{"uuid":"`+uuid.New().String()+`","name":"wallet"}`)))
+	assert.NotNil(t, txn.StoreAccount(walletID, uuid.New(), []byte("garbage")))
+	assert.Len(t, txn.ops, 0)
+}
+
+func TestTransactionUpdateIndex(t *testing.T) {
+	walletID := uuid.New().String()
+	account1 := uuid.New().String()
+	account2 := uuid.New().String()
+	account3 := uuid.New().String()
+	index := []*indexEntry{
+		{UUID: account1, Name: "Account 1"},
+		{UUID: account2, Name: "Account 2"},
+	}
+	store := &Store{vaultSubPath: "eth"}
+
+	tests := []struct {
+		name     string
+		op       *transactionOp
+		expected []*indexEntry
+	}{
+		{
+			name: "Rename",
+			op: &transactionOp{
+				Op:   transactionStoreAccount,
+				Key:  store.accountPath(walletID, account1),
+				data: []byte(`{"uuid":"` + account1 + `","name":"Renamed"}`),
+			},
+			expected: []*indexEntry{
+				{UUID: account1, Name: "Renamed"},
+				{UUID: account2, Name: "Account 2"},
+			},
+		},
+		{
+			name: "New",
+			op: &transactionOp{
+				Op:   transactionStoreAccount,
+				Key:  store.accountPath(walletID, account3),
+				data: []byte(`{"uuid":"` + account3 + `","name":"Account 3"}`),
+			},
+			expected: []*indexEntry{
+				{UUID: account1, Name: "Account 1"},
+				{UUID: account2, Name: "Account 2"},
+				{UUID: account3, Name: "Account 3"},
+			},
+		},
+		{
+			name: "Delete",
+			op: &transactionOp{
+				Op:  transactionDeleteAccount,
+				Key: store.accountPath(walletID, account1),
+			},
+			expected: []*indexEntry{
+				{UUID: account2, Name: "Account 2"},
+			},
+		},
+		{
+			name: "DeleteUnknown",
+			op: &transactionOp{
+				Op:  transactionDeleteAccount,
+				Key: store.accountPath(walletID, account3),
+			},
+			expected: index,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, updateIndex(index, test.op))
+		})
+	}
+	// The original index must be left untouched.
+	assert.Equal(t, "Account 1", index[0].Name)
+}
+
+func TestTransactionCommittedKeys(t *testing.T) {
+	store := &Store{vaultSubPath: "eth"}
+	walletID := uuid.New().String()
+	otherWalletID := uuid.New().String()
+	listed := uuid.New().String()
+	stored := uuid.New().String()
+	deleted := uuid.New().String()
+	writes := map[string]*committedWrite{
+		store.accountPath(walletID, stored): {
+			op: &transactionOp{Op: transactionStoreAccount, WalletID: walletID, Key: store.accountPath(walletID, stored)},
+		},
+		store.accountPath(walletID, listed): {
+			op: &transactionOp{Op: transactionStoreAccount, WalletID: walletID, Key: store.accountPath(walletID, listed)},
+		},
+		store.accountPath(walletID, deleted): {
+			op: &transactionOp{Op: transactionDeleteAccount, WalletID: walletID, Key: store.accountPath(walletID, deleted)},
+		},
+		store.accountPath(otherWalletID, uuid.New().String()): {
+			op: &transactionOp{Op: transactionStoreAccount, WalletID: otherWalletID},
+		},
+		store.walletHeaderPath(otherWalletID): {
+			op: &transactionOp{Op: transactionStoreWallet, WalletID: otherWalletID, Key: store.walletHeaderPath(otherWalletID)},
+		},
+		store.walletIndexPath(walletID): {
+			op: &transactionOp{Op: transactionStoreIndex, WalletID: walletID, Key: store.walletIndexPath(walletID)},
+		},
+	}
+
+	assert.Equal(t, []string{listed, stored}, committedAccountKeys([]string{listed, deleted}, walletID, writes))
+	assert.Equal(t, []string{listed}, committedAccountKeys([]string{listed}, walletID, nil))
+
+	assert.Equal(t, []string{walletID + "/", otherWalletID + "/"}, committedWalletKeys([]string{walletID + "/"}, writes))
+	assert.Equal(t, []string{otherWalletID + "/"}, committedWalletKeys([]string{otherWalletID + "/"}, writes))
+}
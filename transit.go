@@ -0,0 +1,77 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// transitEncrypt encrypts data with the named key in Vault's Transit secrets engine.
+// The returned ciphertext includes the version of the key used to encrypt it.
+func (s *Store) transitEncrypt(key string, data []byte) (string, error) {
+	path := fmt.Sprintf("%s/encrypt/%s", s.transitMount, key)
+
+	var secret *api.Secret
+	err := s.callVault("encrypt", path, func() error {
+		var err error
+		secret, err = s.client.Logical().Write(path, map[string]interface{}{
+			"plaintext": base64.StdEncoding.EncodeToString(data),
+		})
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	if secret == nil || secret.Data == nil {
+		return "", errors.New("no response from transit")
+	}
+
+	ciphertext, ok := secret.Data["ciphertext"].(string)
+	if !ok {
+		return "", errors.New("transit response missing ciphertext")
+	}
+
+	return ciphertext, nil
+}
+
+// transitDecrypt decrypts ciphertext with the named key in Vault's Transit secrets engine.
+func (s *Store) transitDecrypt(key string, ciphertext string) ([]byte, error) {
+	path := fmt.Sprintf("%s/decrypt/%s", s.transitMount, key)
+
+	var secret *api.Secret
+	err := s.callVault("decrypt", path, func() error {
+		var err error
+		secret, err = s.client.Logical().Write(path, map[string]interface{}{
+			"ciphertext": ciphertext,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, errors.New("no response from transit")
+	}
+
+	plaintext, ok := secret.Data["plaintext"].(string)
+	if !ok {
+		return nil, errors.New("transit response missing plaintext")
+	}
+
+	return base64.StdEncoding.DecodeString(plaintext)
+}
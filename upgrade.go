@@ -0,0 +1,111 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// UpgradeFormat rewrites every wallet and account held in an envelope of an earlier format in the current format,
+// keeping the encryption with which it is held.  Data encrypted with the passphrase using a cipher or key derivation
+// other than the store's is re-encrypted with the store's cipher and key derivation.  Data that is not held in an
+// envelope is left as-is.
+// Each object is upgraded under its wallet's lock, so this can be run in the background whilst the store is in use.
+// If supplied, progress is called after each object is processed; objects that are already in the current format are
+// reported as skipped.  Upgrading stops at the first failure, and can be resumed by calling UpgradeFormat again.
+func (s *Store) UpgradeFormat(progress func(*MaintenanceProgress)) (err error) {
+	defer func() { s.audit("upgrade format", "", "", "", err) }()
+
+	if err := s.Authorize(); err != nil {
+		return err
+	}
+
+	status := &MaintenanceProgress{}
+	return s.walkObjects(func(kind string, walletID uuid.UUID, key string) error {
+		upgraded, err := s.upgradeObject(walletID, key)
+		if err != nil {
+			return errors.Wrapf(err, "failed to upgrade %s %s", kind, key)
+		}
+		status.report(key, upgraded, progress)
+		return nil
+	})
+}
+
+// upgradeObject rewrites a single object in the current envelope format if required, returning true if it was
+// rewritten.
+func (s *Store) upgradeObject(walletID uuid.UUID, key string) (bool, error) {
+	unlock, err := s.lockWallet(walletID)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	data, err := s.readObject(key)
+	if err != nil {
+		return false, err
+	}
+	if data == nil {
+		return false, nil
+	}
+	env := openEnvelope(data)
+	if !needsUpgrade(env) && !s.needsReencryption(env) {
+		return false, nil
+	}
+
+	walletPassphrase, err := s.walletPassphrase(walletID)
+	if err != nil {
+		return false, err
+	}
+	plaintext, err := s.unseal(data, walletPassphrase)
+	if err != nil {
+		return false, err
+	}
+	var passphrase []byte
+	var transitKey string
+	switch env.Encryption {
+	case encryptionPassphrase:
+		passphrase = walletPassphrase
+	case encryptionTransit:
+		transitKey = env.Key
+	}
+	sealed, err := s.seal(plaintext, passphrase, transitKey)
+	if err != nil {
+		return false, err
+	}
+	if err := s.kvWrite(key, sealed); err != nil {
+		return false, err
+	}
+	s.invalidate(walletID, key)
+
+	return true, nil
+}
+
+// needsReencryption returns true if the envelope is encrypted with a passphrase using a cipher or key derivation other
+// than the store's.
+func (s *Store) needsReencryption(env *envelope) bool {
+	if env == nil || env.Encryption != encryptionPassphrase {
+		return false
+	}
+	if envelopeCipher(env) != s.sealingCipher() {
+		return true
+	}
+	// Data encrypted with go-ecodec has no separate key derivation.
+	return s.sealingCipher() != CipherECodec && envelopeKDF(env) != s.sealingKDF()
+}
+
+// needsUpgrade returns true if the envelope is of an earlier format than the current one.
+func needsUpgrade(env *envelope) bool {
+	return env != nil && env.Version < envelopeVersion
+}
@@ -0,0 +1,51 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// validatePayload checks that data is a JSON object with a name and a UUID matching id, returning a ValidationError if
+// not.  If name is supplied the data's name must also match it.
+func validatePayload(data []byte, id uuid.UUID, name string) error {
+	info := &struct {
+		UUID *string `json:"uuid"`
+		Name *string `json:"name"`
+	}{}
+	if err := json.Unmarshal(data, info); err != nil {
+		return &ValidationError{Reason: "not a JSON object"}
+	}
+	if info.UUID == nil {
+		return &ValidationError{Reason: "no uuid"}
+	}
+	payloadID, err := uuid.Parse(*info.UUID)
+	if err != nil {
+		return &ValidationError{Reason: fmt.Sprintf("invalid uuid %q", *info.UUID)}
+	}
+	if payloadID != id {
+		return &ValidationError{Reason: fmt.Sprintf("uuid %s does not match %s", payloadID, id)}
+	}
+	if info.Name == nil || *info.Name == "" {
+		return &ValidationError{Reason: "no name"}
+	}
+	if name != "" && *info.Name != name {
+		return &ValidationError{Reason: fmt.Sprintf("name %q does not match %q", *info.Name, name)}
+	}
+
+	return nil
+}
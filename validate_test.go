@@ -0,0 +1,93 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidatePayload(t *testing.T) {
+	id := uuid.MustParse("c9958061-63d4-4a80-bcf3-25f3dda22340")
+
+	tests := []struct {
+		name        string
+		data        []byte
+		payloadName string
+		err         string
+	}{
+		{
+			name: "Garbage",
+			data: []byte("garbage"),
+			err:  "invalid payload: not a JSON object",
+		},
+		{
+			name: "Array",
+			data: []byte(`["c9958061-63d4-4a80-bcf3-25f3dda22340"]`),
+			err:  "invalid payload: not a JSON object",
+		},
+		{
+			name: "NoUUID",
+			data: []byte(`{"name":"test"}`),
+			err:  "invalid payload: no uuid",
+		},
+		{
+			name: "BadUUID",
+			data: []byte(`{"uuid":"bad","name":"test"}`),
+			err:  `invalid payload: invalid uuid "bad"`,
+		},
+		{
+			name: "WrongUUID",
+			data: []byte(`{"uuid":"7603bbd4-7d0f-4b13-9d26-c2d3d58bdbf6","name":"test"}`),
+			err:  "invalid payload: uuid 7603bbd4-7d0f-4b13-9d26-c2d3d58bdbf6 does not match c9958061-63d4-4a80-bcf3-25f3dda22340",
+		},
+		{
+			name: "NoName",
+			data: []byte(`{"uuid":"c9958061-63d4-4a80-bcf3-25f3dda22340"}`),
+			err:  "invalid payload: no name",
+		},
+		{
+			name:        "WrongName",
+			data:        []byte(`{"uuid":"c9958061-63d4-4a80-bcf3-25f3dda22340","name":"test"}`),
+			payloadName: "other",
+			err:         `invalid payload: name "test" does not match "other"`,
+		},
+		{
+			name: "Good",
+			data: []byte(`{"uuid":"c9958061-63d4-4a80-bcf3-25f3dda22340","name":"test","version":1}`),
+		},
+		{
+			name:        "GoodWithName",
+			data:        []byte(`{"uuid":"C9958061-63D4-4A80-BCF3-25F3DDA22340","name":"test"}`),
+			payloadName: "test",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validatePayload(test.data, id, test.payloadName)
+			if test.err != "" {
+				if assert.NotNil(t, err) {
+					assert.Equal(t, test.err, err.Error())
+					_, isValidationError := err.(*ValidationError)
+					assert.True(t, isValidationError)
+				}
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
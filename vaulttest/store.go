@@ -0,0 +1,235 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package vaulttest provides an in-memory wallet store with the same behaviour as the Vault store, so that code using
+// the Vault store can be tested without access to Vault, and a harness for running tests against a real Vault server.
+package vaulttest
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// Store is an in-memory wallet store.  It follows the rules of the Vault store:
+//   - wallets are overwritten by StoreWallet without checking their names, so names are not forced to be unique
+//   - accounts can only be stored in wallets that exist
+//   - wallets, accounts and indexes that cannot be found return the same errors as the Vault store
+//   - wallets and accounts must be JSON objects, and indexes JSON arrays
+//   - data is returned re-encoded as it would be by Vault, with object keys sorted and whitespace removed
+//   - wallets and accounts are retrieved in ID order, matching the order in which Vault lists them
+type Store struct {
+	mutex    sync.RWMutex
+	wallets  map[uuid.UUID][]byte
+	accounts map[uuid.UUID]map[uuid.UUID][]byte
+	indexes  map[uuid.UUID][]byte
+}
+
+// New creates a new in-memory store.
+func New() *Store {
+	return &Store{
+		wallets:  make(map[uuid.UUID][]byte),
+		accounts: make(map[uuid.UUID]map[uuid.UUID][]byte),
+		indexes:  make(map[uuid.UUID][]byte),
+	}
+}
+
+// Name returns the name of this store.
+func (s *Store) Name() string {
+	return "vault"
+}
+
+// Location returns the location of this store.
+func (s *Store) Location() string {
+	return "memory"
+}
+
+// StoreWallet stores wallet-level data.
+// Note that this will overwrite any existing data; it is up to higher-level functions to check for the presence of a
+// wallet with the wallet name and handle clashes accordingly.
+func (s *Store) StoreWallet(walletID uuid.UUID, walletName string, data []byte) error {
+	data, err := normalize(data)
+	if err != nil {
+		return errors.Wrap(err, "failed to store wallet")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.wallets[walletID] = data
+
+	return nil
+}
+
+// RetrieveWallet retrieves wallet-level data by name.
+func (s *Store) RetrieveWallet(walletName string) ([]byte, error) {
+	for data := range s.RetrieveWallets() {
+		info := &struct {
+			Name string `json:"name"`
+		}{}
+		err := json.Unmarshal(data, info)
+		if err == nil && info.Name == walletName {
+			return data, nil
+		}
+	}
+	return nil, errors.New("wallet not found")
+}
+
+// RetrieveWalletByID retrieves wallet-level data by ID.
+func (s *Store) RetrieveWalletByID(walletID uuid.UUID) ([]byte, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	data, exists := s.wallets[walletID]
+	if !exists {
+		return nil, errors.New("wallet not found")
+	}
+	return copyOf(data), nil
+}
+
+// RetrieveWallets retrieves wallet-level data for all wallets.
+func (s *Store) RetrieveWallets() <-chan []byte {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return supply(s.wallets)
+}
+
+// StoreAccount stores an account.  It will fail if the wallet does not exist.
+// Note this will overwrite an existing account with the same ID.
+func (s *Store) StoreAccount(walletID uuid.UUID, accountID uuid.UUID, data []byte) error {
+	data, err := normalize(data)
+	if err != nil {
+		return errors.Wrap(err, "failed to store key")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.wallets[walletID]; !exists {
+		return errors.New("unknown wallet")
+	}
+	if _, exists := s.accounts[walletID]; !exists {
+		s.accounts[walletID] = make(map[uuid.UUID][]byte)
+	}
+	s.accounts[walletID][accountID] = data
+
+	return nil
+}
+
+// RetrieveAccount retrieves account-level data.
+func (s *Store) RetrieveAccount(walletID uuid.UUID, accountID uuid.UUID) ([]byte, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	data, exists := s.accounts[walletID][accountID]
+	if !exists {
+		return nil, errors.New("No account found for ID")
+	}
+	return copyOf(data), nil
+}
+
+// RetrieveAccounts retrieves all account-level data for a wallet.
+func (s *Store) RetrieveAccounts(walletID uuid.UUID) <-chan []byte {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return supply(s.accounts[walletID])
+}
+
+// DeleteAccount deletes an account.
+// Note that this does not update the wallet's accounts index; that is up to higher-level functions.
+func (s *Store) DeleteAccount(walletID uuid.UUID, accountID uuid.UUID) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.accounts[walletID][accountID]; !exists {
+		return errors.New("account not found")
+	}
+	delete(s.accounts[walletID], accountID)
+
+	return nil
+}
+
+// StoreAccountsIndex stores the accounts index for a wallet.
+func (s *Store) StoreAccountsIndex(walletID uuid.UUID, data []byte) error {
+	var entries []interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&entries); err != nil {
+		return err
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.indexes[walletID] = data
+
+	return nil
+}
+
+// RetrieveAccountsIndex retrieves the accounts index for a wallet.
+func (s *Store) RetrieveAccountsIndex(walletID uuid.UUID) ([]byte, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	data, exists := s.indexes[walletID]
+	if !exists {
+		return nil, errors.New("index not found")
+	}
+	return copyOf(data), nil
+}
+
+// normalize re-encodes data as Vault would return it, failing if it is not a JSON object.
+func normalize(data []byte) ([]byte, error) {
+	var object map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&object); err != nil {
+		return nil, err
+	}
+	if object == nil {
+		return nil, errors.New("data is not a JSON object")
+	}
+	return json.Marshal(object)
+}
+
+// supply returns a closed channel holding copies of the given objects, in ID order.
+func supply(objects map[uuid.UUID][]byte) <-chan []byte {
+	ids := make([]string, 0, len(objects))
+	for id := range objects {
+		ids = append(ids, id.String())
+	}
+	sort.Strings(ids)
+
+	ch := make(chan []byte, len(ids))
+	for _, id := range ids {
+		ch <- copyOf(objects[uuid.MustParse(id)])
+	}
+	close(ch)
+
+	return ch
+}
+
+// copyOf returns a copy of data, so that callers cannot change the data held by the store.
+func copyOf(data []byte) []byte {
+	res := make([]byte, len(data))
+	copy(res, data)
+	return res
+}
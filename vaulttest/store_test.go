@@ -0,0 +1,107 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaulttest
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	wtypes "github.com/wealdtech/go-eth2-wallet-types/v2"
+)
+
+var _ wtypes.Store = (*Store)(nil)
+
+func TestWallets(t *testing.T) {
+	store := New()
+
+	walletID1 := uuid.MustParse("4d3f6f2e-fbcd-4b0e-a4a7-3b2ba5ec8a3d")
+	walletID2 := uuid.MustParse("0b2a1d9e-6c47-4d4c-9c71-8b4e1a5d2f10")
+	require.Nil(t, store.StoreWallet(walletID1, "Wallet 1", []byte(`{"uuid":"4d3f6f2e-fbcd-4b0e-a4a7-3b2ba5ec8a3d", "name":"Wallet 1", "version":1}`)))
+	require.Nil(t, store.StoreWallet(walletID2, "Wallet 2", []byte(`{"uuid":"0b2a1d9e-6c47-4d4c-9c71-8b4e1a5d2f10","name":"Wallet 2"}`)))
+
+	// Data is returned as Vault would return it.
+	data, err := store.RetrieveWalletByID(walletID1)
+	require.Nil(t, err)
+	assert.Equal(t, `{"name":"Wallet 1","uuid":"4d3f6f2e-fbcd-4b0e-a4a7-3b2ba5ec8a3d","version":1}`, string(data))
+
+	data, err = store.RetrieveWallet("Wallet 2")
+	require.Nil(t, err)
+	assert.Equal(t, `{"name":"Wallet 2","uuid":"0b2a1d9e-6c47-4d4c-9c71-8b4e1a5d2f10"}`, string(data))
+
+	_, err = store.RetrieveWallet("Wallet 3")
+	assert.EqualError(t, err, "wallet not found")
+	_, err = store.RetrieveWalletByID(uuid.New())
+	assert.EqualError(t, err, "wallet not found")
+
+	// Wallets are supplied in ID order.
+	names := make([]string, 0)
+	for data := range store.RetrieveWallets() {
+		names = append(names, string(data[9:17]))
+	}
+	assert.Equal(t, []string{"Wallet 2", "Wallet 1"}, names)
+
+	assert.NotNil(t, store.StoreWallet(uuid.New(), "Bad", []byte(`[]`)))
+	assert.NotNil(t, store.StoreWallet(uuid.New(), "Bad", []byte(`null`)))
+}
+
+func TestAccounts(t *testing.T) {
+	store := New()
+
+	walletID := uuid.New()
+	accountID := uuid.MustParse("c9958061-63d4-4a80-bcf3-25f3dda22340")
+	assert.EqualError(t, store.StoreAccount(walletID, accountID, []byte(`{"name":"Account"}`)), "unknown wallet")
+
+	require.Nil(t, store.StoreWallet(walletID, "Wallet", []byte(`{"name":"Wallet"}`)))
+	require.Nil(t, store.StoreAccount(walletID, accountID, []byte(`{"name":"Account"}`)))
+
+	data, err := store.RetrieveAccount(walletID, accountID)
+	require.Nil(t, err)
+	assert.Equal(t, `{"name":"Account"}`, string(data))
+	_, err = store.RetrieveAccount(walletID, uuid.New())
+	assert.EqualError(t, err, "No account found for ID")
+
+	// Changes to returned data do not affect the store.
+	data[2] = 'N'
+	data, err = store.RetrieveAccount(walletID, accountID)
+	require.Nil(t, err)
+	assert.Equal(t, `{"name":"Account"}`, string(data))
+
+	count := 0
+	for range store.RetrieveAccounts(walletID) {
+		count++
+	}
+	assert.Equal(t, 1, count)
+
+	require.Nil(t, store.DeleteAccount(walletID, accountID))
+	assert.EqualError(t, store.DeleteAccount(walletID, accountID), "account not found")
+	_, err = store.RetrieveAccount(walletID, accountID)
+	assert.NotNil(t, err)
+}
+
+func TestAccountsIndex(t *testing.T) {
+	store := New()
+
+	walletID := uuid.New()
+	_, err := store.RetrieveAccountsIndex(walletID)
+	assert.EqualError(t, err, "index not found")
+
+	assert.NotNil(t, store.StoreAccountsIndex(walletID, []byte(`{}`)))
+	require.Nil(t, store.StoreAccountsIndex(walletID, []byte(`[{"uuid":"c9958061-63d4-4a80-bcf3-25f3dda22340", "name":"Account"}]`)))
+
+	data, err := store.RetrieveAccountsIndex(walletID)
+	require.Nil(t, err)
+	assert.Equal(t, `[{"name":"Account","uuid":"c9958061-63d4-4a80-bcf3-25f3dda22340"}]`, string(data))
+}
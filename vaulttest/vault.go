@@ -0,0 +1,141 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaulttest
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	vault "github.com/stakedllc/go-eth2-wallet-store-vault"
+)
+
+// ErrNoVault is returned by StartDevServer when no Vault server is configured and the vault binary cannot be found,
+// so that integration tests can be skipped.
+var ErrNoVault = errors.New("no Vault server available")
+
+// devServerTimeout is the time allowed for a Vault dev server to start.
+const devServerTimeout = 10 * time.Second
+
+// DevServer is a Vault server for integration tests.
+type DevServer struct {
+	// Address is the address of the Vault server.
+	Address string
+	// Token is a root token for the Vault server.
+	Token string
+	cmd   *exec.Cmd
+}
+
+// StartDevServer provides a Vault server for integration tests.  If the VAULT_ADDR and VAULT_TOKEN environment
+// variables are set the server they refer to is used, otherwise a Vault dev server is started with the vault binary,
+// which must be on the path.  The server's data is held in memory, and is lost when it is stopped.
+func StartDevServer() (*DevServer, error) {
+	if address, token := os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN"); address != "" && token != "" {
+		return &DevServer{
+			Address: address,
+			Token:   token,
+		}, nil
+	}
+
+	binary, err := exec.LookPath("vault")
+	if err != nil {
+		return nil, ErrNoVault
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find a free port")
+	}
+	listenAddress := listener.Addr().String()
+	listener.Close()
+
+	server := &DevServer{
+		Address: fmt.Sprintf("http://%s", listenAddress),
+		Token:   uuid.New().String(),
+	}
+	server.cmd = exec.Command(binary, "server", "-dev",
+		fmt.Sprintf("-dev-root-token-id=%s", server.Token),
+		fmt.Sprintf("-dev-listen-address=%s", listenAddress))
+	if err := server.cmd.Start(); err != nil {
+		return nil, errors.Wrap(err, "failed to start Vault dev server")
+	}
+
+	if err := server.waitForHealth(); err != nil {
+		server.Stop()
+		return nil, err
+	}
+
+	return server, nil
+}
+
+// waitForHealth waits for the server to report that it is initialized and unsealed.
+func (d *DevServer) waitForHealth() error {
+	client := &http.Client{Timeout: time.Second}
+	deadline := time.Now().Add(devServerTimeout)
+	for {
+		resp, err := client.Get(fmt.Sprintf("%s/v1/sys/health", d.Address))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return errors.New("timed out waiting for Vault dev server to start")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Stop stops the server, if it was started by StartDevServer.
+func (d *DevServer) Stop() error {
+	if d.cmd == nil || d.cmd.Process == nil {
+		return nil
+	}
+	if err := d.cmd.Process.Kill(); err != nil {
+		return err
+	}
+	// The process exits with an error as it has been killed.
+	d.cmd.Wait()
+	d.cmd = nil
+
+	return nil
+}
+
+// NewStore creates a store in the server, accessed with the server's root token.  Each store is given a newly-mounted
+// version 2 KV secrets engine, so that stores do not see each other's data.  Options supplied override these defaults.
+func (d *DevServer) NewStore(opts ...vault.Option) (*vault.Store, error) {
+	defaults := []vault.Option{
+		vault.WithVaultAddress(d.Address),
+		vault.WithToken(d.Token),
+		vault.WithKVMount(fmt.Sprintf("test-%s", uuid.New())),
+		vault.WithKVVersion(2),
+	}
+	store, err := vault.New(append(defaults, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	vaultStore := store.(*vault.Store)
+	if err := vaultStore.Bootstrap(0); err != nil {
+		return nil, errors.Wrap(err, "failed to provision store")
+	}
+
+	return vaultStore, nil
+}
@@ -0,0 +1,52 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaulttest
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDevServer(t *testing.T) {
+	server, err := StartDevServer()
+	if err == ErrNoVault {
+		t.Skip("no Vault server available")
+	}
+	require.Nil(t, err)
+	defer server.Stop()
+
+	store, err := server.NewStore()
+	require.Nil(t, err)
+
+	walletID := uuid.New()
+	require.Nil(t, store.StoreWallet(walletID, "Test wallet", []byte(`{"name":"Test wallet","uuid":"`+walletID.String()+`"}`)))
+	accountID := uuid.New()
+	require.Nil(t, store.StoreAccount(walletID, accountID, []byte(`{"name":"Test account","uuid":"`+accountID.String()+`"}`)))
+
+	data, err := store.RetrieveWallet("Test wallet")
+	require.Nil(t, err)
+	assert.Contains(t, string(data), walletID.String())
+	data, err = store.RetrieveAccount(walletID, accountID)
+	require.Nil(t, err)
+	assert.Contains(t, string(data), accountID.String())
+
+	// Stores do not share data.
+	other, err := server.NewStore()
+	require.Nil(t, err)
+	_, err = other.RetrieveWalletByID(walletID)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,147 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// VerifyResult is the result of verifying a single wallet, account or accounts index.
+type VerifyResult struct {
+	// Kind is the kind of the object: "wallet", "account" or "index".
+	Kind string
+	// WalletID is the ID of the wallet to which the object belongs.
+	WalletID uuid.UUID
+	// Key is the key of the object in Vault.
+	Key string
+	// Error is the reason the object failed verification, or nil if it passed.
+	Error error
+}
+
+// Verify checks that every wallet and account in the store can be read and decrypted, that its checksum matches if it
+// has one, and that it is a JSON object with a name and the ID under which it is stored.  It also checks that each
+// wallet's accounts index matches the accounts in the wallet.  It returns a result for every object; an error is only
+// returned if the store cannot be walked.
+func (s *Store) Verify(ctx context.Context) ([]*VerifyResult, error) {
+	if err := s.AuthorizeContext(ctx); err != nil {
+		return nil, err
+	}
+
+	results := make([]*VerifyResult, 0)
+	var walletID uuid.UUID
+	var accounts []*indexEntry
+	var failed map[string]bool
+	finishWallet := func() {
+		if accounts != nil {
+			results = append(results, s.verifyIndex(walletID, accounts, failed))
+		}
+	}
+	err := s.walkObjects(func(kind string, id uuid.UUID, key string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if kind == objectWallet {
+			finishWallet()
+			walletID = id
+			accounts = make([]*indexEntry, 0)
+			failed = make(map[string]bool)
+		}
+		data, err := s.verifyObject(kind, id, key)
+		results = append(results, &VerifyResult{
+			Kind:     kind,
+			WalletID: id,
+			Key:      key,
+			Error:    err,
+		})
+		if kind == objectAccount {
+			if err != nil {
+				failed[path.Base(key)] = true
+			} else {
+				accounts = append(accounts, &indexEntry{UUID: path.Base(key), Name: nameOf(data)})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	finishWallet()
+
+	return results, nil
+}
+
+// verifyObject checks a single wallet or account, returning its data if it passes.
+func (s *Store) verifyObject(kind string, walletID uuid.UUID, key string) ([]byte, error) {
+	id := walletID
+	if kind == objectAccount {
+		var err error
+		if id, err = uuid.Parse(path.Base(key)); err != nil {
+			return nil, errors.New("invalid account ID")
+		}
+	}
+
+	data, err := s.readObject(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read")
+	}
+	if data == nil {
+		return nil, errors.New("not found")
+	}
+	if data, err = s.decryptIfRequired(walletID, data); err != nil {
+		return nil, err
+	}
+	if err := validatePayload(data, id, ""); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// verifyIndex checks that a wallet's accounts index matches its accounts.  Accounts that failed verification are not
+// considered, as they have already been reported.
+func (s *Store) verifyIndex(walletID uuid.UUID, accounts []*indexEntry, failed map[string]bool) *VerifyResult {
+	result := &VerifyResult{
+		Kind:     objectIndex,
+		WalletID: walletID,
+		Key:      s.walletIndexPath(walletID.String()),
+	}
+
+	index, err := s.accountsIndex(walletID)
+	if err != nil {
+		result.Error = errors.Wrap(err, "failed to read")
+		return result
+	}
+	checked := make([]*indexEntry, 0, len(index))
+	for _, entry := range index {
+		if !failed[entry.UUID] {
+			checked = append(checked, entry)
+		}
+	}
+
+	issues := indexIssues(walletID, result.Key, accounts, checked)
+	if len(issues) > 0 {
+		details := make([]string, len(issues))
+		for i := range issues {
+			details[i] = issues[i].Detail
+		}
+		result.Error = errors.New(strings.Join(details, "; "))
+	}
+
+	return result
+}
@@ -0,0 +1,217 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// ObjectVersion describes a version of a wallet or account held in version 2 of the KV secrets engine.
+type ObjectVersion struct {
+	// Version is the version number, starting at 1.
+	Version int
+	// Created is the time at which the version was written.
+	Created time.Time
+	// Deleted is the time at which the version was deleted, or zero if it has not been deleted.
+	Deleted time.Time
+	// Destroyed is true if the version has been permanently destroyed.
+	Destroyed bool
+	// Current is true if this is the current version.
+	Current bool
+}
+
+// ListAccountVersions lists the versions of an account, oldest first.
+// This requires version 2 of the KV secrets engine.
+func (s *Store) ListAccountVersions(walletID uuid.UUID, accountID uuid.UUID) ([]*ObjectVersion, error) {
+	if s.kvVersion != 2 {
+		return nil, errors.New("versions require version 2 of the KV secrets engine")
+	}
+	if err := s.Authorize(); err != nil {
+		return nil, err
+	}
+
+	return s.kvVersions(s.accountPath(walletID.String(), accountID.String()))
+}
+
+// RetrieveWalletWithVersion retrieves wallet-level data along with its current version, for use with
+// StoreWalletAtVersion.  A version of 0 is returned if the wallet was read from a replica, which may lag the primary.
+// This requires version 2 of the KV secrets engine.
+func (s *Store) RetrieveWalletWithVersion(walletID uuid.UUID) (_ []byte, _ int, err error) {
+	defer func() { s.audit("retrieve wallet", walletID.String(), "", "", err) }()
+
+	if s.kvVersion != 2 {
+		return nil, 0, errors.New("versions require version 2 of the KV secrets engine")
+	}
+	if err := s.Authorize(); err != nil {
+		return nil, 0, err
+	}
+
+	walletData, version, err := s.kvReadVersion(s.walletHeaderPath(walletID.String()))
+	if err != nil {
+		return nil, 0, err
+	}
+	if walletData == nil {
+		return nil, 0, errors.New("wallet not found")
+	}
+
+	byteData, err := json.Marshal(walletData)
+	if err != nil {
+		return nil, 0, err
+	}
+	byteData, err = s.decryptIfRequired(walletID, byteData)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return byteData, version, nil
+}
+
+// StoreWalletAtVersion stores wallet-level data as StoreWallet, but only if the current version of the wallet is the
+// given version, as returned by RetrieveWalletWithVersion; a version of 0 only stores the wallet if it does not exist.
+// If the wallet has been written since that version the write fails with a ConflictError, and the caller can retrieve
+// the wallet again, merge its changes and retry.  This applies whether or not check-and-set is enabled for the store.
+// This requires version 2 of the KV secrets engine.
+func (s *Store) StoreWalletAtVersion(walletID uuid.UUID, name string, data []byte, version int) (err error) {
+	defer func() { s.audit("store wallet", walletID.String(), name, "", err) }()
+
+	if s.kvVersion != 2 {
+		return errors.New("versions require version 2 of the KV secrets engine")
+	}
+	if version < 0 {
+		return errors.New("version must not be negative")
+	}
+
+	return s.storeWallet(walletID, name, data, func(key string, data []byte) error {
+		newVersion, err := s.kvWriteCAS(key, data, version)
+		if err != nil {
+			return err
+		}
+		s.recordVersion(key, newVersion)
+		return nil
+	})
+}
+
+// RetrieveAccountVersion retrieves a specific version of an account.
+// This requires version 2 of the KV secrets engine.
+func (s *Store) RetrieveAccountVersion(walletID uuid.UUID, accountID uuid.UUID, version int) (_ []byte, err error) {
+	defer func() { s.audit("retrieve account version", walletID.String(), "", accountID.String(), err) }()
+
+	if s.kvVersion != 2 {
+		return nil, errors.New("versions require version 2 of the KV secrets engine")
+	}
+	if err := s.Authorize(); err != nil {
+		return nil, err
+	}
+
+	key := s.accountPath(walletID.String(), accountID.String())
+	accountData, err := s.kvReadAtVersion(key, version)
+	if err != nil {
+		return nil, err
+	}
+	if accountData == nil {
+		return nil, errors.New("account version not found")
+	}
+
+	byteData, err := json.Marshal(accountData)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.decryptIfRequired(walletID, byteData)
+}
+
+// kvReadAtVersion reads the data held at the given version of a key.  It returns nil if there is no data at the key
+// for that version, including if the version has been deleted or destroyed.
+func (s *Store) kvReadAtVersion(key string, version int) (map[string]interface{}, error) {
+	var secret *api.Secret
+	err := s.callVault("read", key, func() error {
+		var err error
+		secret, err = s.client.Logical().ReadWithData(s.kvPath("data", key), map[string][]string{
+			"version": {strconv.Itoa(version)},
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, nil
+	}
+
+	data, ok := secret.Data["data"].(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	return data, nil
+}
+
+// errNoVersions is returned by kvVersions for a key that has never been written, or has been destroyed.
+var errNoVersions = errors.New("not found")
+
+// kvVersions lists the versions of a key, oldest first.
+func (s *Store) kvVersions(key string) ([]*ObjectVersion, error) {
+	var secret *api.Secret
+	err := s.callVault("read metadata", key, func() error {
+		var err error
+		secret, err = s.client.Logical().Read(s.kvPath("metadata", key))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, errNoVersions
+	}
+
+	currentVersion := kvVersionOf(secret.Data["current_version"])
+	rawVersions, ok := secret.Data["versions"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("unexpected metadata response")
+	}
+
+	versions := make([]*ObjectVersion, 0, len(rawVersions))
+	for rawVersion, rawInfo := range rawVersions {
+		info, ok := rawInfo.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		version := &ObjectVersion{
+			Version: kvVersionOf(rawVersion),
+		}
+		version.Current = version.Version == currentVersion
+		if created, ok := info["created_time"].(string); ok {
+			version.Created, _ = time.Parse(time.RFC3339Nano, created)
+		}
+		if deleted, ok := info["deletion_time"].(string); ok && deleted != "" {
+			version.Deleted, _ = time.Parse(time.RFC3339Nano, deleted)
+		}
+		if destroyed, ok := info["destroyed"].(bool); ok {
+			version.Destroyed = destroyed
+		}
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version < versions[j].Version
+	})
+
+	return versions, nil
+}
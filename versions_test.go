@@ -0,0 +1,36 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWalletVersionRequirements(t *testing.T) {
+	store := &Store{
+		log:       nopLogger{},
+		kvVersion: 1,
+	}
+	_, _, err := store.RetrieveWalletWithVersion(uuid.New())
+	assert.EqualError(t, err, "versions require version 2 of the KV secrets engine")
+	err = store.StoreWalletAtVersion(uuid.New(), "Test", []byte(`{"name":"Test"}`), 1)
+	assert.EqualError(t, err, "versions require version 2 of the KV secrets engine")
+
+	store.kvVersion = 2
+	err = store.StoreWalletAtVersion(uuid.New(), "Test", []byte(`{"name":"Test"}`), -1)
+	assert.EqualError(t, err, "version must not be negative")
+}
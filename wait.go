@@ -0,0 +1,62 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// waitForVault polls Vault until it is reachable, initialized and unsealed, or until timeout has passed.  It returns a
+// SealedError if Vault is still sealed at the deadline.
+func (s *Store) waitForVault(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for attempt := 1; ; attempt++ {
+		err := s.vaultReady()
+		if err == nil {
+			return nil
+		}
+		wait := DefaultRetryPolicy.backoff(attempt)
+		if time.Now().Add(wait).After(deadline) {
+			return err
+		}
+		s.log.Debug("Waiting for Vault", "error", err, "wait", wait)
+		time.Sleep(wait)
+	}
+}
+
+// vaultReady returns nil if Vault is ready to serve requests.
+func (s *Store) vaultReady() error {
+	sealStatus, err := s.client.Sys().SealStatus()
+	if err != nil {
+		return errors.Wrap(err, "Vault unreachable")
+	}
+	if !sealStatus.Initialized {
+		return errors.New("Vault is not initialized")
+	}
+	if sealStatus.Sealed {
+		return &SealedError{}
+	}
+
+	health, err := s.client.Sys().Health()
+	if err != nil {
+		return errors.Wrap(err, "Vault unhealthy")
+	}
+	if health.Sealed {
+		return &SealedError{}
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+const (
+	objectWallet  = "wallet"
+	objectAccount = "account"
+)
+
+// MaintenanceProgress reports the progress of a maintenance operation that rewrites objects in the store.
+type MaintenanceProgress struct {
+	// Key is the key of the object most recently processed.
+	Key string
+	// Updated is the number of objects rewritten.
+	Updated int
+	// Skipped is the number of objects that did not need to be rewritten.
+	Skipped int
+}
+
+// report records the processing of an object and passes the updated progress to progress, if supplied.
+func (p *MaintenanceProgress) report(key string, updated bool, progress func(*MaintenanceProgress)) {
+	if updated {
+		p.Updated++
+	} else {
+		p.Skipped++
+	}
+	p.Key = key
+	if progress != nil {
+		progress(p)
+	}
+}
+
+// isAccountKey returns true if the key, as listed under a wallet, is an account.
+func isAccountKey(walletID string, key string) bool {
+	return key != "index" && key != "lock" && key != walletID
+}
+
+// walkObjects calls fn with the kind, wallet ID and key of every wallet and account held in the store.
+// Each wallet is visited before its accounts.  Walking stops at the first error returned by fn.
+func (s *Store) walkObjects(fn func(kind string, walletID uuid.UUID, key string) error) error {
+	wallets, err := s.kvList(s.walletsPath())
+	if err != nil {
+		return errors.Wrap(err, "failed to list wallets")
+	}
+
+	for _, wallet := range wallets {
+		walletID, err := uuid.Parse(strings.TrimSuffix(wallet, "/"))
+		if err != nil {
+			// Not a wallet.
+			continue
+		}
+		if err := fn(objectWallet, walletID, s.walletHeaderPath(walletID.String())); err != nil {
+			return err
+		}
+
+		accounts, err := s.kvList(s.walletPath(walletID.String()))
+		if err != nil {
+			return errors.Wrapf(err, "failed to list accounts for wallet %s", walletID)
+		}
+		for _, account := range accounts {
+			if !isAccountKey(walletID.String(), account) {
+				continue
+			}
+			if err := fn(objectAccount, walletID, s.accountPath(walletID.String(), account)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// readObject reads the data for a wallet or account as it is held in Vault, without decrypting it.
+// It returns nil if there is no data at the key.
+func (s *Store) readObject(key string) ([]byte, error) {
+	data, err := s.kvRead(key)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, nil
+	}
+
+	return json.Marshal(data)
+}
@@ -14,111 +14,245 @@
 package vault
 
 import (
+	"context"
 	"encoding/json"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
+	wtypes "github.com/wealdtech/go-eth2-wallet-types/v2"
 )
 
 // StoreWallet stores wallet-level data.  It will fail if it cannot store the data.
 // Note that this will overwrite any existing data; it is up to higher-level functions to check for the presence of a wallet with
 // the wallet name and handle clashes accordingly.
-func (s *Store) StoreWallet(id uuid.UUID, name string, data []byte) error {
+func (s *Store) StoreWallet(id uuid.UUID, name string, data []byte) (err error) {
+	defer func() { s.audit("store wallet", id.String(), name, "", err) }()
+
+	return s.storeWallet(id, name, data, s.kvWrite)
+}
+
+// storeWallet stores wallet-level data, writing it to Vault with write.
+func (s *Store) storeWallet(id uuid.UUID, name string, data []byte, write func(key string, data []byte) error) error {
+	if s.validatePayloads {
+		if err := validatePayload(data, id, name); err != nil {
+			return err
+		}
+	}
+
 	path := s.walletHeaderPath(id.String())
-	s.Authorize()
+	if err := s.Authorize(); err != nil {
+		return err
+	}
+
+	unlock, err := s.lockWallet(id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	client := s.client
-	var err error
+	encryptedData, err := s.encryptIfRequired(id, data)
+	if err != nil {
+		return err
+	}
 
-	_, err = client.Logical().WriteBytes(path, data)
+	err = write(path, encryptedData)
 
 	if err != nil {
+		if _, isConflict := err.(*ConflictError); isConflict {
+			// Ensure that the next retrieval obtains the latest version from Vault.
+			s.walletCache.remove(walletCacheKey(id))
+			return err
+		}
 		return errors.Wrap(err, "failed to store wallet")
 	}
-	return nil
+
+	s.walletCache.remove(walletCacheKey(id))
+
+	if err := s.tagObject(path, map[string]string{
+		tagWalletID:   id.String(),
+		tagWalletName: name,
+	}); err != nil {
+		return err
+	}
+
+	return s.mirror(func(secondary wtypes.Store) error {
+		return secondary.StoreWallet(id, name, data)
+	})
 }
 
 // RetrieveWallet retrieves wallet-level data.  It will fail if it cannot retrieve the data.
-func (s *Store) RetrieveWallet(walletName string) ([]byte, error) {
-	for data := range s.RetrieveWallets() {
+func (s *Store) RetrieveWallet(walletName string) (_ []byte, err error) {
+	defer func() { s.audit("retrieve wallet", "", walletName, "", err) }()
+
+	if err := s.authorizeRead(); err != nil {
+		return nil, err
+	}
+
+	// Wallets are searched directly rather than through RetrieveWallets, so that the search stops as soon as the wallet is
+	// found rather than leaving the goroutine supplying the channel blocked.
+	var found []byte
+	s.eachWallet(func(data []byte, err error) bool {
+		if err != nil {
+			// Wallets that cannot be retrieved are skipped, as with RetrieveWallets.
+			return true
+		}
 		info := &struct {
 			Name string `json:"name"`
 		}{}
-		err := json.Unmarshal(data, info)
-		if err == nil && info.Name == walletName {
-			return data, nil
+		if err := json.Unmarshal(data, info); err == nil && info.Name == walletName {
+			found = data
+			return false
 		}
+		return true
+	})
+	if found == nil {
+		return nil, errors.New("wallet not found")
 	}
-	return nil, errors.New("wallet not found")
+	return found, nil
 }
 
 // RetrieveWalletByID retrieves wallet-level data.  It will fail if it cannot retrieve the data.
-func (s *Store) RetrieveWalletByID(walletID uuid.UUID) ([]byte, error) {
-	s.Authorize()
+func (s *Store) RetrieveWalletByID(walletID uuid.UUID) (_ []byte, err error) {
+	defer func() { s.audit("retrieve wallet", walletID.String(), "", "", err) }()
 
-	client := s.client
+	return s.retrieveWalletByID(walletID)
+}
 
-	secret, err := client.Logical().Read(s.walletHeaderPath(walletID.String()))
+// retrieveWalletByID retrieves wallet-level data as per RetrieveWalletByID, without auditing the retrieval.  It is used
+// by operations that audit themselves.
+func (s *Store) retrieveWalletByID(walletID uuid.UUID) ([]byte, error) {
+	if data, exists := s.walletCache.get(walletCacheKey(walletID)); exists {
+		return data, nil
+	}
+
+	if err := s.authorizeRead(); err != nil {
+		return nil, err
+	}
+
+	walletData, err := s.readWalletHeader(walletID.String(), nil)
 
 	if err != nil {
 		return nil, err
 	}
 
-	if secret == nil {
+	if walletData == nil {
 		return nil, errors.New("wallet not found")
 	}
 
-	byteData, err := json.Marshal(secret.Data)
+	byteData, err := json.Marshal(walletData)
+
+	if err != nil {
+		return nil, err
+	}
+
+	byteData, err = s.decryptIfRequired(walletID, byteData)
 
 	if err != nil {
 		return nil, err
 	}
 
+	s.walletCache.set(walletCacheKey(walletID), byteData)
+
 	return byteData, nil
 }
 
 // RetrieveWallets retrieves wallet-level data for all wallets.
 func (s *Store) RetrieveWallets() <-chan []byte {
-	ch := make(chan []byte, 1024)
-	s.Authorize()
+	return s.RetrieveWalletsContext(context.Background())
+}
 
-	client := s.client
+// RetrieveWalletsContext retrieves wallet-level data for all wallets.  Retrieval stops and the channel is closed when ctx
+// is done, so a consumer that stops reading early should cancel ctx to release the goroutine supplying the channel.
+func (s *Store) RetrieveWalletsContext(ctx context.Context) <-chan []byte {
+	ch := make(chan []byte, s.channelBuffer)
+	if err := s.AuthorizeContext(ctx); err != nil {
+		// Errors cannot be passed through the channel; wallets are still supplied if a replica can serve them.
+		s.log.Warn("Failed to log in to Vault", "error", err)
+	}
 
-	go func() {
-		secret, err := client.Logical().List(s.walletsPath())
+	s.spawn(func() {
+		defer close(ch)
+		s.eachWallet(func(data []byte, err error) bool {
+			if err != nil {
+				// Errors are logged by eachWallet, and cannot be passed through the channel.
+				return ctx.Err() == nil
+			}
+			return s.send(ctx, ch, data)
+		})
+	})
+	return ch
+}
 
-		if err != nil || secret == nil {
-			close(ch)
-			return
-		}
+// eachWallet calls yield with the data for each wallet, or an error for each wallet that cannot be retrieved, until
+// yield returns false.  Wallets are fetched concurrently, and supplied in the order in which they arrive.
+func (s *Store) eachWallet(yield func([]byte, error) bool) {
+	wallets, err := s.kvList(s.walletsPath())
 
-		wallets, typeError := secret.Data["keys"].([]interface{})
+	if err != nil {
+		s.log.Warn("Failed to list wallets", "error", err)
+		yield(nil, errors.Wrap(err, "failed to list wallets"))
+		return
+	}
 
-		if !typeError {
-			close(ch)
-			return
-		}
+	writes, err := s.committedWrites()
+	if err != nil {
+		yield(nil, err)
+		return
+	}
+	wallets = committedWalletKeys(wallets, writes)
+
+	walletIDs := make([]string, len(wallets))
+	for i, wallet := range wallets {
+		walletIDs[i] = strings.TrimSuffix(wallet, "/")
+	}
 
-		for _, wallet := range wallets {
-			walletName := wallet.(string)
-			nameLength := len(walletName) - 1
+	fetchConcurrently(walletIDs, s.concurrency, func(walletID string) ([]byte, error) {
+		return s.fetchWallet(walletID, writes)
+	}, yield)
+}
 
-			secret, err := client.Logical().Read(s.walletHeaderPath(walletName[:nameLength]))
+// fetchWallet retrieves the wallet with the given ID from Vault, taking account of the given committed writes.  It
+// returns nil if the wallet no longer exists.
+func (s *Store) fetchWallet(walletID string, writes map[string]*committedWrite) ([]byte, error) {
+	walletData, err := s.readWalletHeader(walletID, writes)
 
-			if err != nil || secret == nil {
-				continue
-			}
+	if err != nil {
+		s.log.Warn("Skipping wallet; failed to read", "wallet", walletID, "error", err)
+		return nil, errors.Wrapf(err, "failed to read wallet %s", walletID)
+	}
+	if walletData == nil {
+		s.log.Debug("Skipping wallet; not found", "wallet", walletID)
+		return nil, nil
+	}
 
-			byteData, err := json.Marshal(secret.Data)
+	byteData, err := json.Marshal(walletData)
 
-			if err != nil {
-				continue
-			}
+	if err != nil {
+		s.log.Warn("Skipping wallet; failed to marshal", "wallet", walletID, "error", err)
+		return nil, errors.Wrapf(err, "failed to marshal wallet %s", walletID)
+	}
 
-			ch <- byteData
-		}
+	id, err := uuid.Parse(walletID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid wallet ID %s", walletID)
+	}
+	byteData, err = s.decryptIfRequired(id, byteData)
 
-		close(ch)
-	}()
-	return ch
+	if err != nil {
+		s.log.Warn("Skipping wallet; failed to decrypt", "wallet", walletID, "error", err)
+		return nil, errors.Wrapf(err, "failed to decrypt wallet %s", walletID)
+	}
+
+	return byteData, nil
+}
+
+// readWalletHeader reads the data for a wallet from Vault, response-wrapped if the store is configured to do so, taking
+// account of committed transactions as per readCommitted.
+func (s *Store) readWalletHeader(walletID string, writes map[string]*committedWrite) (map[string]interface{}, error) {
+	read := s.kvRead
+	if s.responseWrapTTL > 0 {
+		read = s.kvReadWrapped
+	}
+	return s.readCommitted(s.walletHeaderPath(walletID), writes, read)
 }
@@ -0,0 +1,244 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// AccountEventType is the type of change to an account reported by Watch.
+type AccountEventType string
+
+const (
+	// AccountAdded is reported when an account is added to a wallet.
+	AccountAdded AccountEventType = "added"
+	// AccountUpdated is reported when an account is changed.
+	AccountUpdated AccountEventType = "updated"
+	// AccountDeleted is reported when an account is removed from a wallet.
+	AccountDeleted AccountEventType = "deleted"
+)
+
+// AccountEvent is a change to an account reported by Watch.
+type AccountEvent struct {
+	Type      AccountEventType
+	WalletID  uuid.UUID
+	AccountID uuid.UUID
+	// Data is the account data for added and updated accounts.
+	Data []byte
+}
+
+// defaultWatchInterval is the default interval between polls of Vault when watching for changes.
+const defaultWatchInterval = 30 * time.Second
+
+// Watch reports changes to the accounts in a wallet, relative to the accounts present when it is called.  Vault is
+// polled at the interval set with WithWatchInterval; with version 2 of the KV secrets engine changes are detected from
+// accounts' versions, otherwise from their data.  Events are supplied until ctx is done or the store is closed, at which
+// point the channel is closed.  Failed polls, and changed accounts that cannot be retrieved, are logged and retried at
+// the next interval.
+func (s *Store) Watch(ctx context.Context, walletID uuid.UUID) (<-chan *AccountEvent, error) {
+	if err := s.AuthorizeContext(ctx); err != nil {
+		return nil, err
+	}
+
+	snapshot, err := s.accountsSnapshot(walletID)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan *AccountEvent, s.channelBuffer)
+	s.spawn(func() {
+		defer close(ch)
+		ticker := time.NewTicker(s.watchInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			case <-s.done():
+				return
+			}
+
+			if err := s.AuthorizeContext(ctx); err != nil {
+				s.log.Warn("Failed to log in to Vault", "wallet", walletID, "error", err)
+				continue
+			}
+			current, err := s.accountsSnapshot(walletID)
+			if err != nil {
+				s.log.Warn("Failed to poll accounts", "wallet", walletID, "error", err)
+				continue
+			}
+			events, failed := s.accountEvents(walletID, snapshot, current)
+			for _, event := range events {
+				select {
+				case ch <- event:
+				case <-ctx.Done():
+					return
+				case <-s.done():
+					return
+				}
+			}
+			snapshot = nextSnapshot(snapshot, current, failed)
+		}
+	})
+
+	return ch, nil
+}
+
+// accountsSnapshot returns a token for each account in a wallet that changes whenever the account changes.
+func (s *Store) accountsSnapshot(walletID uuid.UUID) (map[string]string, error) {
+	keys, err := s.kvList(s.walletPath(walletID.String()))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list accounts")
+	}
+
+	snapshot := make(map[string]string, len(keys))
+	for _, key := range keys {
+		if !isAccountKey(walletID.String(), key) {
+			continue
+		}
+		if _, err := uuid.Parse(key); err != nil {
+			continue
+		}
+		token, err := s.accountToken(s.accountPath(walletID.String(), key))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to check account %s", key)
+		}
+		if token != "" {
+			snapshot[key] = token
+		}
+	}
+
+	return snapshot, nil
+}
+
+// accountToken returns a token that changes whenever the object held at the given key changes, or an empty string if
+// the object does not exist.
+func (s *Store) accountToken(key string) (string, error) {
+	if s.kvVersion == 2 {
+		// Deleted accounts are still listed, so check the current version is live.
+		versions, err := s.kvVersions(key)
+		if err == errNoVersions {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		for _, version := range versions {
+			if version.Current && version.Deleted.IsZero() && !version.Destroyed {
+				return strconv.Itoa(version.Version), nil
+			}
+		}
+		return "", nil
+	}
+
+	data, err := s.readObject(key)
+	if err != nil || data == nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// accountEvents returns the events for the changes between two snapshots of a wallet's accounts.  Accounts that have
+// been added or updated but cannot be retrieved are logged and omitted from the events, and their keys returned.
+func (s *Store) accountEvents(walletID uuid.UUID, previous map[string]string, current map[string]string) ([]*AccountEvent, []string) {
+	added, updated, deleted := diffSnapshots(previous, current)
+
+	events := make([]*AccountEvent, 0, len(added)+len(updated)+len(deleted))
+	failed := make([]string, 0)
+	for _, change := range []struct {
+		eventType AccountEventType
+		keys      []string
+	}{
+		{AccountAdded, added},
+		{AccountUpdated, updated},
+		{AccountDeleted, deleted},
+	} {
+		for _, key := range change.keys {
+			event := &AccountEvent{
+				Type:      change.eventType,
+				WalletID:  walletID,
+				AccountID: uuid.MustParse(key),
+			}
+			// Ensure that cached copies of the account are not used.
+			s.invalidate(walletID, s.accountPath(walletID.String(), key))
+			if change.eventType != AccountDeleted {
+				data, err := s.fetchAccount(walletID, key, nil)
+				if err != nil || data == nil {
+					s.log.Warn("Failed to retrieve changed account", "wallet", walletID, "account", key, "error", err)
+					failed = append(failed, key)
+					continue
+				}
+				event.Data = data
+			}
+			events = append(events, event)
+		}
+	}
+
+	return events, failed
+}
+
+// nextSnapshot returns the snapshot against which to detect the next changes after those between previous and current
+// have been reported.  Accounts whose changes could not be reported keep their previous state, so that they are reported
+// at the next poll.
+func nextSnapshot(previous map[string]string, current map[string]string, failed []string) map[string]string {
+	next := make(map[string]string, len(current))
+	for key, token := range current {
+		next[key] = token
+	}
+	for _, key := range failed {
+		if token, exists := previous[key]; exists {
+			next[key] = token
+		} else {
+			delete(next, key)
+		}
+	}
+
+	return next
+}
+
+// diffSnapshots returns the keys added, updated and deleted between two snapshots, each in sorted order.
+func diffSnapshots(previous map[string]string, current map[string]string) ([]string, []string, []string) {
+	added := make([]string, 0)
+	updated := make([]string, 0)
+	deleted := make([]string, 0)
+	for key, token := range current {
+		previousToken, exists := previous[key]
+		switch {
+		case !exists:
+			added = append(added, key)
+		case previousToken != token:
+			updated = append(updated, key)
+		}
+	}
+	for key := range previous {
+		if _, exists := current[key]; !exists {
+			deleted = append(deleted, key)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(updated)
+	sort.Strings(deleted)
+
+	return added, updated, deleted
+}
@@ -0,0 +1,69 @@
+// Copyright 2019, 2020 Weald Technology Trading
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffSnapshots(t *testing.T) {
+	previous := map[string]string{
+		"a": "1",
+		"b": "1",
+		"c": "2",
+		"d": "1",
+	}
+	current := map[string]string{
+		"a": "1",
+		"c": "3",
+		"e": "1",
+		"d": "2",
+		"f": "1",
+	}
+
+	added, updated, deleted := diffSnapshots(previous, current)
+	assert.Equal(t, []string{"e", "f"}, added)
+	assert.Equal(t, []string{"c", "d"}, updated)
+	assert.Equal(t, []string{"b"}, deleted)
+
+	added, updated, deleted = diffSnapshots(previous, previous)
+	assert.Len(t, added, 0)
+	assert.Len(t, updated, 0)
+	assert.Len(t, deleted, 0)
+}
+
+func TestNextSnapshot(t *testing.T) {
+	previous := map[string]string{
+		"a": "1",
+		"c": "2",
+	}
+	current := map[string]string{
+		"a": "1",
+		"c": "3",
+		"e": "1",
+		"f": "1",
+	}
+
+	// Changes to c and e could not be reported, so must be seen again at the next poll.
+	next := nextSnapshot(previous, current, []string{"c", "e"})
+	assert.Equal(t, map[string]string{"a": "1", "c": "2", "f": "1"}, next)
+	added, updated, deleted := diffSnapshots(next, current)
+	assert.Equal(t, []string{"e"}, added)
+	assert.Equal(t, []string{"c"}, updated)
+	assert.Len(t, deleted, 0)
+
+	assert.Equal(t, current, nextSnapshot(previous, current, nil))
+}